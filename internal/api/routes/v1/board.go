@@ -12,12 +12,17 @@ func registerBoard(r fiber.Router) {
 	// Initialize handler
 	boardRepo := repo.NewBoardRepository(config.DB)
 	boardDataRepo := repo.NewBoardDataRepository(config.DB)
-	boardHandler := handlers.NewBoardHandler(boardRepo, boardDataRepo)
+	boardHandler := handlers.NewBoardHandler(boardRepo, boardDataRepo, hub)
 
 	// Register routes
 	r.Get("/boards", boardHandler.GetAllBoards)
 	r.Post("/boards", boardHandler.CreateBoard)
 	r.Get("/boards/:boardId", boardHandler.GetBoardByID)
+	r.Get("/boards/:boardId/shapes", boardHandler.QueryShapes)
 	r.Post("/boards/:boardId/save", boardHandler.SaveData)
+	r.Post("/boards/:boardId/diagram", boardHandler.RenderDiagram)
+	r.Post("/boards/:boardId/layout", boardHandler.ArrangeShapes)
 	r.Delete("/boards/:boardId/clear", boardHandler.ClearBoard)
+	r.Get("/boards/:boardId/generation-settings", boardHandler.GetGenerationSettings)
+	r.Put("/boards/:boardId/generation-settings", boardHandler.UpdateGenerationSettings)
 }
@@ -24,12 +24,31 @@ func registerChat(app fiber.Router) {
 
 	chatRepo := repo.NewChatRepository(config.DB)
 	chatHandler := handlers.NewChatHandler(chatRepo)
-	workflow := workflow.NewWorkflow(chatRepo)
+	boardRepo := repo.NewBoardRepository(config.DB)
+	threadRepo := repo.NewChatThreadRepository(config.DB)
+	threadHandler := handlers.NewChatThreadHandler(threadRepo)
+	boardDataRepo := repo.NewBoardDataRepository(config.DB)
+	attachmentRepo := repo.NewChatAttachmentRepository(config.DB)
+	blobStore := libraries.NewBlobStoreFromEnv()
+	attachmentHandler := handlers.NewChatAttachmentHandler(attachmentRepo, blobStore)
+	workflow := workflow.NewWorkflow(chatRepo, boardRepo, threadRepo, boardDataRepo, attachmentRepo, blobStore)
 
 	// No initialization needed - everything happens on request
 	app.Post("/chat/:boardId", workflow.TriggerChatWorkflow)
 	app.Get("/chat/:boardId", chatHandler.GetChatsByBoardId)
+	app.Get("/chat/:boardId/tool-calls", chatHandler.GetToolCalls)
+	app.Delete("/chat/:boardId/messages/:messageId", chatHandler.DeleteMessage)
+
+	// image attachments: upload first, then send their ids with the message
+	app.Post("/chat/:boardId/attachments", attachmentHandler.UploadAttachment)
+	app.Get("/chat/:boardId/attachments/:attachmentId", attachmentHandler.GetAttachment)
+
+	// threads: separate conversations on the same board
+	app.Post("/chat/:boardId/threads", threadHandler.CreateThread)
+	app.Get("/chat/:boardId/threads", threadHandler.GetThreads)
+	app.Patch("/chat/:boardId/threads/:threadId", threadHandler.UpdateThread)
+	app.Delete("/chat/:boardId/threads/:threadId", threadHandler.DeleteThread)
 	
 	// Use the Hub-based WebSocket handler
-	app.Get("/ws", libraries.WebSocketHandler(hub , workflow))
+	app.Get("/ws", libraries.WebSocketHandler(hub , workflow, workflow))
 }
\ No newline at end of file
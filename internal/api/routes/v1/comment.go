@@ -0,0 +1,21 @@
+package v1
+
+import (
+	"melina-studio-backend/internal/config"
+	"melina-studio-backend/internal/handlers"
+	"melina-studio-backend/internal/repo"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func registerComment(r fiber.Router) {
+	commentRepo := repo.NewCommentRepository(config.DB)
+	boardRepo := repo.NewBoardRepository(config.DB)
+	// comment_* events go out through the chat websocket hub
+	commentHandler := handlers.NewCommentHandler(commentRepo, boardRepo, hub)
+
+	r.Get("/boards/:boardId/comments", commentHandler.GetComments)
+	r.Post("/boards/:boardId/comments", commentHandler.CreateComment)
+	r.Patch("/boards/:boardId/comments/:commentId", commentHandler.UpdateComment)
+	r.Delete("/boards/:boardId/comments/:commentId", commentHandler.DeleteComment)
+}
@@ -0,0 +1,14 @@
+package v1
+
+import (
+	"melina-studio-backend/internal/handlers"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func registerPresence(r fiber.Router) {
+	// presence lives in the chat websocket hub
+	presenceHandler := handlers.NewPresenceHandler(hub)
+
+	r.Get("/boards/:boardId/viewers", presenceHandler.GetViewers)
+}
@@ -7,4 +7,8 @@ import (
 func RegisterRoutes(r fiber.Router) {
 	registerBoard(r)
 	registerChat(r)
+	registerSearch(r)
+	registerTemplate(r)
+	registerComment(r)
+	registerPresence(r)
 }
@@ -0,0 +1,24 @@
+package v1
+
+import (
+	"log"
+	"melina-studio-backend/internal/config"
+	"melina-studio-backend/internal/handlers"
+	llmHandlers "melina-studio-backend/internal/llm_handlers"
+	"melina-studio-backend/internal/repo"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func registerSearch(r fiber.Router) {
+	searchRepo := repo.NewSearchRepository(config.DB)
+
+	// semantic search is optional - it needs an embeddings endpoint
+	embedder, err := llmHandlers.NewEmbedderFromEnv()
+	if err != nil {
+		log.Printf("semantic search disabled: %v", err)
+	}
+	searchHandler := handlers.NewSearchHandler(searchRepo, embedder)
+
+	r.Get("/search", searchHandler.Search)
+}
@@ -0,0 +1,23 @@
+package v1
+
+import (
+	"melina-studio-backend/internal/config"
+	"melina-studio-backend/internal/handlers"
+	"melina-studio-backend/internal/repo"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func registerTemplate(r fiber.Router) {
+	templateRepo := repo.NewBoardTemplateRepository(config.DB)
+	boardRepo := repo.NewBoardRepository(config.DB)
+	boardDataRepo := repo.NewBoardDataRepository(config.DB)
+	templateHandler := handlers.NewTemplateHandler(templateRepo, boardRepo, boardDataRepo)
+
+	r.Get("/templates", templateHandler.GetTemplates)
+	r.Get("/templates/:templateId", templateHandler.GetTemplate)
+	r.Delete("/templates/:templateId", templateHandler.DeleteTemplate)
+	r.Post("/templates/:templateId/boards", templateHandler.CreateBoardFromTemplate)
+	r.Post("/boards/:boardId/template", templateHandler.SaveBoardAsTemplate)
+	r.Post("/boards/:boardId/templates/:templateId", templateHandler.InsertTemplate)
+}
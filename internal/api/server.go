@@ -37,10 +37,15 @@ func NewServer() *fiber.App {
 	})
 
 
-	ctx := context.Background()
-	_, err := gcp.NewClients(ctx)
-	if err != nil {
-		log.Fatalf("failed to init gcp clients: %v", err)
+	// Google Cloud is optional - self-hosted setups can use the Anthropic API or a local model instead
+	if os.Getenv("GCP_SERVICE_ACCOUNT_CREDENTIALS") == "" {
+		log.Println("GCP_SERVICE_ACCOUNT_CREDENTIALS not set, skipping gcp clients")
+	} else {
+		ctx := context.Background()
+		_, err := gcp.NewClients(ctx)
+		if err != nil {
+			log.Fatalf("failed to init gcp clients: %v", err)
+		}
 	}
 
 	return app
@@ -3,8 +3,10 @@ package config
 import (
 	"fmt"
 	"log"
+	"melina-studio-backend/internal/melina/layout"
 	"melina-studio-backend/internal/models"
 	"os"
+	"strings"
 	"time"
 
 	"gorm.io/driver/postgres"
@@ -47,10 +49,29 @@ func MigrateAllModels(run bool) error {
 			&models.Board{},
 			&models.BoardData{},
 			&models.Chat{},
+			&models.ChatThread{},
+			&models.ChatSummary{},
+			&models.ChatToolCall{},
+			&models.ChatAttachment{},
+			&models.SearchEmbedding{},
+			&models.BoardTemplate{},
+			&models.Comment{},
 		)
 		if err != nil {
 			return fmt.Errorf("failed to migrate database: %w", err)
 		}
+		if err := dropStaleIndexes(); err != nil {
+			return fmt.Errorf("failed to drop stale indexes: %w", err)
+		}
+		if err := createSearchIndexes(); err != nil {
+			return fmt.Errorf("failed to create search indexes: %w", err)
+		}
+		if err := createShapeIndexes(); err != nil {
+			return fmt.Errorf("failed to create shape indexes: %w", err)
+		}
+		if err := backfillShapeBounds(); err != nil {
+			return fmt.Errorf("failed to backfill shape bounds: %w", err)
+		}
 		log.Println("✅ Database migration completed")
 		return nil
 	} else {
@@ -66,3 +87,82 @@ func CloseDB() error {
 	}
 	return sqlDB.Close()
 }
+
+// dropStaleIndexes removes indexes AutoMigrate leaves behind when a model changes them
+func dropStaleIndexes() error {
+	statements := []string{
+		// summaries were unique per board before threads; now they are unique per (board, thread)
+		`DROP INDEX IF EXISTS idx_chat_summaries_board_uuid`,
+		// the btree on (board_id, min_x, min_y) could only range scan min_x, see createShapeIndexes
+		`DROP INDEX IF EXISTS idx_board_data_bbox`,
+	}
+	for _, statement := range statements {
+		if err := DB.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createSearchIndexes adds the full-text indexes used by search; the expressions
+// must match the ones in repo/search.go for Postgres to use them
+func createSearchIndexes() error {
+	statements := []string{
+		`CREATE INDEX IF NOT EXISTS idx_chats_content_fts ON chats USING GIN (to_tsvector('english', content))`,
+		`CREATE INDEX IF NOT EXISTS idx_boards_title_fts ON boards USING GIN (to_tsvector('english', title))`,
+		`CREATE INDEX IF NOT EXISTS idx_board_data_text_fts ON board_data USING GIN (to_tsvector('english', coalesce(data->>'text', ''))) WHERE type = 'text'`,
+	}
+	for _, statement := range statements {
+		if err := DB.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createShapeIndexes adds the GiST index used by bbox queries; the expression
+// must match the one in repo/board_data.go QueryShapes for Postgres to use it
+func createShapeIndexes() error {
+	return DB.Exec(`CREATE INDEX IF NOT EXISTS idx_board_data_bbox_gist ON board_data USING GIST (box(point(min_x, min_y), point(max_x, max_y))) WHERE min_x IS NOT NULL`).Error
+}
+
+// backfillShapeBounds computes the bounding boxes of shapes saved before they
+// were stored; their z index stays 0 so they keep their creation order.
+// It runs once: shapes without geometry keep NULL bounds and are not read again.
+func backfillShapeBounds() error {
+	if err := DB.Exec(`CREATE TABLE IF NOT EXISTS schema_backfills (name text PRIMARY KEY, done_at timestamptz NOT NULL DEFAULT now())`).Error; err != nil {
+		return err
+	}
+	var done bool
+	if err := DB.Raw(`SELECT EXISTS (SELECT 1 FROM schema_backfills WHERE name = 'shape_bounds')`).Scan(&done).Error; err != nil {
+		return err
+	}
+	if done {
+		return nil
+	}
+
+	var rows []models.BoardData
+	err := DB.Where("min_x IS NULL").FindInBatches(&rows, 500, func(tx *gorm.DB, batch int) error {
+		values := make([]string, 0, len(rows))
+		args := make([]interface{}, 0, len(rows)*5)
+		for i := range rows {
+			layout.SetRowBounds(&rows[i])
+			if rows[i].MinX == nil {
+				continue
+			}
+			values = append(values, "(?::uuid, ?::double precision, ?::double precision, ?::double precision, ?::double precision)")
+			args = append(args, rows[i].UUID, *rows[i].MinX, *rows[i].MinY, *rows[i].MaxX, *rows[i].MaxY)
+		}
+		if len(values) == 0 {
+			return nil
+		}
+		// one statement per batch instead of one per row
+		return DB.Exec(`UPDATE board_data AS b SET min_x = v.min_x, min_y = v.min_y, max_x = v.max_x, max_y = v.max_y
+			FROM (VALUES `+strings.Join(values, ", ")+`) AS v(uuid, min_x, min_y, max_x, max_y)
+			WHERE b.uuid = v.uuid`, args...).Error
+	}).Error
+	if err != nil {
+		return err
+	}
+	return DB.Exec(`INSERT INTO schema_backfills (name) VALUES ('shape_bounds') ON CONFLICT DO NOTHING`).Error
+}
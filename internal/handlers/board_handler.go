@@ -3,13 +3,18 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
+	"errors"
 	"log"
+	"melina-studio-backend/internal/libraries"
+	llmHandlers "melina-studio-backend/internal/llm_handlers"
+	"melina-studio-backend/internal/melina/layout"
 	"melina-studio-backend/internal/models"
 	"melina-studio-backend/internal/repo"
 	"os"
 	"path/filepath"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -18,12 +23,14 @@ import (
 type BoardHandler struct {
 	repo          repo.BoardRepoInterface
 	boardDataRepo repo.BoardDataRepoInterface
+	hub           *libraries.Hub
 }
 
-func NewBoardHandler(repo repo.BoardRepoInterface, boardDataRepo repo.BoardDataRepoInterface) *BoardHandler {
+func NewBoardHandler(repo repo.BoardRepoInterface, boardDataRepo repo.BoardDataRepoInterface, hub *libraries.Hub) *BoardHandler {
 	return &BoardHandler{
 		repo:          repo,
 		boardDataRepo: boardDataRepo,
+		hub:           hub,
 	}
 }
 
@@ -122,6 +129,7 @@ func (h *BoardHandler) SaveData(c *fiber.Ctx) error {
 	}
 
 	// Save each shape (create or update)
+	savedIds := make([]string, 0, len(shapes))
 	for _, data := range shapes {
 		err := h.boardDataRepo.SaveShapeData(boardId, &data)
 		if err != nil {
@@ -130,8 +138,20 @@ func (h *BoardHandler) SaveData(c *fiber.Ctx) error {
 				"error": "Failed to save shape data",
 			})
 		}
+		savedIds = append(savedIds, data.ID)
 	}
 
+	// connectors attached to the saved shapes follow them
+	connectors, err := layout.ReconnectBoard(h.boardDataRepo, boardId, savedIds)
+	if err != nil {
+		log.Println(err, "Error rerouting connectors")
+	}
+	connectorShapes := make([]map[string]interface{}, 0, len(connectors))
+	for _, row := range connectors {
+		connectorShapes = append(connectorShapes, layout.ShapeMap(row))
+	}
+	libraries.BroadcastShapeUpdates(h.hub, boardId.String(), connectorShapes, nil)
+
 	// Handle image file if provided
 	files := form.File["image"]
 	if len(files) > 0 {
@@ -161,7 +181,8 @@ func (h *BoardHandler) SaveData(c *fiber.Ctx) error {
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "Data saved successfully",
+		"message":    "Data saved successfully",
+		"connectors": connectorShapes,
 	})
 }
 
@@ -182,6 +203,12 @@ func (h *BoardHandler) GetBoardByID(c *fiber.Ctx) error {
 			"error": "Failed to get board",
 		})
 	}
+	if err := encodeRowPoints(c, board); err != nil {
+		log.Println(err, "Error encoding points")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to encode points",
+		})
+	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"board": board,
@@ -210,3 +237,75 @@ func (h *BoardHandler) ClearBoard(c *fiber.Ctx) error {
 		"message": "Board cleared successfully",
 	})
 }
+
+// function to get the generation settings of a board
+func (h *BoardHandler) GetGenerationSettings(c *fiber.Ctx) error {
+	boardId, err := uuid.Parse(c.Params("boardId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid board ID",
+		})
+	}
+
+	board, err := h.repo.GetBoardByID(boardId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Board not found",
+			})
+		}
+		log.Println(err, "Error getting board")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get board",
+		})
+	}
+
+	var settings models.GenerationParams
+	if len(board.GenerationSettings) > 0 {
+		if err := json.Unmarshal(board.GenerationSettings, &settings); err != nil {
+			log.Println(err, "Error decoding generation settings")
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"generation_settings": settings,
+		"effective":           llmHandlers.ResolveGenerationParams(settings),
+		"limits":              llmHandlers.GenerationLimitsFromEnv(),
+	})
+}
+
+// function to update the generation settings of a board, clamped to the admin limits
+func (h *BoardHandler) UpdateGenerationSettings(c *fiber.Ctx) error {
+	boardId, err := uuid.Parse(c.Params("boardId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid board ID",
+		})
+	}
+
+	var settings models.GenerationParams
+	if err := c.BodyParser(&settings); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	settings = llmHandlers.ClampGenerationParams(settings, llmHandlers.GenerationLimitsFromEnv())
+
+	if err := h.repo.UpdateGenerationSettings(boardId, settings); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Board not found",
+			})
+		}
+		log.Println(err, "Error updating generation settings")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update generation settings",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"generation_settings": settings,
+		"effective":           llmHandlers.ResolveGenerationParams(settings),
+		"message":             "Generation settings updated successfully",
+	})
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"melina-studio-backend/internal/libraries"
+	"melina-studio-backend/internal/models"
+	"melina-studio-backend/internal/repo"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// defaultMaxAttachmentBytes is the upload limit unless MAX_ATTACHMENT_BYTES is set
+const defaultMaxAttachmentBytes = 5 << 20
+
+// attachmentMediaTypes are the image types every provider accepts
+var attachmentMediaTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+type ChatAttachmentHandler struct {
+	attachmentRepo repo.ChatAttachmentRepoInterface
+	blobStore      libraries.BlobStore
+}
+
+func NewChatAttachmentHandler(attachmentRepo repo.ChatAttachmentRepoInterface, blobStore libraries.BlobStore) *ChatAttachmentHandler {
+	return &ChatAttachmentHandler{attachmentRepo: attachmentRepo, blobStore: blobStore}
+}
+
+func maxAttachmentBytes() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("MAX_ATTACHMENT_BYTES"), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return defaultMaxAttachmentBytes
+}
+
+// upload an image (multipart field "file") to attach to a chat message
+func (h *ChatAttachmentHandler) UploadAttachment(c *fiber.Ctx) error {
+	boardId, err := uuid.Parse(c.Params("boardId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid board ID",
+		})
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "No file provided",
+		})
+	}
+	if file.Size > maxAttachmentBytes() {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": fmt.Sprintf("File is larger than %d bytes", maxAttachmentBytes()),
+		})
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to read file",
+		})
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to read file",
+		})
+	}
+
+	// trust the content, not the file name or the client's header
+	mediaType := http.DetectContentType(data)
+	if !attachmentMediaTypes[mediaType] {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+			"error": "Only png, jpeg, gif and webp images are supported",
+		})
+	}
+
+	attachment := &models.ChatAttachment{
+		UUID:      uuid.New(),
+		BoardUUID: boardId,
+		MediaType: mediaType,
+		Filename:  file.Filename,
+		SizeBytes: int64(len(data)),
+	}
+	attachment.StorageKey = fmt.Sprintf("attachments/%s/%s", boardId, attachment.UUID)
+
+	if err := h.blobStore.Put(c.Context(), attachment.StorageKey, mediaType, data); err != nil {
+		log.Println(err, "Error storing attachment")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to store attachment",
+		})
+	}
+	if _, err := h.attachmentRepo.CreateAttachment(attachment); err != nil {
+		log.Println(err, "Error creating attachment")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create attachment",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"attachment": attachment,
+		"message":    "Attachment uploaded successfully",
+	})
+}
+
+// download an attachment
+func (h *ChatAttachmentHandler) GetAttachment(c *fiber.Ctx) error {
+	boardId, err := uuid.Parse(c.Params("boardId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid board ID",
+		})
+	}
+	attachmentId, err := uuid.Parse(c.Params("attachmentId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid attachment ID",
+		})
+	}
+
+	attachment, err := h.attachmentRepo.GetAttachment(boardId, attachmentId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Attachment not found",
+			})
+		}
+		log.Println(err, "Error getting attachment")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get attachment",
+		})
+	}
+
+	data, err := h.blobStore.Get(c.Context(), attachment.StorageKey)
+	if err != nil {
+		log.Println(err, "Error reading attachment")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to read attachment",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, attachment.MediaType)
+	return c.Status(fiber.StatusOK).Send(data)
+}
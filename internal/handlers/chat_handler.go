@@ -1,10 +1,14 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"melina-studio-backend/internal/repo"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type ChatHandler struct {
@@ -15,7 +19,8 @@ func NewChatHandler(chatRepo repo.ChatRepoInterface) *ChatHandler {
 	return &ChatHandler{chatRepo: chatRepo}
 }
 
-// get chats by board id
+// get chats by board id, one page at a time
+// query: thread_id, before / after (message id cursors), order (asc|desc), limit, fields (comma separated)
 func (h *ChatHandler) GetChatsByBoardId(c *fiber.Ctx) error {
 	boardId := c.Params("boardId")
 
@@ -26,14 +31,141 @@ func (h *ChatHandler) GetChatsByBoardId(c *fiber.Ctx) error {
 		})
 	}
 
-	chats, total, err := h.chatRepo.GetChatsByBoardId(boardIdUUID, 1, 20)
+	// chats of the board's default conversation unless ?thread_id= is given
+	threadIdUUID, err := repo.ParseThreadID(c.Query("thread_id"))
 	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid thread ID",
+		})
+	}
+
+	page := repo.ChatPage{
+		Limit: c.QueryInt("limit", 20),
+	}
+	switch c.Query("order", "asc") {
+	case "asc":
+	case "desc":
+		page.NewestFirst = true
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid order, use asc or desc",
+		})
+	}
+	if before := c.Query("before"); before != "" {
+		beforeUUID, err := uuid.Parse(before)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid before cursor",
+			})
+		}
+		page.Before = &beforeUUID
+	}
+	if after := c.Query("after"); after != "" {
+		afterUUID, err := uuid.Parse(after)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid after cursor",
+			})
+		}
+		page.After = &afterUUID
+	}
+	if fields := c.Query("fields"); fields != "" {
+		for _, field := range strings.Split(fields, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				page.Fields = append(page.Fields, field)
+			}
+		}
+	}
+
+	chats, hasMore, err := h.chatRepo.GetChatsPage(boardIdUUID, threadIdUUID, page)
+	if err != nil {
+		if errors.Is(err, repo.ErrInvalidChatField) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Cursor message not found",
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to get chats",
 		})
 	}
+
+	// the cursor for the next page in the same order: pass it as before (desc) or after (asc)
+	nextCursor := ""
+	if hasMore && len(chats) > 0 {
+		nextCursor = chats[len(chats)-1].UUID.String()
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"chats":       chats,
+		"has_more":    hasMore,
+		"next_cursor": nextCursor,
+	})
+}
+
+// get the tool calls made on a board, optionally for one assistant message (?chat_id=)
+func (h *ChatHandler) GetToolCalls(c *fiber.Ctx) error {
+	boardId := c.Params("boardId")
+
+	boardIdUUID, err := uuid.Parse(boardId)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid board ID",
+		})
+	}
+
+	chatIds := []uuid.UUID{}
+	if chatId := c.Query("chat_id"); chatId != "" {
+		chatIdUUID, err := uuid.Parse(chatId)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid chat ID",
+			})
+		}
+		chatIds = append(chatIds, chatIdUUID)
+	}
+
+	toolCalls, err := h.chatRepo.GetToolCalls(boardIdUUID, chatIds...)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get tool calls",
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"tool_calls": toolCalls,
+	})
+}
+
+// delete a single chat message; edits and regenerations go through the chat workflow
+func (h *ChatHandler) DeleteMessage(c *fiber.Ctx) error {
+	boardIdUUID, err := uuid.Parse(c.Params("boardId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid board ID",
+		})
+	}
+	messageIdUUID, err := uuid.Parse(c.Params("messageId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid message ID",
+		})
+	}
+
+	if err := h.chatRepo.DeleteChat(boardIdUUID, messageIdUUID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Message not found",
+			})
+		}
+		log.Println(err, "Error deleting message")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete message",
+		})
+	}
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"chats": chats,
-		"total": total,
+		"message": "Message deleted successfully",
 	})
-}
\ No newline at end of file
+}
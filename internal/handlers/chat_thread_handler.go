@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"melina-studio-backend/internal/models"
+	"melina-studio-backend/internal/repo"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ChatThreadHandler struct {
+	threadRepo repo.ChatThreadRepoInterface
+}
+
+func NewChatThreadHandler(threadRepo repo.ChatThreadRepoInterface) *ChatThreadHandler {
+	return &ChatThreadHandler{threadRepo: threadRepo}
+}
+
+// parseThreadParams reads the boardId and threadId route params
+func parseThreadParams(c *fiber.Ctx) (uuid.UUID, uuid.UUID, error) {
+	boardId, err := uuid.Parse(c.Params("boardId"))
+	if err != nil {
+		return uuid.Nil, uuid.Nil, errors.New("Invalid board ID")
+	}
+	threadId, err := uuid.Parse(c.Params("threadId"))
+	if err != nil {
+		return uuid.Nil, uuid.Nil, errors.New("Invalid thread ID")
+	}
+	return boardId, threadId, nil
+}
+
+// create a thread on a board
+func (h *ChatThreadHandler) CreateThread(c *fiber.Ctx) error {
+	boardId, err := uuid.Parse(c.Params("boardId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid board ID",
+		})
+	}
+
+	var dto struct {
+		Title string `json:"title"`
+	}
+	if err := c.BodyParser(&dto); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	title := strings.TrimSpace(dto.Title)
+	if title == "" {
+		title = "New conversation"
+	}
+
+	thread := &models.ChatThread{
+		BoardUUID: boardId,
+		Title:     title,
+	}
+	if _, err := h.threadRepo.CreateThread(thread); err != nil {
+		log.Println(err, "Error creating thread")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create thread",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"thread":  thread,
+		"message": "Thread created successfully",
+	})
+}
+
+// list the threads of a board; archived threads are included with ?archived=true
+func (h *ChatThreadHandler) GetThreads(c *fiber.Ctx) error {
+	boardId, err := uuid.Parse(c.Params("boardId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid board ID",
+		})
+	}
+
+	threads, err := h.threadRepo.GetThreadsByBoardId(boardId, c.QueryBool("archived", false))
+	if err != nil {
+		log.Println(err, "Error getting threads")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get threads",
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"threads": threads,
+	})
+}
+
+// rename and/or (un)archive a thread
+func (h *ChatThreadHandler) UpdateThread(c *fiber.Ctx) error {
+	boardId, threadId, err := parseThreadParams(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var dto struct {
+		Title    *string `json:"title"`
+		Archived *bool   `json:"archived"`
+	}
+	if err := c.BodyParser(&dto); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	updates := map[string]interface{}{}
+	if dto.Title != nil {
+		title := strings.TrimSpace(*dto.Title)
+		if title == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Title cannot be empty",
+			})
+		}
+		updates["title"] = title
+	}
+	if dto.Archived != nil {
+		updates["archived"] = *dto.Archived
+	}
+	if len(updates) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Nothing to update",
+		})
+	}
+
+	if err := h.threadRepo.UpdateThread(boardId, threadId, updates); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Thread not found",
+			})
+		}
+		log.Println(err, "Error updating thread")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update thread",
+		})
+	}
+
+	thread, err := h.threadRepo.GetThread(boardId, threadId)
+	if err != nil {
+		log.Println(err, "Error getting thread")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get thread",
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"thread":  thread,
+		"message": "Thread updated successfully",
+	})
+}
+
+// delete a thread and its messages
+func (h *ChatThreadHandler) DeleteThread(c *fiber.Ctx) error {
+	boardId, threadId, err := parseThreadParams(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := h.threadRepo.DeleteThread(boardId, threadId); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Thread not found",
+			})
+		}
+		log.Println(err, "Error deleting thread")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete thread",
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Thread deleted successfully",
+	})
+}
@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"melina-studio-backend/internal/libraries"
+	"melina-studio-backend/internal/models"
+	"melina-studio-backend/internal/repo"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type CommentHandler struct {
+	commentRepo repo.CommentRepoInterface
+	boardRepo   repo.BoardRepoInterface
+	hub         *libraries.Hub
+}
+
+func NewCommentHandler(commentRepo repo.CommentRepoInterface, boardRepo repo.BoardRepoInterface, hub *libraries.Hub) *CommentHandler {
+	return &CommentHandler{
+		commentRepo: commentRepo,
+		boardRepo:   boardRepo,
+		hub:         hub,
+	}
+}
+
+// parseCommentParams reads the boardId and commentId route params
+func parseCommentParams(c *fiber.Ctx) (uuid.UUID, uuid.UUID, error) {
+	boardId, err := uuid.Parse(c.Params("boardId"))
+	if err != nil {
+		return uuid.Nil, uuid.Nil, errors.New("Invalid board ID")
+	}
+	commentId, err := uuid.Parse(c.Params("commentId"))
+	if err != nil {
+		return uuid.Nil, uuid.Nil, errors.New("Invalid comment ID")
+	}
+	return boardId, commentId, nil
+}
+
+// list the comment threads of a board with their replies
+// query: resolved (true|false, both when missing), shape_id, mention
+func (h *CommentHandler) GetComments(c *fiber.Ctx) error {
+	boardId, err := uuid.Parse(c.Params("boardId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid board ID",
+		})
+	}
+
+	filter := repo.CommentFilter{
+		ShapeId: c.Query("shape_id"),
+		Mention: strings.TrimPrefix(c.Query("mention"), "@"),
+	}
+	switch c.Query("resolved") {
+	case "true":
+		resolved := true
+		filter.Resolved = &resolved
+	case "false":
+		resolved := false
+		filter.Resolved = &resolved
+	}
+
+	threads, err := h.commentRepo.GetThreads(boardId, filter)
+	if err != nil {
+		log.Println(err, "Error getting comments")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get comments",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"comments": threads,
+	})
+}
+
+// create a comment pinned to a shape or a canvas position, or a reply with parentId
+func (h *CommentHandler) CreateComment(c *fiber.Ctx) error {
+	boardId, err := uuid.Parse(c.Params("boardId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid board ID",
+		})
+	}
+
+	var dto struct {
+		Body       string   `json:"body"`
+		AuthorID   string   `json:"authorId"`
+		AuthorName string   `json:"authorName"`
+		ShapeId    string   `json:"shapeId"`
+		X          *float64 `json:"x"`
+		Y          *float64 `json:"y"`
+		ParentID   string   `json:"parentId"`
+	}
+	if err := c.BodyParser(&dto); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	body := strings.TrimSpace(dto.Body)
+	if body == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Comment body is required",
+		})
+	}
+	authorId, err := uuid.Parse(dto.AuthorID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid author id",
+		})
+	}
+
+	if _, err := h.boardRepo.GetBoardByID(boardId); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Board not found",
+			})
+		}
+		log.Println(err, "Error getting board")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get board",
+		})
+	}
+
+	mentions, _ := json.Marshal(models.ParseMentions(body))
+	comment := &models.Comment{
+		BoardUUID:  boardId,
+		AuthorID:   authorId,
+		AuthorName: dto.AuthorName,
+		Body:       body,
+		Mentions:   mentions,
+	}
+
+	if dto.ParentID != "" {
+		parentId, err := uuid.Parse(dto.ParentID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid parent comment ID",
+			})
+		}
+		parent, err := h.commentRepo.GetComment(boardId, parentId)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Parent comment not found",
+				})
+			}
+			log.Println(err, "Error getting parent comment")
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to get parent comment",
+			})
+		}
+		// threads are one level deep: a reply to a reply joins the same thread
+		threadId := parent.UUID
+		if parent.ParentUUID != nil {
+			threadId = *parent.ParentUUID
+		}
+		comment.ParentUUID = &threadId
+	} else {
+		if dto.ShapeId == "" && (dto.X == nil || dto.Y == nil) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "A comment needs a shapeId or x and y",
+			})
+		}
+		if dto.ShapeId != "" {
+			comment.ShapeId = &dto.ShapeId
+		}
+		comment.X, comment.Y = dto.X, dto.Y
+	}
+
+	if _, err := h.commentRepo.CreateComment(comment); err != nil {
+		log.Println(err, "Error creating comment")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create comment",
+		})
+	}
+	libraries.BroadcastCommentEvent(h.hub, libraries.WebSocketMessageTypeCommentCreated, boardId.String(), comment)
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"comment": comment,
+		"message": "Comment created successfully",
+	})
+}
+
+// edit the body of a comment, or resolve / unresolve its thread
+func (h *CommentHandler) UpdateComment(c *fiber.Ctx) error {
+	boardId, commentId, err := parseCommentParams(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var dto struct {
+		Body     *string `json:"body"`
+		Resolved *bool   `json:"resolved"`
+		UserID   string  `json:"userId"`
+	}
+	if err := c.BodyParser(&dto); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	comment, err := h.commentRepo.GetComment(boardId, commentId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Comment not found",
+			})
+		}
+		log.Println(err, "Error getting comment")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get comment",
+		})
+	}
+
+	if dto.Body != nil {
+		body := strings.TrimSpace(*dto.Body)
+		if body == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Comment body can't be empty",
+			})
+		}
+		mentions, _ := json.Marshal(models.ParseMentions(body))
+		if err := h.commentRepo.UpdateComment(boardId, commentId, map[string]interface{}{"body": body, "mentions": mentions}); err != nil {
+			log.Println(err, "Error updating comment")
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update comment",
+			})
+		}
+	}
+
+	// the first comment of a thread holds its resolved state
+	threadId := commentId
+	if comment.ParentUUID != nil {
+		threadId = *comment.ParentUUID
+	}
+	if dto.Resolved != nil {
+		updates := map[string]interface{}{"resolved": *dto.Resolved, "resolved_at": nil, "resolved_by": nil}
+		if *dto.Resolved {
+			updates["resolved_at"] = time.Now()
+			if resolverId, err := uuid.Parse(dto.UserID); err == nil {
+				updates["resolved_by"] = resolverId
+			}
+		}
+		if err := h.commentRepo.UpdateComment(boardId, threadId, updates); err != nil {
+			log.Println(err, "Error resolving comment")
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update comment",
+			})
+		}
+	}
+
+	updated, err := h.commentRepo.GetComment(boardId, commentId)
+	if err != nil {
+		log.Println(err, "Error getting comment")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get comment",
+		})
+	}
+	libraries.BroadcastCommentEvent(h.hub, libraries.WebSocketMessageTypeCommentUpdated, boardId.String(), updated)
+	if dto.Resolved != nil && threadId != commentId {
+		if thread, err := h.commentRepo.GetComment(boardId, threadId); err == nil {
+			libraries.BroadcastCommentEvent(h.hub, libraries.WebSocketMessageTypeCommentUpdated, boardId.String(), thread)
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"comment": updated,
+	})
+}
+
+// delete a comment; deleting the first comment of a thread deletes the thread
+func (h *CommentHandler) DeleteComment(c *fiber.Ctx) error {
+	boardId, commentId, err := parseCommentParams(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	comment, err := h.commentRepo.GetComment(boardId, commentId)
+	if err == nil {
+		err = h.commentRepo.DeleteComment(boardId, commentId)
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Comment not found",
+			})
+		}
+		log.Println(err, "Error deleting comment")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete comment",
+		})
+	}
+	libraries.BroadcastCommentEvent(h.hub, libraries.WebSocketMessageTypeCommentDeleted, boardId.String(), comment)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Comment deleted successfully",
+	})
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"melina-studio-backend/internal/libraries"
+	"melina-studio-backend/internal/melina/diagram"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// function to render a mermaid or plantuml diagram into shapes and save them to the board
+func (h *BoardHandler) RenderDiagram(c *fiber.Ctx) error {
+	boardId, err := uuid.Parse(c.Params("boardId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid board ID",
+		})
+	}
+
+	var dto struct {
+		Source string   `json:"source"`
+		X      *float64 `json:"x"`
+		Y      *float64 `json:"y"`
+	}
+	if err := c.BodyParser(&dto); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if dto.Source == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "source is required",
+		})
+	}
+	x, y := 100.0, 100.0
+	if dto.X != nil {
+		x = *dto.X
+	}
+	if dto.Y != nil {
+		y = *dto.Y
+	}
+
+	if _, err := h.repo.GetBoardByID(boardId); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Board not found",
+			})
+		}
+		log.Println(err, "Error getting board")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get board",
+		})
+	}
+
+	result, err := diagram.Render(dto.Source, x, y)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// same persistence as shapes saved by the client
+	for i := range result.Shapes {
+		if err := h.boardDataRepo.SaveShapeData(boardId, &result.Shapes[i]); err != nil {
+			log.Println(err, "Error saving diagram shape")
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save diagram shapes",
+			})
+		}
+	}
+
+	// the diagram shows up for everyone looking at the board
+	var shapeMaps []map[string]interface{}
+	if data, err := json.Marshal(result.Shapes); err == nil {
+		if err := json.Unmarshal(data, &shapeMaps); err != nil {
+			log.Println(err, "Error converting diagram shapes")
+		}
+	}
+	libraries.BroadcastShapesCreated(h.hub, boardId.String(), shapeMaps, nil)
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"kind":   result.Kind,
+		"shapes": result.Shapes,
+		"width":  result.Width,
+		"height": result.Height,
+	})
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"melina-studio-backend/internal/libraries"
+	"melina-studio-backend/internal/melina/layout"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// function to move shapes of a board with a layout operation (align, distribute, grid, tree, flow, snap)
+func (h *BoardHandler) ArrangeShapes(c *fiber.Ctx) error {
+	boardId, err := uuid.Parse(c.Params("boardId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid board ID",
+		})
+	}
+
+	var req layout.Request
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	shapes, err := h.boardDataRepo.GetBoardData(boardId)
+	if err != nil {
+		log.Println(err, "Error getting board data")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get board data",
+		})
+	}
+
+	updated, err := layout.Apply(shapes, req)
+	if err != nil {
+		if errors.Is(err, layout.ErrNothingToArrange) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "No shapes matched the selection",
+			})
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := h.boardDataRepo.UpdateShapesData(boardId, updated); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Board changed while arranging, try again",
+			})
+		}
+		log.Println(err, "Error saving arranged shapes")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save arranged shapes",
+		})
+	}
+
+	shapeUpdates := make([]map[string]interface{}, 0, len(updated))
+	for _, row := range updated {
+		shapeUpdates = append(shapeUpdates, layout.ShapeMap(row))
+	}
+	// moved shapes and rerouted connectors go to everyone looking at the board
+	libraries.BroadcastShapeUpdates(h.hub, boardId.String(), shapeUpdates, nil)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"shapes":  shapeUpdates,
+		"updated": len(shapeUpdates),
+	})
+}
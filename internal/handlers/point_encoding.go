@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"encoding/json"
+	"melina-studio-backend/internal/melina/layout"
+	"melina-studio-backend/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// clients list the point encodings they can read in this header, the response
+// names the one used; without it points stay plain arrays
+const pointEncodingHeader = "X-Point-Encoding"
+
+// encodeRowPoints replaces the points arrays of the rows with the encoding the client asked for
+func encodeRowPoints(c *fiber.Ctx, rows []models.BoardData) error {
+	enc, ok := models.ParsePointEncoding(c.Get(pointEncodingHeader))
+	if !ok {
+		return nil
+	}
+	for i := range rows {
+		data := map[string]interface{}{}
+		if err := json.Unmarshal(rows[i].Data, &data); err != nil {
+			continue
+		}
+		points := layout.Points(data)
+		if len(points) == 0 {
+			continue
+		}
+		encoded, err := models.EncodePoints(points, enc)
+		if err != nil {
+			return err
+		}
+		data["points"] = encoded
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		rows[i].Data = raw
+	}
+	c.Set(pointEncodingHeader, string(enc))
+	return nil
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"melina-studio-backend/internal/libraries"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type PresenceHandler struct {
+	hub *libraries.Hub
+}
+
+func NewPresenceHandler(hub *libraries.Hub) *PresenceHandler {
+	return &PresenceHandler{hub: hub}
+}
+
+// list who is on a board right now, Melina included while it generates
+func (h *PresenceHandler) GetViewers(c *fiber.Ctx) error {
+	boardId, err := uuid.Parse(c.Params("boardId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid board ID",
+		})
+	}
+
+	viewers := h.hub.BoardViewers(boardId.String())
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"viewers": viewers,
+		"count":   len(viewers),
+	})
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"math"
+	llmHandlers "melina-studio-backend/internal/llm_handlers"
+	"melina-studio-backend/internal/repo"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	// semanticCandidateLimit is how many recent texts semantic search compares against by default
+	semanticCandidateLimit = 500
+	// maxSemanticCandidates bounds the candidates parameter, every candidate may need embedding
+	maxSemanticCandidates = 2000
+	// embedBatchSize is how many texts are sent per embeddings request
+	embedBatchSize = 64
+	// maxSnippetLen is the length of semantic search snippets
+	maxSnippetLen = 200
+)
+
+type SearchHandler struct {
+	searchRepo repo.SearchRepoInterface
+	embedder   llmHandlers.Embedder // nil when semantic search is not configured
+}
+
+func NewSearchHandler(searchRepo repo.SearchRepoInterface, embedder llmHandlers.Embedder) *SearchHandler {
+	return &SearchHandler{searchRepo: searchRepo, embedder: embedder}
+}
+
+// search chats, board titles and text shapes of the user's boards
+// query: q, user_id, mode (fulltext|semantic), kinds (comma separated: chat,board,shape), limit,
+// candidates (semantic only: how many of the most recent texts are compared, older ones are never found)
+func (h *SearchHandler) Search(c *fiber.Ctx) error {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Query cannot be empty",
+		})
+	}
+
+	// results are limited to the boards owned by this user
+	userId, err := uuid.Parse(c.Query("user_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user id",
+		})
+	}
+
+	kinds := repo.SearchKinds
+	if value := c.Query("kinds"); value != "" {
+		kinds = []string{}
+		for _, kind := range strings.Split(value, ",") {
+			kind = strings.TrimSpace(kind)
+			if kind != repo.SearchKindChat && kind != repo.SearchKindBoard && kind != repo.SearchKindShape {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid kind: " + kind,
+				})
+			}
+			kinds = append(kinds, kind)
+		}
+	}
+
+	limit := c.QueryInt("limit", 20)
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	response := fiber.Map{}
+	var results []repo.SearchResult
+	mode := c.Query("mode", "fulltext")
+	switch mode {
+	case "fulltext":
+		results, err = h.searchRepo.FullTextSearch(userId, query, kinds, limit)
+	case "semantic":
+		if h.embedder == nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Semantic search is not configured",
+			})
+		}
+		candidates := c.QueryInt("candidates", semanticCandidateLimit)
+		if candidates <= 0 {
+			candidates = semanticCandidateLimit
+		}
+		if candidates > maxSemanticCandidates {
+			candidates = maxSemanticCandidates
+		}
+		// only the most recent texts are compared, tell the client how many
+		response["candidates"] = candidates
+		results, err = h.semanticSearch(c.Context(), userId, query, kinds, limit, candidates)
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid mode, use fulltext or semantic",
+		})
+	}
+	if err != nil {
+		log.Println(err, "Error searching")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to search",
+		})
+	}
+
+	response["mode"] = mode
+	response["results"] = results
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// semanticSearch ranks the user's candidates most recent texts by cosine similarity to the query.
+// Embeddings are cached by content hash, so only new or changed texts are embedded.
+func (h *SearchHandler) semanticSearch(ctx context.Context, userId uuid.UUID, query string, kinds []string, limit int, candidates int) ([]repo.SearchResult, error) {
+	documents, err := h.searchRepo.GetSearchDocuments(userId, kinds, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(documents))
+	for i, document := range documents {
+		hashes[i] = contentHash(document.Text)
+	}
+	model := h.embedder.Model()
+	vectors, err := h.searchRepo.GetEmbeddings(model, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	// embed the texts that are not cached yet
+	missing := []string{}
+	missingHashes := []string{}
+	for i, document := range documents {
+		if _, ok := vectors[hashes[i]]; ok {
+			continue
+		}
+		vectors[hashes[i]] = nil // a text shared by several documents is embedded once
+		missing = append(missing, document.Text)
+		missingHashes = append(missingHashes, hashes[i])
+	}
+	for start := 0; start < len(missing); start += embedBatchSize {
+		end := min(start+embedBatchSize, len(missing))
+		embedded, err := h.embedder.Embed(ctx, missing[start:end])
+		if err != nil {
+			return nil, err
+		}
+		batch := make(map[string][]float32, len(embedded))
+		for i, vector := range embedded {
+			batch[missingHashes[start+i]] = vector
+			vectors[missingHashes[start+i]] = vector
+		}
+		if err := h.searchRepo.SaveEmbeddings(model, batch); err != nil {
+			log.Println(err, "Error caching embeddings")
+		}
+	}
+
+	queryVectors, err := h.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]repo.SearchResult, 0, len(documents))
+	for i, document := range documents {
+		results = append(results, repo.SearchResult{
+			Kind:       document.Kind,
+			BoardId:    document.BoardId,
+			BoardTitle: document.BoardTitle,
+			ItemId:     document.ItemId,
+			Snippet:    snippet(document.Text),
+			Highlights: []repo.SearchHighlight{},
+			Rank:       cosineSimilarity(queryVectors[0], vectors[hashes[i]]),
+			CreatedAt:  document.CreatedAt,
+		})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Rank > results[j].Rank
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func contentHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// cosineSimilarity returns 0 for vectors of different lengths or zero vectors
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// snippet shortens a text for a search result
+func snippet(text string) string {
+	if runes := []rune(text); len(runes) > maxSnippetLen {
+		return string(runes[:maxSnippetLen]) + "…"
+	}
+	return text
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"melina-studio-backend/internal/melina/layout"
+	"melina-studio-backend/internal/models"
+	"melina-studio-backend/internal/repo"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// most shapes a single region query returns
+const maxShapeQueryLimit = 5000
+
+// function to get the shapes of a board inside a region, e.g. the client's viewport
+// query: bbox=x,y,w,h, types=rect,text, limit
+func (h *BoardHandler) QueryShapes(c *fiber.Ctx) error {
+	boardId, err := uuid.Parse(c.Params("boardId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid board ID",
+		})
+	}
+
+	query := repo.ShapeQuery{Limit: maxShapeQueryLimit}
+	if bbox := c.Query("bbox"); bbox != "" {
+		bounds, err := parseBBox(bbox)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		query.BBox = &bounds
+	}
+	if types := c.Query("types"); types != "" {
+		for _, t := range strings.Split(types, ",") {
+			query.Types = append(query.Types, models.Type(strings.TrimSpace(t)))
+		}
+	}
+	if limit := c.QueryInt("limit"); limit > 0 && limit < maxShapeQueryLimit {
+		query.Limit = limit
+	}
+
+	shapes, err := h.boardDataRepo.QueryShapes(boardId, query)
+	if err != nil {
+		log.Println(err, "Error querying shapes")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get shapes",
+		})
+	}
+	if err := encodeRowPoints(c, shapes); err != nil {
+		log.Println(err, "Error encoding points")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to encode points",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"shapes":    shapes,
+		"count":     len(shapes),
+		"truncated": len(shapes) == query.Limit,
+	})
+}
+
+// parseBBox parses "x,y,w,h"
+func parseBBox(s string) (layout.Bounds, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return layout.Bounds{}, fmt.Errorf("bbox must be x,y,w,h")
+	}
+	values := make([]float64, 4)
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return layout.Bounds{}, fmt.Errorf("bbox must be x,y,w,h")
+		}
+		values[i] = v
+	}
+	if values[2] < 0 || values[3] < 0 {
+		return layout.Bounds{}, fmt.Errorf("bbox width and height must not be negative")
+	}
+	return layout.Bounds{X: values[0], Y: values[1], W: values[2], H: values[3]}, nil
+}
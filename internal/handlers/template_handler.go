@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"melina-studio-backend/internal/melina/layout"
+	"melina-studio-backend/internal/melina/templates"
+	"melina-studio-backend/internal/models"
+	"melina-studio-backend/internal/repo"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// where a template goes on a new board
+const newBoardTemplateOrigin = 100.0
+
+type TemplateHandler struct {
+	templateRepo  repo.BoardTemplateRepoInterface
+	boardRepo     repo.BoardRepoInterface
+	boardDataRepo repo.BoardDataRepoInterface
+}
+
+func NewTemplateHandler(templateRepo repo.BoardTemplateRepoInterface, boardRepo repo.BoardRepoInterface, boardDataRepo repo.BoardDataRepoInterface) *TemplateHandler {
+	return &TemplateHandler{
+		templateRepo:  templateRepo,
+		boardRepo:     boardRepo,
+		boardDataRepo: boardDataRepo,
+	}
+}
+
+// list the built-in templates and the ones saved by a user, without their shapes
+// query: user_id
+func (h *TemplateHandler) GetTemplates(c *fiber.Ctx) error {
+	ownerId, err := uuid.Parse(c.Query("user_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user id",
+		})
+	}
+
+	list, err := templates.List(h.templateRepo, ownerId)
+	if err != nil {
+		log.Println(err, "Error getting templates")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get templates",
+		})
+	}
+	for i := range list {
+		list[i].Shapes = nil
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"templates": list,
+	})
+}
+
+// get a template with its shapes
+func (h *TemplateHandler) GetTemplate(c *fiber.Ctx) error {
+	template, status, err := h.findTemplate(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"template": template,
+	})
+}
+
+// save the shapes of a board as a new template
+func (h *TemplateHandler) SaveBoardAsTemplate(c *fiber.Ctx) error {
+	boardId, err := uuid.Parse(c.Params("boardId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid board ID",
+		})
+	}
+
+	var dto struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Thumbnail   string `json:"thumbnail"`
+		UserID      string `json:"userId"`
+	}
+	if err := c.BodyParser(&dto); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	board, err := h.boardRepo.GetBoardByID(boardId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Board not found",
+			})
+		}
+		log.Println(err, "Error getting board")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get board",
+		})
+	}
+
+	// the board owner keeps the template unless it is saved for someone else (e.g. a team)
+	ownerId := board.UserID
+	if dto.UserID != "" {
+		if ownerId, err = uuid.Parse(dto.UserID); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid user id",
+			})
+		}
+	}
+
+	rows, err := h.boardDataRepo.GetBoardData(boardId)
+	if err != nil {
+		log.Println(err, "Error getting board data")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get board data",
+		})
+	}
+	shapes, width, height, err := templates.FromBoard(rows)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	shapesJSON, err := json.Marshal(shapes)
+	if err != nil {
+		log.Println(err, "Error encoding template shapes")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save template",
+		})
+	}
+
+	title := strings.TrimSpace(dto.Title)
+	if title == "" {
+		title = board.Title
+	}
+	template := &models.BoardTemplate{
+		Title:       title,
+		Description: dto.Description,
+		OwnerID:     ownerId,
+		Thumbnail:   templateThumbnail(dto.Thumbnail, board),
+		Shapes:      shapesJSON,
+		Width:       width,
+		Height:      height,
+	}
+	if _, err := h.templateRepo.CreateTemplate(template); err != nil {
+		log.Println(err, "Error creating template")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save template",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"template": template,
+		"message":  "Template saved successfully",
+	})
+}
+
+// templateThumbnail picks the preview of a new template: the one sent, the
+// board's thumbnail or the last saved image of the board
+func templateThumbnail(thumbnail string, board *models.Board) string {
+	if thumbnail != "" {
+		return thumbnail
+	}
+	if board.Thumbnail != "" {
+		return board.Thumbnail
+	}
+	image, err := os.ReadFile("temp/images/" + board.UUUID.String() + ".png")
+	if err != nil {
+		return ""
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(image)
+}
+
+// create a new board from a template
+func (h *TemplateHandler) CreateBoardFromTemplate(c *fiber.Ctx) error {
+	var dto struct {
+		Title  string `json:"title"`
+		UserID string `json:"userId"`
+	}
+	if err := c.BodyParser(&dto); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	userID, err := uuid.Parse(dto.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user id",
+		})
+	}
+
+	template, status, err := h.findTemplate(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	shapes, err := templates.Instantiate(template, newBoardTemplateOrigin, newBoardTemplateOrigin)
+	if err != nil {
+		log.Println(err, "Error using template")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to use template",
+		})
+	}
+
+	title := strings.TrimSpace(dto.Title)
+	if title == "" {
+		title = template.Title
+	}
+	boardId, err := h.boardRepo.CreateBoardWithShapes(&models.Board{
+		Title:     title,
+		UserID:    userID,
+		Thumbnail: template.Thumbnail,
+	}, shapes)
+	if err != nil {
+		log.Println(err, "Error creating board from template")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create board",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"uuid":    boardId.String(),
+		"shapes":  len(shapes),
+		"message": "Board created successfully",
+	})
+}
+
+// insert the shapes of a template into a board, with its top left corner at x, y
+func (h *TemplateHandler) InsertTemplate(c *fiber.Ctx) error {
+	boardId, err := uuid.Parse(c.Params("boardId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid board ID",
+		})
+	}
+	var dto struct {
+		X float64 `json:"x"`
+		Y float64 `json:"y"`
+	}
+	if err := c.BodyParser(&dto); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if _, err := h.boardRepo.GetBoardByID(boardId); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Board not found",
+			})
+		}
+		log.Println(err, "Error getting board")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get board",
+		})
+	}
+
+	template, status, err := h.findTemplate(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	shapes, err := templates.Instantiate(template, dto.X, dto.Y)
+	if err != nil {
+		log.Println(err, "Error using template")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to use template",
+		})
+	}
+	if err := h.boardDataRepo.CreateShapes(boardId, shapes); err != nil {
+		log.Println(err, "Error creating template shapes")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create template shapes",
+		})
+	}
+
+	created := make([]map[string]interface{}, 0, len(shapes))
+	for _, row := range shapes {
+		created = append(created, layout.ShapeMap(row))
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"shapes": created,
+	})
+}
+
+// delete a template saved by a user; built-in templates can't be deleted
+// query: user_id
+func (h *TemplateHandler) DeleteTemplate(c *fiber.Ctx) error {
+	templateId, err := uuid.Parse(c.Params("templateId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid template ID",
+		})
+	}
+	ownerId, err := uuid.Parse(c.Query("user_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user id",
+		})
+	}
+	for _, builtIn := range templates.BuiltIns() {
+		if builtIn.UUID == templateId {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Built-in templates can't be deleted",
+			})
+		}
+	}
+
+	if err := h.templateRepo.DeleteTemplate(ownerId, templateId); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Template not found",
+			})
+		}
+		log.Println(err, "Error deleting template")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete template",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Template deleted successfully",
+	})
+}
+
+// findTemplate loads the template of the templateId route param
+// it returns the status code to answer with when it fails
+func (h *TemplateHandler) findTemplate(c *fiber.Ctx) (*models.BoardTemplate, int, error) {
+	templateId, err := uuid.Parse(c.Params("templateId"))
+	if err != nil {
+		return nil, fiber.StatusBadRequest, errors.New("Invalid template ID")
+	}
+	template, err := templates.Find(h.templateRepo, templateId)
+	if err != nil {
+		if errors.Is(err, templates.ErrTemplateNotFound) {
+			return nil, fiber.StatusNotFound, errors.New("Template not found")
+		}
+		log.Println(err, "Error getting template")
+		return nil, fiber.StatusInternalServerError, errors.New("Failed to get template")
+	}
+	return template, 0, nil
+}
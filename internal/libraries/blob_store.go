@@ -0,0 +1,104 @@
+package libraries
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// BlobStore keeps uploaded files such as chat attachments
+type BlobStore interface {
+	Put(ctx context.Context, key string, contentType string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// GCSBlobStore stores blobs in a Google Cloud Storage bucket
+type GCSBlobStore struct {
+	client *storage.Client
+	bucket string
+}
+
+func NewGCSBlobStore(client *storage.Client, bucket string) *GCSBlobStore {
+	return &GCSBlobStore{client: client, bucket: bucket}
+}
+
+func (s *GCSBlobStore) Put(ctx context.Context, key string, contentType string, data []byte) error {
+	w := s.client.Bucket(s.bucket).Object(key).NewWriter(ctx)
+	w.ContentType = contentType
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return fmt.Errorf("gcs write %s: %w", key, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("gcs close %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *GCSBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	r, err := s.client.Bucket(s.bucket).Object(key).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("gcs read %s: %w", key, err)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// LocalBlobStore stores blobs on disk, for setups without Google Cloud
+type LocalBlobStore struct {
+	dir string
+}
+
+func NewLocalBlobStore(dir string) *LocalBlobStore {
+	return &LocalBlobStore{dir: dir}
+}
+
+// path maps a key to a file inside dir; keys can't escape it
+func (s *LocalBlobStore) path(key string) (string, error) {
+	cleaned := filepath.Clean("/" + key)
+	if strings.Contains(cleaned, "..") {
+		return "", fmt.Errorf("invalid blob key: %s", key)
+	}
+	return filepath.Join(s.dir, cleaned), nil
+}
+
+func (s *LocalBlobStore) Put(ctx context.Context, key string, contentType string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func (s *LocalBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// NewBlobStoreFromEnv uses the ATTACHMENTS_BUCKET GCS bucket when the gcp clients
+// are initialized, and falls back to ATTACHMENTS_DIR (default temp/attachments) on disk
+func NewBlobStoreFromEnv() BlobStore {
+	if bucket := os.Getenv("ATTACHMENTS_BUCKET"); bucket != "" {
+		if c := GetClients(); c != nil && c.GCS != nil {
+			return NewGCSBlobStore(c.GCS, bucket)
+		}
+		fmt.Printf("[blob] ATTACHMENTS_BUCKET is set but gcp clients are not initialized, storing attachments on disk\n")
+	}
+
+	dir := os.Getenv("ATTACHMENTS_DIR")
+	if dir == "" {
+		dir = "temp/attachments"
+	}
+	return NewLocalBlobStore(dir)
+}
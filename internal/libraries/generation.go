@@ -0,0 +1,304 @@
+package libraries
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// how long a generation keeps running (and its events stay buffered) without a connected client
+	generationResumeWindow = 2 * time.Minute
+	// upper bound on buffered events per generation
+	maxGenerationEvents = 10000
+	// events copied out of the buffer per round while replaying to a resuming client
+	generationReplayChunk = 256
+)
+
+// bufferedEvent is an already-encoded websocket message with its sequence number
+type bufferedEvent struct {
+	seq  int64
+	data []byte
+}
+
+// Generation represents a single chat generation started over the websocket.
+// Every event it emits is numbered and buffered, so a client that reconnects can
+// resume from the last sequence number it saw. Its context is cancelled when the
+// client sends chat_cancel, or when nobody resumes it within generationResumeWindow.
+type Generation struct {
+	ID      string
+	BoardId string
+	// secret sent to the starting connection in chat_starting; resuming requires it
+	ResumeToken string
+
+	hub    *Hub
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// sendMu keeps events in order while they are delivered outside mu
+	sendMu sync.Mutex
+
+	mu       sync.Mutex
+	client   *Client // currently attached connection, nil while disconnected
+	events   []bufferedEvent
+	seq      int64
+	finished bool
+	expiry   *time.Timer
+}
+
+// Context returns the context that must be passed down to the LLM call and tool executions
+func (g *Generation) Context() context.Context {
+	return g.ctx
+}
+
+// Cancel stops the generation; it is safe to call more than once
+func (g *Generation) Cancel() {
+	g.cancel()
+}
+
+// Send numbers, buffers and forwards an event to the attached client (if any).
+// The event is delivered without holding mu, so a slow socket only holds up this
+// generation's events, not attaching, detaching or cancelling it.
+func (g *Generation) Send(messageType WebSocketMessageType, data interface{}) {
+	g.sendMu.Lock()
+	defer g.sendMu.Unlock()
+
+	g.mu.Lock()
+	g.seq++
+	eventBytes, err := json.Marshal(WebSocketMessage{
+		Type:         messageType,
+		Data:         data,
+		GenerationId: g.ID,
+		Seq:          g.seq,
+	})
+	if err != nil {
+		g.mu.Unlock()
+		log.Println("failed to marshal generation event:", err)
+		return
+	}
+
+	g.events = append(g.events, bufferedEvent{seq: g.seq, data: eventBytes})
+	if len(g.events) > maxGenerationEvents {
+		g.events = g.events[len(g.events)-maxGenerationEvents:]
+	}
+	client := g.client
+	g.mu.Unlock()
+
+	if client != nil {
+		g.hub.SendMessage(client, eventBytes)
+	}
+}
+
+// SendError sends a standardized error message as part of the generation
+func (g *Generation) SendError(errorMsg string) {
+	g.Send(WebSocketMessageTypeError, &ChatMessagePayload{
+		BoardId: g.BoardId,
+		Message: errorMsg,
+	})
+}
+
+// Finish marks the generation as done. Its events stay available for
+// resuming until the resume window passes.
+func (g *Generation) Finish() {
+	g.mu.Lock()
+	g.finished = true
+	if g.expiry != nil {
+		g.expiry.Stop()
+	}
+	g.expiry = time.AfterFunc(generationResumeWindow, func() {
+		g.hub.removeGeneration(g.ID)
+	})
+	g.mu.Unlock()
+
+	g.hub.leaveVirtual(g.BoardId, g.participantId())
+	// release the context
+	g.cancel()
+}
+
+// attach replays every event after lastSeq to the client and makes it the live receiver.
+// Events are replayed in chunks without holding the lock, so a slow client only holds
+// up its own replay; the client becomes the live receiver once it has caught up.
+func (g *Generation) attach(client *Client, lastSeq int64) error {
+	for {
+		g.mu.Lock()
+		if len(g.events) > 0 && lastSeq+1 < g.events[0].seq {
+			g.mu.Unlock()
+			return fmt.Errorf("events after %d are no longer available", lastSeq)
+		}
+		pending := g.eventsAfter(lastSeq, generationReplayChunk)
+		if len(pending) == 0 {
+			// caught up - nothing can be emitted between the last replayed event and this
+			g.client = client
+			if !g.finished && g.expiry != nil {
+				g.expiry.Stop()
+				g.expiry = nil
+			}
+			g.mu.Unlock()
+			return nil
+		}
+		g.mu.Unlock()
+
+		for _, ev := range pending {
+			g.hub.SendMessage(client, ev.data)
+		}
+		if client.isGone() {
+			return fmt.Errorf("client disconnected while resuming")
+		}
+		lastSeq = pending[len(pending)-1].seq
+	}
+}
+
+// eventsAfter copies up to limit buffered events with a sequence number after seq; g.mu must be held
+func (g *Generation) eventsAfter(seq int64, limit int) []bufferedEvent {
+	start := len(g.events)
+	for i, ev := range g.events {
+		if ev.seq > seq {
+			start = i
+			break
+		}
+	}
+	end := min(start+limit, len(g.events))
+	return append([]bufferedEvent(nil), g.events[start:end]...)
+}
+
+// ownedBy reports whether a resume request carries the generation's resume token
+func (g *Generation) ownedBy(resumeToken string) bool {
+	return resumeToken != "" && subtle.ConstantTimeCompare([]byte(resumeToken), []byte(g.ResumeToken)) == 1
+}
+
+// detach stops forwarding events to the client. If nobody resumes the
+// generation within the resume window, it gets cancelled.
+func (g *Generation) detach(client *Client) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.client != client {
+		return
+	}
+	g.client = nil
+
+	if g.finished {
+		return
+	}
+	g.expiry = time.AfterFunc(generationResumeWindow, func() {
+		g.mu.Lock()
+		abandoned := g.client == nil
+		g.mu.Unlock()
+		if abandoned {
+			log.Println("generation abandoned, cancelling:", g.ID)
+			g.Cancel()
+		}
+	})
+}
+
+// broadcastToBoard sends a message to the viewers of a board, except the client
+// receiving the generation, which gets it through Send
+func (g *Generation) broadcastToBoard(boardId string, messageType WebSocketMessageType, data interface{}) {
+	g.mu.Lock()
+	client := g.client
+	g.mu.Unlock()
+	g.hub.BroadcastToBoard(normalizeBoardId(boardId), messageType, data, client)
+}
+
+// isAttachedTo reports whether events are currently forwarded to the client
+func (g *Generation) isAttachedTo(client *Client) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.client == client
+}
+
+// StartGeneration registers a new generation attached to the client
+func (h *Hub) StartGeneration(client *Client, generationId string, boardId string) (*Generation, error) {
+	h.generationsMu.Lock()
+	defer h.generationsMu.Unlock()
+
+	if _, exists := h.generations[generationId]; exists {
+		return nil, fmt.Errorf("generation %s already exists", generationId)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	gen := &Generation{
+		ID:          generationId,
+		BoardId:     boardId,
+		ResumeToken: uuid.NewString(),
+		hub:         h,
+		ctx:         ctx,
+		cancel:      cancel,
+		client:      client,
+	}
+	h.generations[generationId] = gen
+
+	// Melina shows up on the board while it works
+	h.joinVirtual(&Presence{
+		ClientId:  gen.participantId(),
+		BoardId:   boardId,
+		Name:      melinaParticipantName,
+		Color:     melinaParticipantColor,
+		Selection: []string{},
+		Virtual:   true,
+		JoinedAt:  time.Now(),
+	})
+	return gen, nil
+}
+
+// participantId is the presence id of Melina while this generation runs
+func (g *Generation) participantId() string {
+	return "melina-" + g.ID
+}
+
+// getGeneration looks up a generation by id
+func (h *Hub) getGeneration(generationId string) (*Generation, bool) {
+	h.generationsMu.Lock()
+	defer h.generationsMu.Unlock()
+	gen, exists := h.generations[generationId]
+	return gen, exists
+}
+
+// removeGeneration drops a generation and its buffered events
+func (h *Hub) removeGeneration(generationId string) {
+	h.generationsMu.Lock()
+	defer h.generationsMu.Unlock()
+	delete(h.generations, generationId)
+}
+
+// CancelGeneration cancels a generation attached to the client.
+// Returns false if no such generation is attached to this client.
+func (h *Hub) CancelGeneration(client *Client, generationId string) bool {
+	gen, exists := h.getGeneration(generationId)
+	if !exists || !gen.isAttachedTo(client) {
+		return false
+	}
+	gen.Cancel()
+	return true
+}
+
+// ResumeGeneration attaches the client to a generation, replaying the events it missed.
+// Only a client holding the resume token the starting connection received may resume it.
+func (h *Hub) ResumeGeneration(client *Client, generationId string, resumeToken string, lastSeq int64) error {
+	gen, exists := h.getGeneration(generationId)
+	// unknown generations and wrong tokens look the same, so ids can't be probed
+	if !exists || !gen.ownedBy(resumeToken) {
+		return fmt.Errorf("generation %s not found or expired", generationId)
+	}
+	return gen.attach(client, lastSeq)
+}
+
+// DetachClientGenerations detaches the client from every generation it is receiving
+func (h *Hub) DetachClientGenerations(client *Client) {
+	h.generationsMu.Lock()
+	gens := make([]*Generation, 0, len(h.generations))
+	for _, gen := range h.generations {
+		gens = append(gens, gen)
+	}
+	h.generationsMu.Unlock()
+
+	for _, gen := range gens {
+		gen.detach(client)
+	}
+}
@@ -0,0 +1,310 @@
+package libraries
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// a client's cursor is fanned out at most this often, the latest move in between is sent when the interval ends
+	cursorThrottleInterval = 50 * time.Millisecond
+
+	melinaParticipantName  = "Melina"
+	melinaParticipantColor = "#7c3aed"
+)
+
+// colors given to viewers that don't pick one, the first free one on the board is used
+var presenceColors = []string{"#ef4444", "#f97316", "#eab308", "#22c55e", "#06b6d4", "#3b82f6", "#ec4899", "#14b8a6"}
+
+// Presence is a participant of a board: a connected viewer, or Melina while it generates
+type Presence struct {
+	ClientId  string    `json:"client_id"`
+	BoardId   string    `json:"board_id"`
+	UserId    string    `json:"user_id,omitempty"`
+	Name      string    `json:"name"`
+	Color     string    `json:"color"`
+	Selection []string  `json:"selection"`
+	Virtual   bool      `json:"virtual,omitempty"` // not a connection, e.g. Melina
+	JoinedAt  time.Time `json:"joined_at"`
+}
+
+// PresenceJoinPayload is sent by a client to join a board, and to the board for every participant
+type PresenceJoinPayload struct {
+	BoardId string `json:"board_id"`
+	UserId  string `json:"user_id"`
+	Name    string `json:"name"`
+	Color   string `json:"color,omitempty"`
+}
+
+// CursorMovePayload is a cursor position in board coordinates
+type CursorMovePayload struct {
+	BoardId  string  `json:"board_id"`
+	ClientId string  `json:"client_id,omitempty"` // set by the server when fanning out
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+}
+
+// SelectionChangePayload lists the shapes a participant has selected
+type SelectionChangePayload struct {
+	BoardId  string   `json:"board_id"`
+	ClientId string   `json:"client_id,omitempty"` // set by the server when fanning out
+	ShapeIds []string `json:"shape_ids"`
+}
+
+// JoinBoard makes the client a viewer of a board, leaving the board it was on.
+// The others are told about it and the client gets a presence_join for everyone already there.
+func (h *Hub) JoinBoard(client *Client, join *PresenceJoinPayload) {
+	h.LeaveBoard(client)
+
+	h.presenceMu.Lock()
+	viewers := h.boards[join.BoardId]
+	if viewers == nil {
+		viewers = map[string]*Client{}
+		h.boards[join.BoardId] = viewers
+	}
+	color := join.Color
+	if color == "" {
+		color = h.freeColor(join.BoardId, join.UserId)
+	}
+	client.presence = &Presence{
+		ClientId:  client.ID,
+		BoardId:   join.BoardId,
+		UserId:    join.UserId,
+		Name:      join.Name,
+		Color:     color,
+		Selection: []string{},
+		JoinedAt:  time.Now(),
+	}
+	viewers[client.ID] = client
+	joined := *client.presence
+	h.presenceMu.Unlock()
+
+	for _, participant := range h.BoardViewers(join.BoardId) {
+		if participant.ClientId != client.ID {
+			h.sendTo(client, WebSocketMessageTypePresenceJoin, participant)
+		}
+	}
+	h.BroadcastToBoard(join.BoardId, WebSocketMessageTypePresenceJoin, joined, client)
+}
+
+// LeaveBoard removes the client from its board and tells the others
+func (h *Hub) LeaveBoard(client *Client) {
+	h.presenceMu.Lock()
+	presence := client.presence
+	if presence == nil {
+		h.presenceMu.Unlock()
+		return
+	}
+	client.presence = nil
+	client.pendingCursor = nil
+	if client.cursorFlush != nil {
+		client.cursorFlush.Stop()
+		client.cursorFlush = nil
+	}
+	if viewers := h.boards[presence.BoardId]; viewers != nil {
+		delete(viewers, client.ID)
+		if len(viewers) == 0 {
+			delete(h.boards, presence.BoardId)
+		}
+	}
+	h.presenceMu.Unlock()
+
+	h.BroadcastToBoard(presence.BoardId, WebSocketMessageTypePresenceLeave, presence, nil)
+}
+
+// MoveCursor fans out the client's cursor to its board. Moves that come too fast
+// are held back; only the latest is sent once the throttle interval ends, so the
+// final position always arrives.
+func (h *Hub) MoveCursor(client *Client, move *CursorMovePayload) {
+	h.presenceMu.Lock()
+	if client.presence == nil {
+		h.presenceMu.Unlock()
+		return
+	}
+	if wait := cursorThrottleInterval - time.Since(client.lastCursorMove); wait > 0 {
+		client.pendingCursor = &CursorMovePayload{X: move.X, Y: move.Y}
+		if client.cursorFlush == nil {
+			client.cursorFlush = time.AfterFunc(wait, func() {
+				h.flushCursor(client)
+			})
+		}
+		h.presenceMu.Unlock()
+		return
+	}
+	client.lastCursorMove = time.Now()
+	boardId := client.presence.BoardId
+	h.presenceMu.Unlock()
+
+	h.broadcastCursor(client, boardId, move.X, move.Y)
+}
+
+// flushCursor sends the move held back by MoveCursor, if the client is still on its board
+func (h *Hub) flushCursor(client *Client) {
+	h.presenceMu.Lock()
+	client.cursorFlush = nil
+	move := client.pendingCursor
+	client.pendingCursor = nil
+	if move == nil || client.presence == nil {
+		h.presenceMu.Unlock()
+		return
+	}
+	client.lastCursorMove = time.Now()
+	boardId := client.presence.BoardId
+	h.presenceMu.Unlock()
+
+	h.broadcastCursor(client, boardId, move.X, move.Y)
+}
+
+// broadcastCursor sends the client's cursor position to the other viewers of the board.
+// Peers with a full buffer just miss it, the next position replaces it anyway.
+func (h *Hub) broadcastCursor(client *Client, boardId string, x float64, y float64) {
+	h.fanOut(boardId, WebSocketMessageTypeCursorMove, &CursorMovePayload{
+		BoardId:  boardId,
+		ClientId: client.ID,
+		X:        x,
+		Y:        y,
+	}, client, func(peer *Client, message []byte) {
+		h.trySend(peer, message)
+	})
+}
+
+// ChangeSelection stores the client's selection and fans it out; it is state, so it is never dropped
+func (h *Hub) ChangeSelection(client *Client, change *SelectionChangePayload) {
+	h.presenceMu.Lock()
+	if client.presence == nil {
+		h.presenceMu.Unlock()
+		return
+	}
+	selection := append([]string{}, change.ShapeIds...)
+	client.presence.Selection = selection
+	boardId := client.presence.BoardId
+	h.presenceMu.Unlock()
+
+	h.BroadcastToBoard(boardId, WebSocketMessageTypeSelectionChange, &SelectionChangePayload{
+		BoardId:  boardId,
+		ClientId: client.ID,
+		ShapeIds: selection,
+	}, client)
+}
+
+// BoardViewers returns the participants of a board in the order they joined
+func (h *Hub) BoardViewers(boardId string) []Presence {
+	h.presenceMu.RLock()
+	viewers := make([]Presence, 0, len(h.boards[boardId])+len(h.virtualParticipants[boardId]))
+	for _, client := range h.boards[boardId] {
+		viewers = append(viewers, *client.presence)
+	}
+	for _, participant := range h.virtualParticipants[boardId] {
+		viewers = append(viewers, *participant)
+	}
+	h.presenceMu.RUnlock()
+
+	sort.Slice(viewers, func(i, j int) bool { return viewers[i].JoinedAt.Before(viewers[j].JoinedAt) })
+	return viewers
+}
+
+// BroadcastToBoard sends a message to every viewer of a board except one (nil for none).
+// It never waits: it often runs on a viewer's read loop, which one slow peer must not stall.
+// Peers whose buffer is full are disconnected and resync when they join again.
+func (h *Hub) BroadcastToBoard(boardId string, messageType WebSocketMessageType, data interface{}, except *Client) {
+	h.fanOut(boardId, messageType, data, except, h.sendOrDisconnect)
+}
+
+// fanOut sends a message with send to every viewer of a board except one
+func (h *Hub) fanOut(boardId string, messageType WebSocketMessageType, data interface{}, except *Client, send func(*Client, []byte)) {
+	messageBytes, err := json.Marshal(WebSocketMessage{Type: messageType, Data: data})
+	if err != nil {
+		log.Println("failed to marshal board message:", err)
+		return
+	}
+
+	boardId = normalizeBoardId(boardId)
+	h.presenceMu.RLock()
+	clients := make([]*Client, 0, len(h.boards[boardId]))
+	for _, client := range h.boards[boardId] {
+		if client != except {
+			clients = append(clients, client)
+		}
+	}
+	h.presenceMu.RUnlock()
+
+	for _, client := range clients {
+		send(client, messageBytes)
+	}
+}
+
+// joinVirtual shows a participant that is not a connection, e.g. Melina while it generates
+func (h *Hub) joinVirtual(participant *Presence) {
+	participant.BoardId = normalizeBoardId(participant.BoardId)
+
+	h.presenceMu.Lock()
+	participants := h.virtualParticipants[participant.BoardId]
+	if participants == nil {
+		participants = map[string]*Presence{}
+		h.virtualParticipants[participant.BoardId] = participants
+	}
+	participants[participant.ClientId] = participant
+	h.presenceMu.Unlock()
+
+	h.BroadcastToBoard(participant.BoardId, WebSocketMessageTypePresenceJoin, participant, nil)
+}
+
+// leaveVirtual removes a participant added with joinVirtual
+func (h *Hub) leaveVirtual(boardId string, participantId string) {
+	boardId = normalizeBoardId(boardId)
+
+	h.presenceMu.Lock()
+	participant, ok := h.virtualParticipants[boardId][participantId]
+	if ok {
+		delete(h.virtualParticipants[boardId], participantId)
+		if len(h.virtualParticipants[boardId]) == 0 {
+			delete(h.virtualParticipants, boardId)
+		}
+	}
+	h.presenceMu.Unlock()
+
+	if ok {
+		h.BroadcastToBoard(boardId, WebSocketMessageTypePresenceLeave, participant, nil)
+	}
+}
+
+// normalizeBoardId returns the board id in the form viewers join with, so ids
+// sent in another case or format still reach the same board. Invalid ids are kept as is.
+func normalizeBoardId(boardId string) string {
+	if id, err := uuid.Parse(boardId); err == nil {
+		return id.String()
+	}
+	return boardId
+}
+
+// freeColor returns the first palette color no viewer of the board uses,
+// or one picked from the user id when they are all taken. presenceMu must be held.
+func (h *Hub) freeColor(boardId string, userId string) string {
+	used := map[string]bool{}
+	for _, client := range h.boards[boardId] {
+		used[client.presence.Color] = true
+	}
+	for _, color := range presenceColors {
+		if !used[color] {
+			return color
+		}
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(userId))
+	return presenceColors[hash.Sum32()%uint32(len(presenceColors))]
+}
+
+// sendTo sends one message to a client
+func (h *Hub) sendTo(client *Client, messageType WebSocketMessageType, data interface{}) {
+	messageBytes, err := json.Marshal(WebSocketMessage{Type: messageType, Data: data})
+	if err != nil {
+		log.Println("failed to marshal message:", err)
+		return
+	}
+	h.SendMessage(client, messageBytes)
+}
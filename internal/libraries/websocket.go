@@ -4,8 +4,11 @@ import (
 	"encoding/json"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"melina-studio-backend/internal/models"
+
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
@@ -23,6 +26,23 @@ const (
 	WebSocketMessageTypeChatCompleted WebSocketMessageType = "chat_completed"
 	WebsocketShapeTypeStart WebSocketMessageType = "shape_start"
 	WebSocketMessageTypeShapeCreated WebSocketMessageType = "shape_created"
+	WebSocketMessageTypeChatCancel WebSocketMessageType = "chat_cancel"
+	WebSocketMessageTypeChatCancelled WebSocketMessageType = "chat_cancelled"
+	WebSocketMessageTypeResume WebSocketMessageType = "resume"
+	WebSocketMessageTypeToolStarted WebSocketMessageType = "tool_started"
+	WebSocketMessageTypeToolFinished WebSocketMessageType = "tool_finished"
+	WebSocketMessageTypeToolFailed WebSocketMessageType = "tool_failed"
+	WebSocketMessageTypeIteration WebSocketMessageType = "iteration"
+	WebSocketMessageTypeShapesReverted WebSocketMessageType = "shapes_reverted"
+	WebSocketMessageTypeShapeUpdated WebSocketMessageType = "shape_updated"
+	WebSocketMessageTypeShapePatch WebSocketMessageType = "shape_patch"
+	WebSocketMessageTypeCommentCreated WebSocketMessageType = "comment_created"
+	WebSocketMessageTypeCommentUpdated WebSocketMessageType = "comment_updated"
+	WebSocketMessageTypeCommentDeleted WebSocketMessageType = "comment_deleted"
+	WebSocketMessageTypePresenceJoin WebSocketMessageType = "presence_join"
+	WebSocketMessageTypePresenceLeave WebSocketMessageType = "presence_leave"
+	WebSocketMessageTypeCursorMove WebSocketMessageType = "cursor_move"
+	WebSocketMessageTypeSelectionChange WebSocketMessageType = "selection_change"
 )
 
 
@@ -31,30 +51,107 @@ type Client struct {
 	Conn     *websocket.Conn
 	Send     chan []byte
 	once     sync.Once
+
+	// closed guards Send - generations may still try to send after the connection is gone
+	mu       sync.RWMutex
+	closed   bool
+	// set when the client stopped reading and is being disconnected
+	stalled atomic.Bool
+
+	// board the client is viewing, guarded by the hub's presenceMu
+	presence       *Presence
+	lastCursorMove time.Time
+	// latest move held back by the cursor throttle, sent by cursorFlush
+	pendingCursor *CursorMovePayload
+	cursorFlush   *time.Timer
+}
+
+// close closes the send channel exactly once
+func (c *Client) close() {
+	c.once.Do(func() {
+		c.mu.Lock()
+		c.closed = true
+		close(c.Send)
+		c.mu.Unlock()
+	})
+}
+
+// isGone reports whether the client disconnected or is being disconnected
+func (c *Client) isGone() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.closed || c.stalled.Load()
 }
 
+// how long a message waits for room in a client's send buffer before the client is disconnected
+const clientSendTimeout = 5 * time.Second
+
 type Hub struct {
 	Clients    map[string]*Client
 	Register   chan *Client
 	Unregister chan *Client
 	Broadcast  chan []byte
+
+	// in-flight chat generations keyed by generation id
+	generations   map[string]*Generation
+	generationsMu sync.Mutex
+
+	// viewers of each board by client id, and participants that are not connections (Melina)
+	boards              map[string]map[string]*Client
+	virtualParticipants map[string]map[string]*Presence
+	presenceMu          sync.RWMutex
 }
 
 type WebSocketMessage struct {
 	Type WebSocketMessageType `json:"type"`
 	Data interface{}          `json:"data,omitempty"`
+	// set on events that belong to a generation, used to resume after a reconnect
+	GenerationId string `json:"generation_id,omitempty"`
+	Seq          int64  `json:"seq,omitempty"`
 }
 
 type ChatMessagePayload struct {
-	BoardId string `json:"board_id,omitempty"`
-	Message string `json:"message"`
+	BoardId      string `json:"board_id,omitempty"`
+	ThreadId     string `json:"thread_id,omitempty"` // optional: the board's default conversation if empty
+	Message      string `json:"message"`
+	GenerationId string `json:"generation_id,omitempty"` // optional: generated by the server if empty
+	// optional per-request overrides, clamped to the admin limits
+	Params *models.GenerationParams `json:"params,omitempty"`
+	// optional: answer with another provider than the configured one
+	Provider string `json:"provider,omitempty"`
+	// optional: ids of uploaded images to send with the message
+	AttachmentIds []string `json:"attachment_ids,omitempty"`
+
+	// optional: replace this user message with Message and regenerate from it
+	EditMessageId string `json:"edit_message_id,omitempty"`
+	// optional: regenerate this assistant message; Message is ignored
+	RegenerateMessageId string `json:"regenerate_message_id,omitempty"`
+	// with edit or regenerate: delete the shapes created by the replaced turns
+	RevertShapes bool `json:"revert_shapes,omitempty"`
+}
+
+// ChatCancelPayload asks the server to stop an in-flight generation
+type ChatCancelPayload struct {
+	GenerationId string `json:"generation_id"`
+}
+
+// ResumePayload asks the server to replay the events of a generation after LastSeq.
+// ResumeToken is the one sent in the generation's chat_starting event.
+type ResumePayload struct {
+	GenerationId string `json:"generation_id"`
+	ResumeToken  string `json:"resume_token"`
+	LastSeq      int64  `json:"last_seq"`
 }
 
 type ChatMessageResponsePayload struct {
 	BoardId        string      `json:"board_id"`
+	ThreadId       string      `json:"thread_id,omitempty"`
+	GenerationId   string      `json:"generation_id,omitempty"`
 	Message        string      `json:"message"`
 	HumanMessageId string      `json:"human_message_id"`
 	AiMessageId    string      `json:"ai_message_id"`
+	StopReason     string      `json:"stop_reason,omitempty"` // why the generation ended, e.g. max_tokens or max_iterations
+	ResumeToken    string      `json:"resume_token,omitempty"` // chat_starting only: needed to resume the generation
 	Data           interface{} `json:"data,omitempty"`
 }
 
@@ -64,12 +161,61 @@ type ShapeCreatedPayload struct {
 	Shape   map[string]interface{} `json:"shape"`
 }
 
+// ToolActivityPayload describes a tool call for tool_started, tool_finished and tool_failed
+type ToolActivityPayload struct {
+	BoardId    string                 `json:"board_id"`
+	ToolCallId string                 `json:"tool_call_id,omitempty"`
+	ToolName   string                 `json:"tool_name"`
+	Input      map[string]interface{} `json:"input,omitempty"` // sanitized summary of the tool input
+	Index      int                    `json:"index"`           // 1-based position in the current batch
+	Total      int                    `json:"total"`
+	DurationMs int64                  `json:"duration_ms,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// ShapeUpdatedPayload carries the new data of an existing shape, e.g. after a layout operation
+type ShapeUpdatedPayload struct {
+	BoardId string                 `json:"board_id"`
+	Shape   map[string]interface{} `json:"shape"`
+}
+
+// ShapePatchPayload saves changed shapes from the client; connectors attached
+// to them are rerouted and sent back as shape_updated
+type ShapePatchPayload struct {
+	BoardId string         `json:"board_id"`
+	Shapes  []models.Shape `json:"shapes"`
+}
+
+// CommentEventPayload carries a comment that was created, edited, resolved or deleted
+type CommentEventPayload struct {
+	BoardId string      `json:"board_id"`
+	Comment interface{} `json:"comment"`
+}
+
+// ShapesRevertedPayload lists the shapes removed when turns were edited or regenerated
+type ShapesRevertedPayload struct {
+	BoardId  string   `json:"board_id"`
+	ShapeIds []string `json:"shape_ids"`
+}
+
+// IterationPayload is sent after every pass of the tool loop
+type IterationPayload struct {
+	BoardId    string `json:"board_id"`
+	Iteration  int    `json:"iteration"`
+	StopReason string `json:"stop_reason,omitempty"`
+}
+
 func NewHub() *Hub {
 	return &Hub{
 		Clients:    make(map[string]*Client),
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
 		Broadcast:  make(chan []byte),
+
+		generations: make(map[string]*Generation),
+
+		boards:              make(map[string]map[string]*Client),
+		virtualParticipants: make(map[string]map[string]*Presence),
 	}
 }
 
@@ -81,13 +227,12 @@ func (h *Hub) Run() {
 		case client := <-h.Unregister:
 			if _, exists := h.Clients[client.ID]; exists {
 				delete(h.Clients, client.ID)
-				client.once.Do(func() {
-					close(client.Send)
-				})
+				client.close()
 			}
 		case message := <-h.Broadcast:
+			// never wait for a slow client here, every register and unregister goes through this loop
 			for _, client := range h.Clients {
-				client.Send <- message
+				h.sendOrDisconnect(client, message)
 			}
 		}
 	}
@@ -97,8 +242,66 @@ func (h *Hub) BroadcastMessage(message []byte) {
 	h.Broadcast <- message
 }
 
+// SendMessage queues a message for the client, waiting while its buffer is full.
+// A client that doesn't read for clientSendTimeout is disconnected rather than
+// skipped, so it never silently misses events; generations keep buffering and
+// it can resume them after reconnecting.
 func (h *Hub) SendMessage(client *Client, message []byte) {
-	client.Send <- message
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+
+	if client.closed || client.stalled.Load() {
+		return
+	}
+	select {
+	case client.Send <- message:
+		return
+	default:
+	}
+
+	timer := time.NewTimer(clientSendTimeout)
+	defer timer.Stop()
+	select {
+	case client.Send <- message:
+	case <-timer.C:
+		client.disconnectStalled()
+	}
+}
+
+// sendOrDisconnect queues a message without waiting; a client whose buffer is full
+// is disconnected, so it resyncs on reconnect instead of missing the message silently
+func (h *Hub) sendOrDisconnect(client *Client, message []byte) {
+	if !h.trySend(client, message) {
+		client.disconnectStalled()
+	}
+}
+
+// trySend queues a message without waiting. Returns false if the client's buffer is full;
+// messages to disconnected clients are discarded.
+func (h *Hub) trySend(client *Client, message []byte) bool {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+
+	if client.closed || client.stalled.Load() {
+		return true
+	}
+	select {
+	case client.Send <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+// disconnectStalled closes the connection of a client that stopped reading.
+// The read loop fails, then leaves the board, unregisters the client and detaches its generations.
+func (c *Client) disconnectStalled() {
+	if c.stalled.CompareAndSwap(false, true) {
+		log.Println("client stopped reading, disconnecting:", c.ID)
+		if c.Conn != nil {
+			c.Conn.Close()
+		}
+	}
 }
 
 // sendErrorMessage sends a standardized error message to a client
@@ -160,23 +363,68 @@ func SendChatMessageResponse(hub *Hub, client *Client, Type WebSocketMessageType
 	time.Sleep(50 * time.Millisecond)
 }
 
-// SendShapeCreatedMessage sends a shape created message to a client
-func SendShapeCreatedMessage(hub *Hub, client *Client, boardId string, shape map[string]interface{}) {
-	shapeCreatedResp := WebSocketMessage{
-		Type: WebSocketMessageTypeShapeCreated,
-		Data: &ShapeCreatedPayload{
-			BoardId: boardId,
-			Shape:   shape,
-		},
+// SendShapeCreatedMessage sends a shape created message as part of a generation
+func SendShapeCreatedMessage(gen *Generation, boardId string, shape map[string]interface{}) {
+	gen.Send(WebSocketMessageTypeShapeCreated, &ShapeCreatedPayload{
+		BoardId: boardId,
+		Shape:   shape,
+	})
+}
+
+// SendShapeUpdatedMessage sends a shape updated message as part of a generation
+func SendShapeUpdatedMessage(gen *Generation, boardId string, shape map[string]interface{}) {
+	gen.Send(WebSocketMessageTypeShapeUpdated, &ShapeUpdatedPayload{
+		BoardId: boardId,
+		Shape:   shape,
+	})
+}
+
+// SendShapeUpdates sends shape_updated messages for shapes changed outside a generation
+// to the client that changed them and to the other viewers of the board
+func SendShapeUpdates(hub *Hub, client *Client, boardId string, shapes []map[string]interface{}) {
+	for _, shape := range shapes {
+		updateBytes, err := json.Marshal(WebSocketMessage{
+			Type: WebSocketMessageTypeShapeUpdated,
+			Data: &ShapeUpdatedPayload{BoardId: boardId, Shape: shape},
+		})
+		if err != nil {
+			log.Println("failed to marshal shape update:", err)
+			continue
+		}
+		hub.SendMessage(client, updateBytes)
 	}
-	shapeCreatedBytes, err := json.Marshal(shapeCreatedResp)
-	if err != nil {
-		log.Println("failed to marshal shape created response:", err)
-		return
+	BroadcastShapeUpdates(hub, boardId, shapes, client)
+}
+
+// BroadcastShapesCreated sends shape_created messages to every viewer of the board except one
+func BroadcastShapesCreated(hub *Hub, boardId string, shapes []map[string]interface{}, except *Client) {
+	for _, shape := range shapes {
+		hub.BroadcastToBoard(boardId, WebSocketMessageTypeShapeCreated, &ShapeCreatedPayload{BoardId: boardId, Shape: shape}, except)
+	}
+}
+
+// BroadcastShapeUpdates sends shape_updated messages to every viewer of the board except one
+func BroadcastShapeUpdates(hub *Hub, boardId string, shapes []map[string]interface{}, except *Client) {
+	for _, shape := range shapes {
+		hub.BroadcastToBoard(boardId, WebSocketMessageTypeShapeUpdated, &ShapeUpdatedPayload{BoardId: boardId, Shape: shape}, except)
 	}
-	hub.SendMessage(client, shapeCreatedBytes)
 }
 
+// BroadcastCommentEvent sends a comment_* message to every viewer of the board
+func BroadcastCommentEvent(hub *Hub, messageType WebSocketMessageType, boardId string, comment interface{}) {
+	hub.BroadcastToBoard(boardId, messageType, &CommentEventPayload{BoardId: boardId, Comment: comment}, nil)
+}
+
+// SendCommentEvent sends a comment_* message as part of a generation, e.g. when Melina
+// resolves a comment, and to the other viewers of the board like the REST handlers do
+func SendCommentEvent(gen *Generation, messageType WebSocketMessageType, boardId string, comment interface{}) {
+	payload := &CommentEventPayload{
+		BoardId: boardId,
+		Comment: comment,
+	}
+	gen.Send(messageType, payload)
+	gen.broadcastToBoard(boardId, messageType, payload)
+}
 
 // parseWebSocketMessage parses incoming websocket message and returns the message structure
 func parseWebSocketMessage(msg []byte) (*WebSocketMessage, error) {
@@ -201,6 +449,42 @@ func parseWebSocketMessage(msg []byte) (*WebSocketMessage, error) {
 				return nil, err
 			}
 			message.Data = &chatPayload
+		case WebSocketMessageTypeChatCancel:
+			var cancelPayload ChatCancelPayload
+			if err := json.Unmarshal(rawMessage.Data, &cancelPayload); err != nil {
+				return nil, err
+			}
+			message.Data = &cancelPayload
+		case WebSocketMessageTypeResume:
+			var resumePayload ResumePayload
+			if err := json.Unmarshal(rawMessage.Data, &resumePayload); err != nil {
+				return nil, err
+			}
+			message.Data = &resumePayload
+		case WebSocketMessageTypeShapePatch:
+			var patchPayload ShapePatchPayload
+			if err := json.Unmarshal(rawMessage.Data, &patchPayload); err != nil {
+				return nil, err
+			}
+			message.Data = &patchPayload
+		case WebSocketMessageTypePresenceJoin:
+			var joinPayload PresenceJoinPayload
+			if err := json.Unmarshal(rawMessage.Data, &joinPayload); err != nil {
+				return nil, err
+			}
+			message.Data = &joinPayload
+		case WebSocketMessageTypeCursorMove:
+			var cursorPayload CursorMovePayload
+			if err := json.Unmarshal(rawMessage.Data, &cursorPayload); err != nil {
+				return nil, err
+			}
+			message.Data = &cursorPayload
+		case WebSocketMessageTypeSelectionChange:
+			var selectionPayload SelectionChangePayload
+			if err := json.Unmarshal(rawMessage.Data, &selectionPayload); err != nil {
+				return nil, err
+			}
+			message.Data = &selectionPayload
 		case WebSocketMessageTypeShapeCreated:
 			var shapePayload ShapeCreatedPayload
 			if err := json.Unmarshal(rawMessage.Data, &shapePayload); err != nil {
@@ -221,11 +505,18 @@ func parseWebSocketMessage(msg []byte) (*WebSocketMessage, error) {
 }
 
 // ChatMessageProcessor defines an interface for processing chat messages
+// every event of the answer must be sent through gen so it can be resumed
 type ChatMessageProcessor interface {
-	ProcessChatMessage(hub *Hub, client *Client, boardId string, message *ChatMessagePayload)
+	ProcessChatMessage(gen *Generation, message *ChatMessagePayload)
 }
 
-func WebSocketHandler(hub *Hub, processor ChatMessageProcessor) fiber.Handler {
+// ShapePatchProcessor saves shape changes sent over the websocket and returns
+// the other shapes that changed because of them (e.g. attached connectors)
+type ShapePatchProcessor interface {
+	ProcessShapePatch(patch *ShapePatchPayload) ([]map[string]interface{}, error)
+}
+
+func WebSocketHandler(hub *Hub, processor ChatMessageProcessor, shapeProcessor ShapePatchProcessor) fiber.Handler {
 	return websocket.New(func(conn *websocket.Conn) {
 		client := &Client{
 			ID:   uuid.NewString(),
@@ -256,9 +547,7 @@ func WebSocketHandler(hub *Hub, processor ChatMessageProcessor) fiber.Handler {
 				log.Println("read error:", err)
 				break
 			}
-			log.Println("received:", string(msg))
 
-			
 			// Parse message using standard interface
 			message, err := parseWebSocketMessage(msg)
 			if err != nil {
@@ -266,6 +555,10 @@ func WebSocketHandler(hub *Hub, processor ChatMessageProcessor) fiber.Handler {
 				SendErrorMessage(hub, client, "Invalid JSON format")
 				continue
 			}
+			// cursors move many times a second, keep them out of the log
+			if message.Type != WebSocketMessageTypeCursorMove {
+				log.Println("received:", string(msg))
+			}
 			
 			// Handle ping messages
 			if message.Type == WebSocketMessageTypePing {
@@ -287,8 +580,80 @@ func WebSocketHandler(hub *Hub, processor ChatMessageProcessor) fiber.Handler {
 					SendErrorMessage(hub, client, "Board ID is required")
 					continue
 				}
+				// register the generation so it can be cancelled later
+				generationId := chatPayload.GenerationId
+				if generationId == "" {
+					generationId = uuid.NewString()
+				}
+				gen, err := hub.StartGeneration(client, generationId, boardId)
+				if err != nil {
+					SendErrorMessage(hub, client, err.Error())
+					continue
+				}
 				// send the chat message to the processor
-				go processor.ProcessChatMessage(hub, client,boardId, chatPayload)
+				go processor.ProcessChatMessage(gen, chatPayload)
+			} else if message.Type == WebSocketMessageTypeChatCancel {
+				cancelPayload, ok := message.Data.(*ChatCancelPayload)
+				if !ok || cancelPayload.GenerationId == "" {
+					SendErrorMessage(hub, client, "Generation ID is required")
+					continue
+				}
+				if !hub.CancelGeneration(client, cancelPayload.GenerationId) {
+					SendErrorMessage(hub, client, "Generation not found")
+					continue
+				}
+			} else if message.Type == WebSocketMessageTypeResume {
+				resumePayload, ok := message.Data.(*ResumePayload)
+				if !ok || resumePayload.GenerationId == "" {
+					SendErrorMessage(hub, client, "Generation ID is required")
+					continue
+				}
+				if err := hub.ResumeGeneration(client, resumePayload.GenerationId, resumePayload.ResumeToken, resumePayload.LastSeq); err != nil {
+					SendErrorMessage(hub, client, err.Error())
+					continue
+				}
+			} else if message.Type == WebSocketMessageTypeShapePatch {
+				patchPayload, ok := message.Data.(*ShapePatchPayload)
+				if !ok || patchPayload.BoardId == "" {
+					SendErrorMessage(hub, client, "Board ID is required")
+					continue
+				}
+				updates, err := shapeProcessor.ProcessShapePatch(patchPayload)
+				if err != nil {
+					SendErrorMessage(hub, client, err.Error())
+					continue
+				}
+				SendShapeUpdates(hub, client, patchPayload.BoardId, updates)
+			} else if message.Type == WebSocketMessageTypePresenceJoin {
+				joinPayload, ok := message.Data.(*PresenceJoinPayload)
+				if !ok || joinPayload.BoardId == "" {
+					SendErrorMessage(hub, client, "Board ID is required")
+					continue
+				}
+				boardId, err := uuid.Parse(joinPayload.BoardId)
+				if err != nil {
+					SendErrorMessage(hub, client, "Invalid board ID")
+					continue
+				}
+				// the same form as the viewers endpoint and comment events use
+				joinPayload.BoardId = boardId.String()
+				hub.JoinBoard(client, joinPayload)
+			} else if message.Type == WebSocketMessageTypePresenceLeave {
+				hub.LeaveBoard(client)
+			} else if message.Type == WebSocketMessageTypeCursorMove {
+				cursorPayload, ok := message.Data.(*CursorMovePayload)
+				if !ok {
+					SendErrorMessage(hub, client, "Cursor position is required")
+					continue
+				}
+				hub.MoveCursor(client, cursorPayload)
+			} else if message.Type == WebSocketMessageTypeSelectionChange {
+				selectionPayload, ok := message.Data.(*SelectionChangePayload)
+				if !ok {
+					SendErrorMessage(hub, client, "Selection is required")
+					continue
+				}
+				hub.ChangeSelection(client, selectionPayload)
 			} else {
 				//  return error that type is invalid or not provided
 				SendErrorMessage(hub, client, "Type is invalid or not provided")
@@ -296,6 +661,10 @@ func WebSocketHandler(hub *Hub, processor ChatMessageProcessor) fiber.Handler {
 			}
 		}
 
+		// tell the board here rather than in Run, so slow viewers never hold up the hub
+		hub.LeaveBoard(client)
+		// keep generations running for a while so the client can resume them after reconnecting
+		hub.DetachClientGenerations(client)
 		hub.Unregister <- client
 		conn.Close()
 	})
@@ -4,18 +4,12 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"melina-studio-backend/internal/libraries"
 	"melina-studio-backend/internal/models"
 	"net/http"
-	"os"
 	"strings"
 	"time"
-
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 )
 
 // ClaudeResponse contains the parsed response from Claude
@@ -72,6 +66,7 @@ type streamDelta struct {
 	Text        string `json:"text"`         // for text_delta
 	Delta       string `json:"delta"`        // for input_json_delta (partial JSON) - some APIs use this
 	PartialJSON string `json:"partial_json"` // for input_json_delta (partial JSON) - Vertex AI uses this
+	StopReason  string `json:"stop_reason"`  // for message_delta
 }
 
 type streamContentBlockRef struct {
@@ -81,34 +76,8 @@ type streamContentBlockRef struct {
 	Name  string `json:"name,omitempty"`  // for tool_use blocks
 }
 
-func callClaudeWithMessages(ctx context.Context, systemMessage string, messages []Message, tools []map[string]interface{}) (*ClaudeResponse, error) {
-	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT_ID")
-	location := os.Getenv("GOOGLE_CLOUD_VERTEXAI_LOCATION") // "us-east5"
-	modelID := os.Getenv("CLAUDE_VERTEX_MODEL")             // "claude-sonnet-4-5@20250929"
-
-	// -------- 1) Build authed HTTP client from SA JSON --------
-	enc := os.Getenv("GCP_SERVICE_ACCOUNT_CREDENTIALS")
-	if enc == "" {
-		return nil, fmt.Errorf("GCP_SERVICE_ACCOUNT_CREDENTIALS not set")
-	}
-	saJSON, err := base64.StdEncoding.DecodeString(enc)
-	if err != nil {
-		return nil, fmt.Errorf("decode sa json: %w", err)
-	}
-
-	creds, err := google.CredentialsFromJSON(ctx, saJSON, "https://www.googleapis.com/auth/cloud-platform")
-	if err != nil {
-		return nil, fmt.Errorf("CredentialsFromJSON: %w", err)
-	}
-	httpClient := oauth2.NewClient(ctx, creds.TokenSource)
-
-	// -------- 2) Build Vertex URL --------
-	url := fmt.Sprintf(
-		"https://%s-aiplatform.googleapis.com/v1/projects/%s/locations/%s/publishers/anthropic/models/%s:rawPredict",
-		location, projectID, location, modelID,
-	)
-
-	// -------- 3) Build request body --------
+func callClaudeWithMessages(ctx context.Context, endpoint *AnthropicEndpoint, systemMessage string, messages []Message, tools []map[string]interface{}) (*ClaudeResponse, error) {
+	// -------- 1) Build request body --------
 	// messages -> []map[string]interface{} in Claude format
 	msgs := make([]map[string]interface{}, len(messages))
 	for i, m := range messages {
@@ -118,34 +87,22 @@ func callClaudeWithMessages(ctx context.Context, systemMessage string, messages
 		}
 	}
 
-	body := map[string]interface{}{
-		"anthropic_version": "vertex-2023-10-16",
-		"messages":          msgs,
-		"max_tokens":        1024,
-		"stream":            false,
-	}
+	body := endpoint.newBody(msgs, false)
 
-	if systemMessage != "" {
-		body["system"] = systemMessage
-	}
-
-	if len(tools) > 0 {
-		body["tools"] = tools
-	}
+	setCachedPrompt(body, systemMessage, tools)
 
 	payload, err := json.Marshal(body)
 	if err != nil {
 		return nil, fmt.Errorf("marshal body: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	req, err := endpoint.newRequest(ctx, payload, false)
 	if err != nil {
 		return nil, fmt.Errorf("new request: %w", err)
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	// -------- 4) Send request --------
-	resp, err := httpClient.Do(req)
+	// -------- 2) Send request --------
+	resp, err := endpoint.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("http do: %w", err)
 	}
@@ -154,10 +111,10 @@ func callClaudeWithMessages(ctx context.Context, systemMessage string, messages
 	if resp.StatusCode != http.StatusOK {
 		buf := new(bytes.Buffer)
 		_, _ = buf.ReadFrom(resp.Body)
-		return nil, fmt.Errorf("vertex error %d: %s", resp.StatusCode, buf.String())
+		return nil, fmt.Errorf("%s error %d: %s", endpoint.name, resp.StatusCode, buf.String())
 	}
 
-	// -------- 5) Decode response into your ClaudeResponse --------
+	// -------- 3) Decode response into your ClaudeResponse --------
 	var raw map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
 		return nil, fmt.Errorf("decode response: %w", err)
@@ -166,6 +123,9 @@ func callClaudeWithMessages(ctx context.Context, systemMessage string, messages
 	cr := &ClaudeResponse{
 		RawResponse: raw, // you’ll need to change type from *aiplatformpb.PredictResponse to interface{} or json.RawMessage
 	}
+	if stopReason, ok := raw["stop_reason"].(string); ok {
+		cr.StopReason = NormalizeStopReason(stopReason)
+	}
 
 	// raw["content"] is []{type,text,...}
 	if contentAny, ok := raw["content"]; ok {
@@ -194,41 +154,45 @@ func callClaudeWithMessages(ctx context.Context, systemMessage string, messages
 	return cr, nil
 }
 
+// setCachedPrompt adds the system prompt and tools to the request body with
+// cache_control breakpoints, so the unchanged prefix is served from the prompt cache
+func setCachedPrompt(body map[string]interface{}, systemMessage string, tools []map[string]interface{}) {
+	if len(tools) > 0 {
+		cachedTools := make([]map[string]interface{}, len(tools))
+		copy(cachedTools, tools)
+
+		// the breakpoint on the last tool caches every tool definition; copy it so the shared definitions stay untouched
+		lastTool := make(map[string]interface{}, len(tools[len(tools)-1])+1)
+		for k, v := range tools[len(tools)-1] {
+			lastTool[k] = v
+		}
+		lastTool["cache_control"] = map[string]interface{}{"type": "ephemeral"}
+		cachedTools[len(cachedTools)-1] = lastTool
+
+		body["tools"] = cachedTools
+	}
+
+	if systemMessage != "" {
+		body["system"] = []map[string]interface{}{
+			{
+				"type":          "text",
+				"text":          systemMessage,
+				"cache_control": map[string]interface{}{"type": "ephemeral"},
+			},
+		}
+	}
+}
+
 // StreamClaudeWithMessages streams Claude output and calls onTextChunk for each text delta.
 func StreamClaudeWithMessages(
 	ctx context.Context,
+	endpoint *AnthropicEndpoint,
 	systemMessage string,
 	messages []Message,
 	tools []map[string]interface{},
 	streamCtx *StreamingContext,
 ) (*ClaudeResponse, error) {
-	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT_ID")
-	location := os.Getenv("GOOGLE_CLOUD_VERTEXAI_LOCATION") // e.g. "us-east5"
-	modelID := "claude-sonnet-4-5@20250929"                 // your model
-
-	// ---------- 1) Auth HTTP client from SA JSON ----------
-	enc := os.Getenv("GCP_SERVICE_ACCOUNT_CREDENTIALS")
-	if enc == "" {
-		return nil, fmt.Errorf("GCP_SERVICE_ACCOUNT_CREDENTIALS not set")
-	}
-	saJSON, err := base64.StdEncoding.DecodeString(enc)
-	if err != nil {
-		return nil, fmt.Errorf("decode sa json: %w", err)
-	}
-
-	creds, err := google.CredentialsFromJSON(ctx, saJSON, "https://www.googleapis.com/auth/cloud-platform")
-	if err != nil {
-		return nil, fmt.Errorf("CredentialsFromJSON: %w", err)
-	}
-	httpClient := oauth2.NewClient(ctx, creds.TokenSource)
-
-	// ---------- 2) Build streamRawPredict URL ----------
-	url := fmt.Sprintf(
-		"https://%s-aiplatform.googleapis.com/v1/projects/%s/locations/%s/publishers/anthropic/models/%s:streamRawPredict",
-		location, projectID, location, modelID,
-	)
-
-	// ---------- 3) Build request body ----------
+	// ---------- 1) Build request body ----------
 	msgs := make([]map[string]interface{}, len(messages))
 	for i, m := range messages {
 		msgs[i] = map[string]interface{}{
@@ -237,35 +201,22 @@ func StreamClaudeWithMessages(
 		}
 	}
 
-	body := map[string]interface{}{
-		"anthropic_version": "vertex-2023-10-16",
-		"messages":          msgs,
-		"max_tokens":        1024,
-		"stream":            true, // streaming flag
-	}
+	body := endpoint.newBody(msgs, true)
 
-	if systemMessage != "" {
-		body["system"] = systemMessage
-	}
-
-	if len(tools) > 0 {
-		body["tools"] = tools
-	}
+	setCachedPrompt(body, systemMessage, tools)
 
 	payload, err := json.Marshal(body)
 	if err != nil {
 		return nil, fmt.Errorf("marshal body: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	req, err := endpoint.newRequest(ctx, payload, true)
 	if err != nil {
 		return nil, fmt.Errorf("new request: %w", err)
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "text/event-stream")
 
-	// ---------- 4) Do request & read SSE ----------
-	resp, err := httpClient.Do(req)
+	// ---------- 2) Do request & read SSE ----------
+	resp, err := endpoint.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("http do: %w", err)
 	}
@@ -274,7 +225,7 @@ func StreamClaudeWithMessages(
 	if resp.StatusCode != http.StatusOK {
 		buf := new(bytes.Buffer)
 		_, _ = buf.ReadFrom(resp.Body)
-		return nil, fmt.Errorf("vertex error %d: %s", resp.StatusCode, buf.String())
+		return nil, fmt.Errorf("%s error %d: %s", endpoint.name, resp.StatusCode, buf.String())
 	}
 
 	// Initialize response to accumulate data
@@ -327,16 +278,7 @@ func StreamClaudeWithMessages(
 					currentTextBuilder.WriteString(ev.Delta.Text)
 					accumulatedText.WriteString(ev.Delta.Text)
 
-					// Send streaming chunk to client
-					if streamCtx != nil && streamCtx.Client != nil {
-						payload := &libraries.ChatMessageResponsePayload{
-							Message: ev.Delta.Text,
-						}
-						if streamCtx.BoardId != "" {
-							payload.BoardId = streamCtx.BoardId
-						}
-						libraries.SendChatMessageResponse(streamCtx.Hub, streamCtx.Client, libraries.WebSocketMessageTypeChatResponse, payload)
-					}
+					streamCtx.emitText(ev.Delta.Text)
 				} else if ev.Delta.Type == "input_json_delta" {
 					// Tool use input is being streamed (partial JSON)
 					// Vertex AI uses "partial_json" field, other APIs might use "delta"
@@ -350,6 +292,7 @@ func StreamClaudeWithMessages(
 						idx := ev.Index
 						if inputBuilder, ok := currentToolUseInputBuilders[idx]; ok {
 							inputBuilder.WriteString(jsonChunk)
+							streamCtx.emit(StreamEvent{Type: EventToolCallArgsDelta, ToolCallID: currentToolUseBuilders[idx].ID, ArgsDelta: jsonChunk})
 							fmt.Printf("[anthropic] Accumulated partial_json for index %d: %s (total: %d chars)\n", idx, jsonChunk, inputBuilder.Len())
 						} else {
 							// Index not found - try to find any active builder
@@ -364,6 +307,7 @@ func StreamClaudeWithMessages(
 								}
 								if maxIndex >= 0 {
 									currentToolUseInputBuilders[maxIndex].WriteString(jsonChunk)
+									streamCtx.emit(StreamEvent{Type: EventToolCallArgsDelta, ToolCallID: currentToolUseBuilders[maxIndex].ID, ArgsDelta: jsonChunk})
 									fmt.Printf("[anthropic] Accumulated partial_json to fallback index %d\n", maxIndex)
 								}
 							} else {
@@ -438,6 +382,7 @@ func StreamClaudeWithMessages(
 						Input: make(map[string]interface{}),
 					}
 					currentToolUseInputBuilders[idx] = &strings.Builder{}
+					streamCtx.emit(StreamEvent{Type: EventToolCallStart, ToolCallID: ev.ContentBlock.ID, ToolName: ev.ContentBlock.Name})
 					fmt.Printf("[anthropic] Started tool_use block: index=%d, ID=%s, Name=%s\n", idx, ev.ContentBlock.ID, ev.ContentBlock.Name)
 				} else if ev.ContentBlock.Type == "text" {
 					// Reset text builder for new text block
@@ -448,7 +393,7 @@ func StreamClaudeWithMessages(
 		case "message_stop":
 			// Message is complete - extract stop_reason and finalize any pending tool uses
 			if ev.StopReason != "" {
-				cr.StopReason = ev.StopReason
+				cr.StopReason = NormalizeStopReason(ev.StopReason)
 			}
 			
 			// Finalize any pending tool_use blocks that didn't get a content_block_stop
@@ -485,9 +430,11 @@ func StreamClaudeWithMessages(
 			currentToolUseInputBuilders = make(map[int]*strings.Builder)
 
 		case "message_delta":
-			// Message-level delta (usually contains stop_reason)
-			if ev.StopReason != "" {
-				cr.StopReason = ev.StopReason
+			// Message-level delta - stop_reason lives in delta
+			if ev.Delta != nil && ev.Delta.StopReason != "" {
+				cr.StopReason = NormalizeStopReason(ev.Delta.StopReason)
+			} else if ev.StopReason != "" {
+				cr.StopReason = NormalizeStopReason(ev.StopReason)
 			}
 
 		case "content_block":
@@ -498,16 +445,7 @@ func StreamClaudeWithMessages(
 					cr.TextContent = append(cr.TextContent, block.Text)
 					accumulatedText.WriteString(block.Text)
 
-					// Send to client
-					if streamCtx != nil && streamCtx.Client != nil {
-						payload := &libraries.ChatMessageResponsePayload{
-							Message: block.Text,
-						}
-						if streamCtx.BoardId != "" {
-							payload.BoardId = streamCtx.BoardId
-						}
-						libraries.SendChatMessageResponse(streamCtx.Hub, streamCtx.Client, libraries.WebSocketMessageTypeChatResponse, payload)
-					}
+					streamCtx.emitText(block.Text)
 				} else if block.Type == "tool_use" {
 					// Complete tool use block - this might contain the full input
 					toolUse := ToolUse{
@@ -590,25 +528,50 @@ func StreamClaudeWithMessages(
 	return cr, nil
 }
 
+// chatClaude runs the tool loop without streaming and joins the final text blocks
+func chatClaude(ctx context.Context, endpoint *AnthropicEndpoint, tools []map[string]interface{}, systemMessage string, messages []Message) (string, error) {
+	resp, err := ChatWithTools(ctx, endpoint, systemMessage, messages, tools, nil)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(resp.TextContent, "\n\n"), nil
+}
+
+// chatStreamClaude runs the tool loop, reporting progress through streamCtx
+func chatStreamClaude(ctx context.Context, endpoint *AnthropicEndpoint, tools []map[string]interface{}, streamCtx *StreamingContext, systemMessage string, messages []Message) (string, error) {
+	resp, err := ChatWithTools(ctx, endpoint, systemMessage, messages, tools, streamCtx)
+	if err != nil {
+		streamCtx.emitFinish("", err)
+		// return whatever was already streamed so the caller can keep the partial answer
+		return streamCtx.StreamedText(), err
+	}
+	streamCtx.emitFinish(resp.StopReason, nil)
+	return streamCtx.finalText(strings.Join(resp.TextContent, "\n\n")), nil
+}
+
 // === Updated ExecuteToolFlow that uses dynamic dispatcher ===
-func ChatWithTools(ctx context.Context, systemMessage string, messages []Message, tools []map[string]interface{}, streamCtx *StreamingContext) (*ClaudeResponse, error) {
-	const maxIterations = 10 // safety guard - increased for complex drawings that need many shapes
+func ChatWithTools(ctx context.Context, endpoint *AnthropicEndpoint, systemMessage string, messages []Message, tools []map[string]interface{}, streamCtx *StreamingContext) (*ClaudeResponse, error) {
+	maxIterations := iterationsOrDefault(endpoint.params) // safety guard
 
 	workingMessages := make([]Message, 0, len(messages)+6)
 	workingMessages = append(workingMessages, messages...)
 
 	var lastResp *ClaudeResponse
 	for iter := 0; iter < maxIterations; iter++ {
+		// Stop early if the generation was cancelled
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 
 		var cr *ClaudeResponse
 		var err error
-		if streamCtx != nil && streamCtx.Client != nil {
-			cr, err = StreamClaudeWithMessages(ctx, systemMessage, workingMessages, tools, streamCtx)
+		if streamCtx.IsStreaming() {
+			cr, err = StreamClaudeWithMessages(ctx, endpoint, systemMessage, workingMessages, tools, streamCtx)
 			if err != nil {
 				return nil, fmt.Errorf("StreamClaudeWithMessages: %w", err)
 			}
 		} else {
-			cr, err = callClaudeWithMessages(ctx, systemMessage, workingMessages, tools)
+			cr, err = callClaudeWithMessages(ctx, endpoint, systemMessage, workingMessages, tools)
 		if err != nil {
 			return nil, fmt.Errorf("callClaudeWithMessages: %w", err)
 		}
@@ -620,6 +583,8 @@ func ChatWithTools(ctx context.Context, systemMessage string, messages []Message
 
 		lastResp = cr
 
+		streamCtx.emit(StreamEvent{Type: EventIterationEnd, Iteration: iter + 1, StopReason: cr.StopReason})
+
 		// If no tool uses, we're done
 		if len(cr.ToolUses) == 0 {
 			return cr, nil
@@ -634,7 +599,7 @@ func ChatWithTools(ctx context.Context, systemMessage string, messages []Message
 				ID:       toolUse.ID,
 				Name:     toolUse.Name,
 				Input:    toolUse.Input,
-				Provider: "anthropic",
+				Provider: endpoint.name,
 			})
 		}
 
@@ -692,5 +657,8 @@ func ChatWithTools(ctx context.Context, systemMessage string, messages []Message
 		time.Sleep(50 * time.Millisecond)
 	}
 
-	return lastResp, fmt.Errorf("max iterations reached (%d) while resolving tools", maxIterations)
+	// out of passes - report it as the stop reason and keep what we have
+	fmt.Printf("[%s] max iterations reached (%d) while resolving tools\n", endpoint.name, maxIterations)
+	lastResp.StopReason = StopReasonMaxIterations
+	return lastResp, nil
 }
@@ -0,0 +1,31 @@
+package llmHandlers
+
+import (
+	"context"
+	"fmt"
+	"melina-studio-backend/internal/models"
+)
+
+// AnthropicAPIClient implements llm.Client using the first-party Anthropic Messages API
+type AnthropicAPIClient struct {
+	endpoint *AnthropicEndpoint
+	Tools    []map[string]interface{}
+}
+
+// NewAnthropicAPIClient creates a client; apiKey and model fall back to ANTHROPIC_API_KEY and ANTHROPIC_MODEL
+func NewAnthropicAPIClient(apiKey string, model string, tools []map[string]interface{}, params models.GenerationParams) (*AnthropicAPIClient, error) {
+	endpoint, err := NewAnthropicAPIEndpoint(apiKey, model, params)
+	if err != nil {
+		return nil, fmt.Errorf("create anthropic client: %w", err)
+	}
+	return &AnthropicAPIClient{endpoint: endpoint, Tools: tools}, nil
+}
+
+// Chat returns a single string answer (convenience wrapper).
+func (c *AnthropicAPIClient) Chat(ctx context.Context, systemMessage string, messages []Message) (string, error) {
+	return chatClaude(ctx, c.endpoint, c.Tools, systemMessage, messages)
+}
+
+func (c *AnthropicAPIClient) ChatStream(ctx context.Context, streamCtx *StreamingContext, systemMessage string, messages []Message) (string, error) {
+	return chatStreamClaude(ctx, c.endpoint, c.Tools, streamCtx, systemMessage, messages)
+}
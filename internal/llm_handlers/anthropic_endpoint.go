@@ -0,0 +1,167 @@
+package llmHandlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"melina-studio-backend/internal/models"
+	"net/http"
+	"os"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	defaultVertexClaudeModel    = "claude-sonnet-4-5@20250929"
+	defaultAnthropicClaudeModel = "claude-sonnet-4-5"
+
+	anthropicAPIURL     = "https://api.anthropic.com/v1/messages"
+	anthropicAPIVersion = "2023-06-01"
+)
+
+// AnthropicEndpoint describes where Claude Messages API requests are sent.
+// Vertex AI and the first-party Anthropic API accept the same messages, but differ
+// in URL, authentication and a couple of body fields.
+type AnthropicEndpoint struct {
+	name       string // used in logs and errors
+	httpClient *http.Client
+	url        string
+	streamURL  string
+	headers    map[string]string
+	bodyFields map[string]interface{} // extra fields added to every request body
+	params     models.GenerationParams
+}
+
+// NewVertexAnthropicEndpoint builds an endpoint for Claude on Vertex AI.
+// model falls back to CLAUDE_VERTEX_MODEL, then to defaultVertexClaudeModel.
+func NewVertexAnthropicEndpoint(ctx context.Context, model string, params models.GenerationParams) (*AnthropicEndpoint, error) {
+	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT_ID")
+	location := os.Getenv("GOOGLE_CLOUD_VERTEXAI_LOCATION") // e.g. "us-east5"
+	if model == "" {
+		model = os.Getenv("CLAUDE_VERTEX_MODEL")
+	}
+	if model == "" {
+		model = defaultVertexClaudeModel
+	}
+
+	httpClient, err := vertexHTTPClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := fmt.Sprintf(
+		"https://%s-aiplatform.googleapis.com/v1/projects/%s/locations/%s/publishers/anthropic/models/%s",
+		location, projectID, location, model,
+	)
+
+	return &AnthropicEndpoint{
+		name:       "vertex_anthropic",
+		httpClient: httpClient,
+		url:        baseURL + ":rawPredict",
+		streamURL:  baseURL + ":streamRawPredict",
+		bodyFields: map[string]interface{}{
+			"anthropic_version": "vertex-2023-10-16",
+		},
+		params: params,
+	}, nil
+}
+
+// vertexHTTPClient builds an authed HTTP client from the service account JSON.
+// Replayed cassettes need no credentials.
+func vertexHTTPClient(ctx context.Context) (*http.Client, error) {
+	if isReplayingCassette() {
+		return withCassette(http.DefaultClient), nil
+	}
+
+	enc := os.Getenv("GCP_SERVICE_ACCOUNT_CREDENTIALS")
+	if enc == "" {
+		return nil, fmt.Errorf("GCP_SERVICE_ACCOUNT_CREDENTIALS not set")
+	}
+	saJSON, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return nil, fmt.Errorf("decode sa json: %w", err)
+	}
+
+	creds, err := google.CredentialsFromJSON(ctx, saJSON, "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		return nil, fmt.Errorf("CredentialsFromJSON: %w", err)
+	}
+	return withCassette(oauth2.NewClient(ctx, creds.TokenSource)), nil
+}
+
+// NewAnthropicAPIEndpoint builds an endpoint for the first-party Anthropic Messages API.
+// apiKey and model fall back to ANTHROPIC_API_KEY and ANTHROPIC_MODEL.
+func NewAnthropicAPIEndpoint(apiKey string, model string, params models.GenerationParams) (*AnthropicEndpoint, error) {
+	if apiKey == "" {
+		apiKey = os.Getenv("ANTHROPIC_API_KEY")
+	}
+	if apiKey == "" && !isReplayingCassette() {
+		return nil, fmt.Errorf("ANTHROPIC_API_KEY not set")
+	}
+	if model == "" {
+		model = os.Getenv("ANTHROPIC_MODEL")
+	}
+	if model == "" {
+		model = defaultAnthropicClaudeModel
+	}
+
+	return &AnthropicEndpoint{
+		name:       "anthropic",
+		httpClient: withCassette(http.DefaultClient),
+		url:        anthropicAPIURL,
+		streamURL:  anthropicAPIURL,
+		headers: map[string]string{
+			"x-api-key":         apiKey,
+			"anthropic-version": anthropicAPIVersion,
+		},
+		bodyFields: map[string]interface{}{
+			"model": model,
+		},
+		params: params,
+	}, nil
+}
+
+// newBody returns the common request body for the given messages
+func (e *AnthropicEndpoint) newBody(messages []map[string]interface{}, stream bool) map[string]interface{} {
+	body := map[string]interface{}{
+		"messages":   messages,
+		"max_tokens": maxTokensOrDefault(e.params),
+		"stream":     stream,
+	}
+	if e.params.Temperature != nil {
+		body["temperature"] = *e.params.Temperature
+	}
+	if e.params.TopP != nil {
+		body["top_p"] = *e.params.TopP
+	}
+	if len(e.params.StopSequences) > 0 {
+		body["stop_sequences"] = e.params.StopSequences
+	}
+	for k, v := range e.bodyFields {
+		body[k] = v
+	}
+	return body
+}
+
+// newRequest builds the HTTP request for an encoded body
+func (e *AnthropicEndpoint) newRequest(ctx context.Context, payload []byte, stream bool) (*http.Request, error) {
+	url := e.url
+	if stream {
+		url = e.streamURL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if stream {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
@@ -0,0 +1,244 @@
+package llmHandlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+)
+
+// CassetteMode selects whether a cassette records real traffic or replays it
+type CassetteMode string
+
+const (
+	CassetteModeRecord CassetteMode = "record"
+	CassetteModeReplay CassetteMode = "replay"
+)
+
+// CassetteInteraction is one recorded request/response pair.
+// Request headers and credential query parameters are never stored, so API keys
+// and tokens don't end up in the file.
+type CassetteInteraction struct {
+	Method       string      `json:"method"`
+	URL          string      `json:"url"`
+	RequestBody  string      `json:"request_body"`
+	Status       int         `json:"status"`
+	Header       http.Header `json:"header"`
+	ResponseBody string      `json:"response_body"`
+}
+
+// Cassette records provider traffic to a JSON file, or replays it in order
+// without touching the network
+type Cassette struct {
+	mode CassetteMode
+	path string
+
+	mu           sync.Mutex
+	interactions []CassetteInteraction
+	replayed     int
+}
+
+// query parameters that carry credentials, e.g. Gemini's ?key=
+var cassetteSecretParams = []string{"key", "api_key", "access_token"}
+
+var (
+	httpCassetteMu   sync.Mutex
+	httpCassette     *Cassette
+	httpCassetteInit bool
+)
+
+// NewCassette opens a cassette file. In replay mode the file must exist;
+// in record mode it is (re)written after every interaction.
+func NewCassette(path string, mode CassetteMode) (*Cassette, error) {
+	c := &Cassette{
+		mode: mode,
+		path: path,
+	}
+
+	switch mode {
+	case CassetteModeRecord:
+		return c, nil
+	case CassetteModeReplay:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read cassette: %w", err)
+		}
+		if err := json.Unmarshal(data, &c.interactions); err != nil {
+			return nil, fmt.Errorf("decode cassette %s: %w", path, err)
+		}
+		return c, nil
+	default:
+		return nil, fmt.Errorf("unknown cassette mode: %s", mode)
+	}
+}
+
+// SetHTTPCassette makes every provider client send its requests through c.
+// Passing nil restores normal network access.
+func SetHTTPCassette(c *Cassette) {
+	httpCassetteMu.Lock()
+	defer httpCassetteMu.Unlock()
+	httpCassette = c
+	httpCassetteInit = true
+}
+
+// activeCassette returns the configured cassette, loading it from
+// LLM_HTTP_CASSETTE and LLM_HTTP_MODE on first use
+func activeCassette() *Cassette {
+	httpCassetteMu.Lock()
+	defer httpCassetteMu.Unlock()
+
+	if !httpCassetteInit {
+		httpCassetteInit = true
+		if path := os.Getenv("LLM_HTTP_CASSETTE"); path != "" {
+			mode := CassetteMode(os.Getenv("LLM_HTTP_MODE"))
+			if mode == "" {
+				mode = CassetteModeReplay
+			}
+			c, err := NewCassette(path, mode)
+			if err != nil {
+				fmt.Printf("[cassette] disabled: %v\n", err)
+			} else {
+				fmt.Printf("[cassette] %s mode using %s\n", mode, path)
+				httpCassette = c
+			}
+		}
+	}
+	return httpCassette
+}
+
+// isReplayingCassette reports whether provider traffic is served from a cassette
+func isReplayingCassette() bool {
+	c := activeCassette()
+	return c != nil && c.mode == CassetteModeReplay
+}
+
+// withCassette returns base with its transport wrapped by the active cassette,
+// or base unchanged when no cassette is configured
+func withCassette(base *http.Client) *http.Client {
+	c := activeCassette()
+	if c == nil {
+		return base
+	}
+	if base == nil {
+		base = http.DefaultClient
+	}
+
+	next := base.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	wrapped := *base
+	wrapped.Transport = c.Transport(next)
+	return &wrapped
+}
+
+// Transport returns a round tripper that goes through the cassette; while
+// recording, real requests are sent with next
+func (c *Cassette) Transport(next http.RoundTripper) http.RoundTripper {
+	return cassetteTransport{cassette: c, next: next}
+}
+
+type cassetteTransport struct {
+	cassette *Cassette
+	next     http.RoundTripper
+}
+
+func (t cassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.cassette.roundTrip(req, t.next)
+}
+
+func (c *Cassette) roundTrip(req *http.Request, next http.RoundTripper) (*http.Response, error) {
+	var requestBody []byte
+	if req.Body != nil {
+		var err error
+		requestBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read request body: %w", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(requestBody))
+	}
+
+	if c.mode == CassetteModeReplay {
+		return c.replay(req)
+	}
+
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	responseBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(responseBody))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interactions = append(c.interactions, CassetteInteraction{
+		Method:       req.Method,
+		URL:          cassetteURL(req.URL),
+		RequestBody:  string(requestBody),
+		Status:       resp.StatusCode,
+		Header:       resp.Header.Clone(),
+		ResponseBody: string(responseBody),
+	})
+	if err := c.save(); err != nil {
+		fmt.Printf("[cassette] failed to save %s: %v\n", c.path, err)
+	}
+	return resp, nil
+}
+
+// replay returns the next recorded interaction; requests must arrive in the recorded order
+func (c *Cassette) replay(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	requestURL := cassetteURL(req.URL)
+	if c.replayed >= len(c.interactions) {
+		return nil, fmt.Errorf("cassette %s exhausted: unexpected %s %s", c.path, req.Method, requestURL)
+	}
+	interaction := c.interactions[c.replayed]
+	if interaction.Method != req.Method || interaction.URL != requestURL {
+		return nil, fmt.Errorf("cassette %s mismatch at interaction %d: recorded %s %s, got %s %s",
+			c.path, c.replayed, interaction.Method, interaction.URL, req.Method, requestURL)
+	}
+	c.replayed++
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.Status, http.StatusText(interaction.Status)),
+		StatusCode:    interaction.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        interaction.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader([]byte(interaction.ResponseBody))),
+		ContentLength: int64(len(interaction.ResponseBody)),
+		Request:       req,
+	}, nil
+}
+
+// cassetteURL is the request URL as stored and matched, without credential parameters
+func cassetteURL(u *url.URL) string {
+	clean := *u
+	query := clean.Query()
+	for _, param := range cassetteSecretParams {
+		query.Del(param)
+	}
+	clean.RawQuery = query.Encode()
+	return clean.String()
+}
+
+// save writes every recorded interaction; callers hold c.mu
+func (c *Cassette) save() error {
+	data, err := json.MarshalIndent(c.interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, 0o644)
+}
@@ -0,0 +1,94 @@
+package llmHandlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"melina-studio-backend/internal/models"
+)
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestCassetteReplayRunsTools(t *testing.T) {
+	cassette, err := NewCassette(filepath.Join("testdata", "anthropic_tool_loop.json"), CassetteModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetHTTPCassette(cassette)
+	defer SetHTTPCassette(nil)
+
+	var calls []map[string]interface{}
+	RegisterTool("cassetteTestNote", func(ctx context.Context, input map[string]interface{}) (interface{}, error) {
+		calls = append(calls, input)
+		return map[string]interface{}{"status": "created"}, nil
+	})
+	defer UnregisterTool("cassetteTestNote")
+
+	client, err := NewAnthropicAPIClient("", "", nil, models.GenerationParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer, err := client.Chat(context.Background(), "You are a test.", []Message{{Role: "user", Content: "add a note"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(calls) != 1 || calls[0]["text"] != "hello" {
+		t.Fatalf("tool calls = %v, want one call with text hello", calls)
+	}
+	if !strings.Contains(answer, "The note is on the board.") {
+		t.Fatalf("answer = %q", answer)
+	}
+	if cassette.replayed != 2 {
+		t.Fatalf("replayed %d interactions, want 2", cassette.replayed)
+	}
+}
+
+func TestCassetteDoesNotStoreAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	recorder, err := NewCassette(path, CassetteModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       io.NopCloser(bytes.NewReader([]byte(`{}`))),
+		}, nil
+	})
+
+	send := func(c *Cassette, next http.RoundTripper, key string) error {
+		req, _ := http.NewRequest(http.MethodPost, "https://generativelanguage.googleapis.com/v1beta/models/gemini:streamGenerateContent?alt=sse&key="+key, nil)
+		resp, err := c.Transport(next).RoundTrip(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := send(recorder, upstream, "secret-key"); err != nil {
+		t.Fatal(err)
+	}
+	if url := recorder.interactions[0].URL; strings.Contains(url, "secret-key") || !strings.Contains(url, "alt=sse") {
+		t.Fatalf("recorded url = %q", url)
+	}
+
+	// replays match whatever key the client is configured with
+	player, err := NewCassette(path, CassetteModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := send(player, nil, "another-key"); err != nil {
+		t.Fatal(err)
+	}
+}
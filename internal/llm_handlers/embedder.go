@@ -0,0 +1,116 @@
+package llmHandlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Embedder turns texts into vectors for semantic search
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	// Model identifies the embedding space; vectors of different models are not comparable
+	Model() string
+}
+
+// OpenAICompatibleEmbedder calls the /embeddings endpoint of an OpenAI-compatible
+// server, e.g. OpenAI itself or a local Ollama / llama.cpp server
+type OpenAICompatibleEmbedder struct {
+	baseURL    string
+	model      string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// NewOpenAICompatibleEmbedder creates an embedder for baseURL (e.g. http://localhost:11434/v1)
+func NewOpenAICompatibleEmbedder(baseURL string, model string, apiKey string) (*OpenAICompatibleEmbedder, error) {
+	if baseURL == "" || model == "" {
+		return nil, fmt.Errorf("base URL and model are required for the embedder")
+	}
+	return &OpenAICompatibleEmbedder{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		model:      model,
+		apiKey:     apiKey,
+		httpClient: withCassette(&http.Client{Timeout: 60 * time.Second}),
+	}, nil
+}
+
+// NewEmbedderFromEnv returns the embedder configured by EMBEDDING_BASE_URL,
+// EMBEDDING_MODEL and EMBEDDING_API_KEY, or nil if semantic search is not configured
+func NewEmbedderFromEnv() (Embedder, error) {
+	baseURL := os.Getenv("EMBEDDING_BASE_URL")
+	if baseURL == "" {
+		return nil, nil
+	}
+	embedder, err := NewOpenAICompatibleEmbedder(baseURL, os.Getenv("EMBEDDING_MODEL"), os.Getenv("EMBEDDING_API_KEY"))
+	if err != nil {
+		return nil, err
+	}
+	return embedder, nil
+}
+
+func (e *OpenAICompatibleEmbedder) Model() string {
+	return e.model
+}
+
+func (e *OpenAICompatibleEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"model": e.model,
+		"input": texts,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.baseURL+"/embeddings", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		buf := new(bytes.Buffer)
+		_, _ = buf.ReadFrom(resp.Body)
+		return nil, fmt.Errorf("embeddings error %d: %s", resp.StatusCode, buf.String())
+	}
+
+	var decoded struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	if len(decoded.Data) != len(texts) {
+		return nil, fmt.Errorf("embeddings error: got %d vectors for %d texts", len(decoded.Data), len(texts))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, item := range decoded.Data {
+		if item.Index < 0 || item.Index >= len(texts) {
+			return nil, fmt.Errorf("embeddings error: index %d out of range", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	return vectors, nil
+}
@@ -0,0 +1,121 @@
+package llmHandlers
+
+import (
+	"strings"
+	"sync"
+)
+
+// StreamEventType identifies an event in the provider independent stream
+type StreamEventType string
+
+const (
+	EventTextDelta         StreamEventType = "text_delta"           // a piece of the assistant's answer
+	EventToolCallStart     StreamEventType = "tool_call_start"      // the model started a tool call
+	EventToolCallArgsDelta StreamEventType = "tool_call_args_delta" // partial JSON arguments of a tool call
+	EventToolStart         StreamEventType = "tool_start"           // a tool is about to be executed
+	EventToolResult        StreamEventType = "tool_result"          // a tool finished executing (successfully or not)
+	EventIterationEnd      StreamEventType = "iteration_end"        // one pass of the tool loop is over
+	EventDone              StreamEventType = "done"                 // the final answer is complete
+	EventError             StreamEventType = "error"                // generation failed
+)
+
+// StreamEvent is what every provider emits while generating a response.
+// Only the fields relevant to the event type are set.
+type StreamEvent struct {
+	Type StreamEventType
+
+	Text string // text_delta
+
+	ToolCallID string                 // tool_call_start, tool_call_args_delta, tool_start, tool_result
+	ToolName   string                 // tool_call_start, tool_start, tool_result
+	ArgsDelta  string                 // tool_call_args_delta
+	ToolInput  map[string]interface{} // tool_start, tool_result
+	ToolIndex  int                    // tool_start, tool_result: 1-based position in the batch
+	ToolTotal  int                    // tool_start, tool_result: number of tool calls in the batch
+
+	Result *ToolExecutionResult // tool_result
+
+	Iteration  int    // iteration_end
+	StopReason string // iteration_end, done
+
+	Err error // error
+}
+
+// EventHandler consumes stream events; it is called sequentially
+type EventHandler func(event StreamEvent)
+
+// StreamingContext carries the event stream of a single generation through
+// the provider loop and into the tool handlers
+type StreamingContext struct {
+	BoardId string // Optional: empty string means no board context
+	OnEvent EventHandler
+
+	// emitMu serializes events so handlers never run concurrently
+	emitMu sync.Mutex
+	// streamedText accumulates every text delta already emitted, so the
+	// partial answer can still be saved if the generation gets cancelled
+	streamedText strings.Builder
+}
+
+// NewStreamingContext creates a streaming context that forwards events to onEvent
+func NewStreamingContext(boardId string, onEvent EventHandler) *StreamingContext {
+	return &StreamingContext{
+		BoardId: boardId,
+		OnEvent: onEvent,
+	}
+}
+
+// emit forwards an event to the handler; safe to call on a nil context
+func (s *StreamingContext) emit(event StreamEvent) {
+	if s == nil || s.OnEvent == nil {
+		return
+	}
+	s.emitMu.Lock()
+	defer s.emitMu.Unlock()
+
+	if event.Type == EventTextDelta {
+		s.streamedText.WriteString(event.Text)
+	}
+	s.OnEvent(event)
+}
+
+// emitText emits a text delta
+func (s *StreamingContext) emitText(text string) {
+	if text == "" {
+		return
+	}
+	s.emit(StreamEvent{Type: EventTextDelta, Text: text})
+}
+
+// emitFinish emits done or error depending on how the generation ended
+func (s *StreamingContext) emitFinish(stopReason string, err error) {
+	if err != nil {
+		s.emit(StreamEvent{Type: EventError, Err: err})
+		return
+	}
+	s.emit(StreamEvent{Type: EventDone, StopReason: stopReason})
+}
+
+// IsStreaming reports whether anyone is listening to the events
+func (s *StreamingContext) IsStreaming() bool {
+	return s != nil && s.OnEvent != nil
+}
+
+// StreamedText returns the text that has been emitted so far
+func (s *StreamingContext) StreamedText() string {
+	if s == nil {
+		return ""
+	}
+	s.emitMu.Lock()
+	defer s.emitMu.Unlock()
+	return s.streamedText.String()
+}
+
+// finalText returns the answer as the listener saw it streamed, or fallback when
+// nobody listened, so every provider hands the same text back to the caller
+func (s *StreamingContext) finalText(fallback string) string {
+	if !s.IsStreaming() {
+		return fallback
+	}
+	return s.StreamedText()
+}
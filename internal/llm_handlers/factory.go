@@ -3,6 +3,7 @@ package llmHandlers
 import (
 	"context"
 	"fmt"
+	"melina-studio-backend/internal/models"
 )
 
 type Provider string
@@ -12,21 +13,35 @@ const (
 	ProviderLangChainGroq   Provider = "groq"             // LangChainGo (Groq, uses BaseURL)
 	ProviderVertexAnthropic Provider = "vertex_anthropic" // Your anthropic.go wrapper
 	ProviderGemini    Provider = "gemini"
+	ProviderAnthropic Provider = "anthropic"                 // first-party Anthropic Messages API
+	ProviderOpenAICompatible Provider = "openai_compatible" // LangChainGo against any OpenAI-compatible server (Ollama, llama.cpp, ...)
+	ProviderMock Provider = "mock"                           // scripted turns, no network
 )
 
+// placeholder API key for local servers that don't check it; the OpenAI client refuses an empty key
+const localLLMPlaceholderAPIKey = "not-needed"
+
 type Config struct {
 	Provider Provider
 
-	// LangChain configs
+	// LangChain configs (Model is also used by the Anthropic providers)
 	Model   string
 	BaseURL string
 	APIKey  string
 
 	// Anthropic configs
 	Tools []map[string]interface{}
+
+	// Mock configs: scripted turns, falls back to the MOCK_LLM_SCRIPT file
+	MockTurns []MockTurn
+
+	// Generation params shared by every provider; unset fields use the defaults
+	Params models.GenerationParams
 }
 
 func New(cfg Config) (Client, error) {
+	cfg.Params = clampForProvider(ResolveGenerationParams(cfg.Params), cfg.Provider)
+
 	switch cfg.Provider {
 
 	case ProviderLangChainOpenAI:
@@ -34,6 +49,7 @@ func New(cfg Config) (Client, error) {
 			Model:  cfg.Model,
 			APIKey: cfg.APIKey,
 			Tools:  cfg.Tools,
+			Params: cfg.Params,
 		})
 
 	case ProviderLangChainGroq:
@@ -42,15 +58,38 @@ func New(cfg Config) (Client, error) {
 			BaseURL: cfg.BaseURL, // e.g. https://api.groq.com/openai/v1
 			APIKey:  cfg.APIKey,
 			Tools:   cfg.Tools,
+			Params:  cfg.Params,
+		})
+
+	case ProviderOpenAICompatible:
+		if cfg.BaseURL == "" {
+			return nil, fmt.Errorf("base URL is required for the %s provider", cfg.Provider)
+		}
+		apiKey := cfg.APIKey
+		if apiKey == "" {
+			apiKey = localLLMPlaceholderAPIKey
+		}
+		return NewLangChainClient(LangChainConfig{
+			Model:   cfg.Model,
+			BaseURL: cfg.BaseURL, // e.g. http://localhost:11434/v1 for Ollama
+			APIKey:  apiKey,
+			Tools:   cfg.Tools,
+			Params:  cfg.Params,
 		})
 
 	case ProviderVertexAnthropic:
-		return NewVertexAnthropicClient(cfg.Tools), nil
+		return NewVertexAnthropicClient(cfg.Model, cfg.Tools, cfg.Params), nil
+
+	case ProviderAnthropic:
+		return NewAnthropicAPIClient(cfg.APIKey, cfg.Model, cfg.Tools, cfg.Params)
+
+	case ProviderMock:
+		return NewMockClient(cfg.MockTurns, cfg.Params)
 
 	case ProviderGemini:
 		// Create background context for client initialization
 		ctx := context.Background()
-		client, err := NewGenaiGeminiClient(ctx, cfg.Tools)
+		client, err := NewGenaiGeminiClient(ctx, cfg.Tools, cfg.Params)
 		if err != nil {
 			return nil, err
 		}
@@ -88,5 +127,22 @@ cfg := llm.Config{
 }
 client, _ := llm.New(cfg)
 
+// for the Anthropic API:
+cfg := llm.Config{
+    Provider: llm.ProviderAnthropic,
+    Model:    "claude-sonnet-4-5",
+    APIKey:   os.Getenv("ANTHROPIC_API_KEY"),
+    Tools:    myToolsMeta,
+}
+client, _ := llm.New(cfg)
+
+// for a local server (Ollama):
+cfg := llm.Config{
+    Provider: llm.ProviderOpenAICompatible,
+    Model:    "llama3.1",
+    BaseURL:  "http://localhost:11434/v1",
+}
+client, _ := llm.New(cfg)
+
 
 */
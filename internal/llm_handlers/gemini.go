@@ -5,16 +5,18 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"melina-studio-backend/internal/libraries"
+	"melina-studio-backend/internal/models"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"google.golang.org/genai"
 )
 
 // GeminiResponse contains the parsed response from Gemini
 type GeminiResponse struct {
+	StopReason   string
 	TextContent  []string
 	FunctionCalls []FunctionCall
 	RawResponse   *genai.GenerateContentResponse
@@ -22,6 +24,7 @@ type GeminiResponse struct {
 
 // FunctionCall represents a function call from Gemini
 type FunctionCall struct {
+	ID        string
 	Name      string
 	Arguments map[string]interface{}
 }
@@ -31,12 +34,11 @@ type GenaiGeminiClient struct {
 	client  *genai.Client
 	modelID string
 
-	Temperature float32
-	MaxTokens   int32
-	Tools       []map[string]interface{}
+	Params models.GenerationParams
+	Tools  []map[string]interface{}
 }
 
-func NewGenaiGeminiClient(ctx context.Context, tools []map[string]interface{}) (*GenaiGeminiClient, error) {
+func NewGenaiGeminiClient(ctx context.Context, tools []map[string]interface{}, params models.GenerationParams) (*GenaiGeminiClient, error) {
 	apiKey := os.Getenv("GEMINI_API_KEY")
 	modelID := os.Getenv("GEMINI_MODEL_ID")
 
@@ -45,8 +47,9 @@ func NewGenaiGeminiClient(ctx context.Context, tools []map[string]interface{}) (
 	}
 
 	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  apiKey,
-		Backend: genai.BackendGeminiAPI,
+		APIKey:     apiKey,
+		Backend:    genai.BackendGeminiAPI,
+		HTTPClient: withCassette(nil), // nil unless a cassette is configured
 	})
 
 	if err != nil {
@@ -54,11 +57,10 @@ func NewGenaiGeminiClient(ctx context.Context, tools []map[string]interface{}) (
 	}
 
 	return &GenaiGeminiClient{
-		client:      client,
-		modelID:     modelID,
-		Temperature: 0.2,
-		MaxTokens:   1024,
-		Tools:       tools,
+		client:  client,
+		modelID: modelID,
+		Params:  params,
+		Tools:   tools,
 	}, nil
 }
 
@@ -240,10 +242,16 @@ func (v *GenaiGeminiClient) callGeminiWithMessages(ctx context.Context, systemMe
 	
 	// Build generation config
 	genConfig := &genai.GenerateContentConfig{
-		Temperature:     &v.Temperature,
-		MaxOutputTokens: v.MaxTokens,
+		MaxOutputTokens: int32(maxTokensOrDefault(v.Params)),
+		StopSequences:   v.Params.StopSequences,
 		Tools:           genaiTools,
 	}
+	if v.Params.Temperature != nil {
+		genConfig.Temperature = genai.Ptr(float32(*v.Params.Temperature))
+	}
+	if v.Params.TopP != nil {
+		genConfig.TopP = genai.Ptr(float32(*v.Params.TopP))
+	}
 
 
 	// Add system instruction if exists
@@ -261,13 +269,15 @@ func (v *GenaiGeminiClient) callGeminiWithMessages(ctx context.Context, systemMe
 
 	var resp *genai.GenerateContentResponse
 
-	// Use streaming if streaming context is provided
-	if streamCtx != nil && streamCtx.Client != nil {
+	// Use streaming if anyone listens to the events
+	if streamCtx.IsStreaming() {
 		// Use GenerateContentStream for real-time tokens
 		iterator := v.client.Models.GenerateContentStream(ctx, v.modelID, contents, genConfig)
 		
 		var lastChunk *genai.GenerateContentResponse
 		var accumulatedText strings.Builder
+		// function calls may arrive in any chunk, not only the last one
+		var streamedCalls []*genai.Part
 		
 		// Iterate over streaming chunks
 		// Note: chunk and chunkErr are the loop variables, not shadowing outer resp
@@ -276,7 +286,7 @@ func (v *GenaiGeminiClient) callGeminiWithMessages(ctx context.Context, systemMe
 				return nil, fmt.Errorf("gemini stream error: %w", chunkErr)
 			}
 			
-			// Store the last chunk (contains final state including function calls)
+			// Store the last chunk (contains the final state like the finish reason)
 			lastChunk = chunk
 			
 			// Extract text from the current chunk and stream it
@@ -285,52 +295,35 @@ func (v *GenaiGeminiClient) callGeminiWithMessages(ctx context.Context, systemMe
 			if token != "" {
 				// Accumulate the full text
 				accumulatedText.WriteString(token)
-				
-				// Send streaming chunk to client
-				payload := &libraries.ChatMessageResponsePayload{
-					Message: token,
-				}
-				// Only include BoardId if it's not empty
-				if streamCtx.BoardId != "" {
-					payload.BoardId = streamCtx.BoardId
+				streamCtx.emitText(token)
+			}
+
+			if len(chunk.Candidates) > 0 && chunk.Candidates[0].Content != nil {
+				for _, part := range chunk.Candidates[0].Content.Parts {
+					if part.FunctionCall != nil {
+						streamedCalls = append(streamedCalls, part)
+					}
 				}
-				libraries.SendChatMessageResponse(streamCtx.Hub, streamCtx.Client, libraries.WebSocketMessageTypeChatResponse, payload)
 			}
 		}
 		
-		// Use the last chunk as the base for the final response
-		// This contains the complete response structure including any function calls
 		resp = lastChunk
 		
 		if resp == nil {
 			return nil, fmt.Errorf("gemini stream returned no response")
 		}
 		
-		// IMPORTANT: The last chunk's Content.Parts might only contain the last token
-		// We need to ensure the full accumulated text is in the response
-		// Update the response to include the full accumulated text
+		// The last chunk only holds the last token - rebuild its parts from
+		// everything that was streamed so the parsing below sees the full answer
 		if len(resp.Candidates) > 0 {
-			if resp.Candidates[0].Content != nil && len(resp.Candidates[0].Content.Parts) > 0 {
-				// Replace the text in the first part with the accumulated full text
-				// This ensures the response parsing later gets the complete text
-				fullText := accumulatedText.String()
-				if fullText != "" {
-					// Find the first text part and update it, or create one if needed
-					foundTextPart := false
-					for _, part := range resp.Candidates[0].Content.Parts {
-						if part.Text != "" {
-							part.Text = fullText
-							foundTextPart = true
-							break
-						}
-					}
-					// If no text part exists, create one with the full text
-					if !foundTextPart && fullText != "" {
-						resp.Candidates[0].Content.Parts = append([]*genai.Part{
-							{Text: fullText},
-						}, resp.Candidates[0].Content.Parts...)
-					}
-				}
+			parts := []*genai.Part{}
+			if accumulatedText.Len() > 0 {
+				parts = append(parts, &genai.Part{Text: accumulatedText.String()})
+			}
+			parts = append(parts, streamedCalls...)
+			resp.Candidates[0].Content = &genai.Content{
+				Role:  "model",
+				Parts: parts,
 			}
 		}
 	} else {
@@ -352,6 +345,7 @@ func (v *GenaiGeminiClient) callGeminiWithMessages(ctx context.Context, systemMe
 	}
 
 	cand := resp.Candidates[0]
+	gr.StopReason = NormalizeStopReason(string(cand.FinishReason))
 	if cand.Content == nil {
 		return gr, nil
 	}
@@ -368,10 +362,22 @@ func (v *GenaiGeminiClient) callGeminiWithMessages(ctx context.Context, systemMe
 				args = part.FunctionCall.Args
 			}
 
+			// Gemini only sometimes assigns call ids - make one up so events and results can be matched
+			id := part.FunctionCall.ID
+			if id == "" {
+				id = fmt.Sprintf("gemini_call_%s", uuid.NewString())
+			}
+
 			gr.FunctionCalls = append(gr.FunctionCalls, FunctionCall{
+				ID:        id,
 				Name:      part.FunctionCall.Name,
 				Arguments: args,
 			})
+
+			// Gemini delivers function calls whole, so start and arguments are emitted together
+			argsJSON, _ := json.Marshal(args)
+			streamCtx.emit(StreamEvent{Type: EventToolCallStart, ToolCallID: id, ToolName: part.FunctionCall.Name})
+			streamCtx.emit(StreamEvent{Type: EventToolCallArgsDelta, ToolCallID: id, ArgsDelta: string(argsJSON)})
 		}
 	}
 
@@ -380,19 +386,26 @@ func (v *GenaiGeminiClient) callGeminiWithMessages(ctx context.Context, systemMe
 
 // ChatWithTools handles tool execution loop similar to Anthropic's implementation
 func (v *GenaiGeminiClient) ChatWithTools(ctx context.Context, systemMessage string, messages []Message , streamCtx *StreamingContext) (*GeminiResponse, error) {
-	const maxIterations = 8
+	maxIterations := iterationsOrDefault(v.Params)
 
 	workingMessages := make([]Message, 0, len(messages)+6)
 	workingMessages = append(workingMessages, messages...)
 
 	var lastResp *GeminiResponse
 	for iter := 0; iter < maxIterations; iter++ {
+		// Stop early if the generation was cancelled
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
 		gr, err := v.callGeminiWithMessages(ctx, systemMessage, workingMessages , streamCtx)
 		if err != nil {
 			return nil, fmt.Errorf("callGeminiWithMessages: %w", err)
 		}
 		lastResp = gr
 
+		streamCtx.emit(StreamEvent{Type: EventIterationEnd, Iteration: iter + 1, StopReason: gr.StopReason})
+
 		// If no function calls, we're done
 		if len(gr.FunctionCalls) == 0 {
 			return gr, nil
@@ -402,7 +415,7 @@ func (v *GenaiGeminiClient) ChatWithTools(ctx context.Context, systemMessage str
 		toolCalls := make([]ToolCall, len(gr.FunctionCalls))
 		for i, fc := range gr.FunctionCalls {
 			toolCalls[i] = ToolCall{
-				ID:       "", // Gemini doesn't use IDs
+				ID:       fc.ID,
 				Name:     fc.Name,
 				Input:    fc.Arguments,
 				Provider: "gemini",
@@ -463,7 +476,10 @@ func (v *GenaiGeminiClient) ChatWithTools(ctx context.Context, systemMessage str
 		time.Sleep(50 * time.Millisecond)
 	}
 
-	return lastResp, fmt.Errorf("max iterations reached (%d) while resolving tools", maxIterations)
+	// out of passes - report it as the stop reason and keep what we have
+	fmt.Printf("[gemini] max iterations reached (%d) while resolving tools\n", maxIterations)
+	lastResp.StopReason = StopReasonMaxIterations
+	return lastResp, nil
 }
 
 func (v *GenaiGeminiClient) Chat(ctx context.Context, systemMessage string, messages []Message) (string, error) {
@@ -475,33 +491,30 @@ func (v *GenaiGeminiClient) Chat(ctx context.Context, systemMessage string, mess
 		return "", err
 	}
 
-	if len(resp.TextContent) == 0 {
+	if len(resp.TextContent) == 0 && resp.StopReason != StopReasonMaxIterations {
 		return "", fmt.Errorf("gemini returned no text content")
 	}
 
 	return strings.Join(resp.TextContent, "\n\n"), nil
 }
 
-func (v *GenaiGeminiClient) ChatStream(ctx context.Context, hub *libraries.Hub, client *libraries.Client, boardId string, systemMessage string, messages []Message) (string, error) {
+func (v *GenaiGeminiClient) ChatStream(ctx context.Context, streamCtx *StreamingContext, systemMessage string, messages []Message) (string, error) {
 	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
-	var streamCtx *StreamingContext
-	if client != nil {
-		streamCtx = &StreamingContext{
-			Hub:     hub,
-			Client:  client,
-			BoardId: boardId, // Can be empty string
-		}
-	}
 	resp, err := v.ChatWithTools(ctx, systemMessage, messages, streamCtx)
 	if err != nil {
-		return "", err
+		streamCtx.emitFinish("", err)
+		// return whatever was already streamed so the caller can keep the partial answer
+		return streamCtx.StreamedText(), err
 	}
 
-	if len(resp.TextContent) == 0 {
-		return "", fmt.Errorf("gemini returned no text content")
+	if len(resp.TextContent) == 0 && resp.StopReason != StopReasonMaxIterations {
+		err = fmt.Errorf("gemini returned no text content")
+		streamCtx.emitFinish("", err)
+		return "", err
 	}
 
-	return strings.Join(resp.TextContent, "\n\n"), nil
+	streamCtx.emitFinish(resp.StopReason, nil)
+	return streamCtx.finalText(strings.Join(resp.TextContent, "\n\n")), nil
 }
@@ -0,0 +1,190 @@
+package llmHandlers
+
+import (
+	"fmt"
+	"melina-studio-backend/internal/models"
+	"os"
+	"strconv"
+)
+
+// Normalized stop reasons reported in iteration_end and done events
+const (
+	StopReasonEndTurn       = "end_turn"
+	StopReasonMaxTokens     = "max_tokens"
+	StopReasonStopSequence  = "stop_sequence"
+	StopReasonToolUse       = "tool_use"
+	StopReasonMaxIterations = "max_iterations" // the tool loop ran out of passes
+)
+
+// defaults used when neither the config, the board nor the request sets a param
+const (
+	defaultMaxTokens     = 4096
+	defaultMaxIterations = 10
+	defaultTemperature   = 0.2
+)
+
+// admin bounds, overridable with GENERATION_MAX_TOKENS_LIMIT and GENERATION_MAX_ITERATIONS_LIMIT
+const (
+	defaultMaxTokensLimit     = 8192
+	defaultMaxIterationsLimit = 25
+	maxStopSequences          = 4
+	// widest temperature any provider accepts; New narrows it to the provider's range
+	maxTemperature = 2.0
+)
+
+// GenerationLimits are the admin-defined bounds every param set is clamped to
+type GenerationLimits struct {
+	MaxTokens     int
+	MaxIterations int
+}
+
+// GenerationLimitsFromEnv reads the admin bounds from the environment
+func GenerationLimitsFromEnv() GenerationLimits {
+	return GenerationLimits{
+		MaxTokens:     envInt("GENERATION_MAX_TOKENS_LIMIT", defaultMaxTokensLimit),
+		MaxIterations: envInt("GENERATION_MAX_ITERATIONS_LIMIT", defaultMaxIterationsLimit),
+	}
+}
+
+// DefaultGenerationParams returns the params used when nothing else is configured
+func DefaultGenerationParams() models.GenerationParams {
+	temperature := defaultTemperature
+	return models.GenerationParams{
+		MaxTokens:     defaultMaxTokens,
+		Temperature:   &temperature,
+		MaxIterations: defaultMaxIterations,
+	}
+}
+
+// ResolveGenerationParams layers the overrides (later ones win) on top of the
+// defaults and clamps the result to the admin bounds. Setting only top_p drops the
+// default temperature, as some providers refuse both in one request.
+func ResolveGenerationParams(overrides ...models.GenerationParams) models.GenerationParams {
+	params := DefaultGenerationParams()
+	temperatureSet := false
+	for _, o := range overrides {
+		params = mergeGenerationParams(params, o)
+		temperatureSet = temperatureSet || o.Temperature != nil
+	}
+	if params.TopP != nil && !temperatureSet {
+		params.Temperature = nil
+	}
+	return ClampGenerationParams(params, GenerationLimitsFromEnv())
+}
+
+// mergeGenerationParams returns base with every field set in override replaced
+func mergeGenerationParams(base, override models.GenerationParams) models.GenerationParams {
+	if override.MaxTokens > 0 {
+		base.MaxTokens = override.MaxTokens
+	}
+	if override.Temperature != nil {
+		base.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		base.TopP = override.TopP
+	}
+	if len(override.StopSequences) > 0 {
+		base.StopSequences = override.StopSequences
+	}
+	if override.MaxIterations > 0 {
+		base.MaxIterations = override.MaxIterations
+	}
+	return base
+}
+
+// ClampGenerationParams keeps params inside the limits; temperature is kept in [0, 2] and top_p in [0, 1]
+func ClampGenerationParams(params models.GenerationParams, limits GenerationLimits) models.GenerationParams {
+	if limits.MaxTokens > 0 && params.MaxTokens > limits.MaxTokens {
+		params.MaxTokens = limits.MaxTokens
+	}
+	if limits.MaxIterations > 0 && params.MaxIterations > limits.MaxIterations {
+		params.MaxIterations = limits.MaxIterations
+	}
+	if params.Temperature != nil {
+		t := clampRange(*params.Temperature, maxTemperature)
+		params.Temperature = &t
+	}
+	if params.TopP != nil {
+		p := clampRange(*params.TopP, 1)
+		params.TopP = &p
+	}
+	if len(params.StopSequences) > maxStopSequences {
+		params.StopSequences = params.StopSequences[:maxStopSequences]
+	}
+	return params
+}
+
+// MaxTemperatureFor returns the highest temperature the provider accepts:
+// 1 for Claude, 2 for OpenAI, Groq, OpenAI-compatible servers and Gemini
+func MaxTemperatureFor(provider Provider) float64 {
+	switch provider {
+	case ProviderAnthropic, ProviderVertexAnthropic:
+		return 1
+	default:
+		return maxTemperature
+	}
+}
+
+// clampForProvider narrows the temperature of params to the range of the provider.
+// Claude accepts temperature or top_p, not both; an explicit temperature wins.
+func clampForProvider(params models.GenerationParams, provider Provider) models.GenerationParams {
+	if params.Temperature != nil {
+		t := clampRange(*params.Temperature, MaxTemperatureFor(provider))
+		params.Temperature = &t
+	}
+	if (provider == ProviderAnthropic || provider == ProviderVertexAnthropic) && params.Temperature != nil && params.TopP != nil {
+		fmt.Printf("[params] %s accepts temperature or top_p, ignoring top_p\n", provider)
+		params.TopP = nil
+	}
+	return params
+}
+
+// iterationsOrDefault returns the max tool loop passes for params
+func iterationsOrDefault(params models.GenerationParams) int {
+	if params.MaxIterations > 0 {
+		return params.MaxIterations
+	}
+	return defaultMaxIterations
+}
+
+// maxTokensOrDefault returns the max output tokens for params
+func maxTokensOrDefault(params models.GenerationParams) int {
+	if params.MaxTokens > 0 {
+		return params.MaxTokens
+	}
+	return defaultMaxTokens
+}
+
+// NormalizeStopReason maps provider specific stop reasons onto the StopReason constants
+func NormalizeStopReason(reason string) string {
+	switch reason {
+	case "end_turn", "stop", "STOP", "FINISH_REASON_STOP":
+		return StopReasonEndTurn
+	case "max_tokens", "length", "MAX_TOKENS":
+		return StopReasonMaxTokens
+	case "stop_sequence":
+		return StopReasonStopSequence
+	case "tool_use", "tool_calls", "function_call":
+		return StopReasonToolUse
+	default:
+		return reason
+	}
+}
+
+// clampRange keeps v in [0, max]
+func clampRange(v float64, max float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+func envInt(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return fallback
+}
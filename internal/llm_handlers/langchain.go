@@ -4,29 +4,20 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"melina-studio-backend/internal/libraries"
+	"melina-studio-backend/internal/models"
 	"reflect"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/llms/openai"
 )
 
 type LangChainClient struct {
-	llm   llms.Model
-	Tools []map[string]interface{}
-}
-
-// StreamingContext holds the context needed for streaming responses
-type StreamingContext struct {
-	Hub     *libraries.Hub
-	Client  *libraries.Client
-	BoardId string // Optional: empty string means don't include boardId in response
-	// BufferedChunks stores chunks that should be sent only if there are no tool calls
-	BufferedChunks []string
-	// ShouldStream indicates whether chunks should be streamed immediately or buffered
-	ShouldStream bool
+	llm    llms.Model
+	Tools  []map[string]interface{}
+	Params models.GenerationParams
 }
 
 type LangChainConfig struct {
@@ -34,6 +25,7 @@ type LangChainConfig struct {
 	BaseURL string                 // optional: for Groq or other OpenAI-compatible APIs
 	APIKey  string                 // if not set, it'll fall back to env
 	Tools   []map[string]interface{} // Tool definitions in OpenAI format
+	Params  models.GenerationParams
 }
 
 // LangChainResponse contains the parsed response from LangChain
@@ -41,10 +33,23 @@ type LangChainResponse struct {
 	TextContent   []string
 	FunctionCalls []LangChainFunctionCall
 	RawResponse   *llms.ContentResponse
+	StopReason    string // set when the tool loop stops for its own reasons, e.g. max_iterations
+}
+
+// stopReason returns the normalized reason the response ended
+func (r *LangChainResponse) stopReason() string {
+	if r.StopReason != "" {
+		return r.StopReason
+	}
+	if r.RawResponse != nil && len(r.RawResponse.Choices) > 0 {
+		return NormalizeStopReason(r.RawResponse.Choices[0].StopReason)
+	}
+	return ""
 }
 
 // LangChainFunctionCall represents a function call from LangChain (OpenAI-compatible)
 type LangChainFunctionCall struct {
+	ID        string // tool call id, results must reference it
 	Name      string
 	Arguments map[string]interface{}
 }
@@ -60,6 +65,9 @@ func NewLangChainClient(cfg LangChainConfig) (*LangChainClient, error) {
 	if cfg.APIKey != "" {
 		opts = append(opts, openai.WithToken(cfg.APIKey))
 	}
+	if httpClient := withCassette(nil); httpClient != nil {
+		opts = append(opts, openai.WithHTTPClient(httpClient))
+	}
 
 	llm, err := openai.New(opts...)
 	if err != nil {
@@ -67,8 +75,9 @@ func NewLangChainClient(cfg LangChainConfig) (*LangChainClient, error) {
 	}
 
 	return &LangChainClient{
-		llm:   llm,
-		Tools: cfg.Tools,
+		llm:    llm,
+		Tools:  cfg.Tools,
+		Params: cfg.Params,
 	}, nil
 }
 
@@ -106,6 +115,12 @@ func (c *LangChainClient) convertMessagesToLangChainContent(messages []Message)
 	msgContents := make([]llms.MessageContent, 0, len(messages))
 
 	for _, m := range messages {
+		// tool results become one tool message per call
+		if toolMessages, ok := langChainToolResultMessages(m); ok {
+			msgContents = append(msgContents, toolMessages...)
+			continue
+		}
+
 		var msgType llms.ChatMessageType
 		switch m.Role {
 		case "system":
@@ -148,25 +163,19 @@ func (c *LangChainClient) convertMessagesToLangChainContent(messages []Message)
 						parts = append(parts, llms.ImageURLPart(dataURI))
 					}
 
-				case "function_call":
-					// Handle function call from assistant
-					// Note: langchaingo may handle this differently, we'll parse from response instead
-					if fn, ok := block["function"].(map[string]interface{}); ok {
-						name, _ := fn["name"].(string)
-						arguments, _ := fn["arguments"].(map[string]interface{})
-						argsJSON, _ := json.Marshal(arguments)
-						// Convert to text representation for now
-						parts = append(parts, llms.TextPart(fmt.Sprintf("Function call: %s with args: %s", name, string(argsJSON))))
-					}
-
-				case "function_response":
-					// Handle function response (tool result)
-					// Note: langchaingo may handle this differently, we'll format as text for now
-					if fn, ok := block["function"].(map[string]interface{}); ok {
-						name, _ := fn["name"].(string)
-						responseStr, _ := fn["response"].(string)
-						parts = append(parts, llms.TextPart(fmt.Sprintf("Function response for %s: %s", name, responseStr)))
-					}
+				case "tool_use":
+					// Tool call made by the assistant - langchaingo sends it as a native tool call
+					id, _ := block["id"].(string)
+					name, _ := block["name"].(string)
+					argsJSON, _ := json.Marshal(block["input"])
+					parts = append(parts, llms.ToolCall{
+						ID:   id,
+						Type: "function",
+						FunctionCall: &llms.FunctionCall{
+							Name:      name,
+							Arguments: string(argsJSON),
+						},
+					})
 				}
 			}
 
@@ -203,39 +212,51 @@ func (c *LangChainClient) callLangChainWithMessages(ctx context.Context, systemM
 	// Convert tools to langchaingo format
 	langChainTools := convertToolsToLangChainTools(c.Tools)
 
+	// Tool call deltas arrive on the same streaming func as text, encoded as a JSON array.
+	// Split them out so only real text reaches the client as text.
+	var lastToolCallID string
 	streamingFunc := func(ctx context.Context, chunk []byte) error {
-		// Only handle streaming if client is provided
-		if streamCtx != nil && streamCtx.Client != nil {
-			chunkStr := string(chunk)
-			
-			if streamCtx.ShouldStream {
-				// Stream immediately (final iteration, no tool calls)
-				payload := &libraries.ChatMessageResponsePayload{
-					Message: chunkStr,
+		if len(chunk) == 0 {
+			return nil
+		}
+		if deltas, ok := parseLangChainToolCallDeltas(chunk); ok {
+			for _, d := range deltas {
+				if d.Type != "" {
+					// a new tool call starts
+					lastToolCallID = d.ID
+					streamCtx.emit(StreamEvent{Type: EventToolCallStart, ToolCallID: d.ID, ToolName: d.Function.Name})
 				}
-				// Only include BoardId if it's not empty
-				if streamCtx.BoardId != "" {
-					payload.BoardId = streamCtx.BoardId
+				if d.Function.Arguments != "" {
+					streamCtx.emit(StreamEvent{Type: EventToolCallArgsDelta, ToolCallID: lastToolCallID, ArgsDelta: d.Function.Arguments})
 				}
-				libraries.SendChatMessageResponse(streamCtx.Hub, streamCtx.Client, libraries.WebSocketMessageTypeChatResponse, payload)
-			} else {
-				// Buffer chunks (intermediate iteration, might have tool calls)
-				streamCtx.BufferedChunks = append(streamCtx.BufferedChunks, chunkStr)
 			}
+			return nil
 		}
+		streamCtx.emitText(string(chunk))
 		return nil
 	}
 
 	// Build call options
-	opts := []llms.CallOption{}
+	opts := []llms.CallOption{
+		llms.WithMaxTokens(maxTokensOrDefault(c.Params)),
+	}
+	if c.Params.Temperature != nil {
+		opts = append(opts, llms.WithTemperature(*c.Params.Temperature))
+	}
+	if c.Params.TopP != nil {
+		opts = append(opts, llms.WithTopP(*c.Params.TopP))
+	}
+	if len(c.Params.StopSequences) > 0 {
+		opts = append(opts, llms.WithStopWords(c.Params.StopSequences))
+	}
 	if len(langChainTools) > 0 {
 		// WithFunctions expects a single slice, not variadic
 		opts = append(opts, llms.WithFunctions(langChainTools))
+	}
 
-		// Enable streaming if streaming context is provided
-		if streamCtx != nil && streamCtx.Client != nil {
-			opts = append(opts, llms.WithStreamingFunc(streamingFunc))
-		}
+	// Enable streaming if anyone listens to the events
+	if streamCtx.IsStreaming() {
+		opts = append(opts, llms.WithStreamingFunc(streamingFunc))
 	}
 
 	// Call GenerateContent
@@ -289,6 +310,7 @@ func (c *LangChainClient) callLangChainWithMessages(ctx context.Context, systemM
 				}
 				
 				lr.FunctionCalls = append(lr.FunctionCalls, LangChainFunctionCall{
+					ID:        toolCall.ID,
 					Name:      toolCall.FunctionCall.Name,
 					Arguments: args,
 				})
@@ -326,6 +348,10 @@ func (c *LangChainClient) callLangChainWithMessages(ctx context.Context, systemM
 								
 								if nameField.IsValid() && nameField.Kind() == reflect.String {
 									name := nameField.String()
+									id := ""
+									if idField := elem.FieldByName("ID"); idField.IsValid() && idField.Kind() == reflect.String {
+										id = idField.String()
+									}
 									var args map[string]interface{}
 									
 									// Arguments is a string (JSON), not []byte
@@ -341,6 +367,7 @@ func (c *LangChainClient) callLangChainWithMessages(ctx context.Context, systemM
 									}
 									
 									lr.FunctionCalls = append(lr.FunctionCalls, LangChainFunctionCall{
+										ID:        id,
 										Name:      name,
 										Arguments: args,
 									})
@@ -358,63 +385,49 @@ func (c *LangChainClient) callLangChainWithMessages(ctx context.Context, systemM
 		}
 	}
 
+	// Some OpenAI-compatible servers omit tool call ids - results can't be matched without one
+	for i := range lr.FunctionCalls {
+		if lr.FunctionCalls[i].ID == "" {
+			lr.FunctionCalls[i].ID = "call_" + uuid.New().String()
+		}
+	}
+
 	return lr, nil
 }
 
 // ChatWithTools handles tool execution loop similar to Anthropic's and Gemini's implementation
 func (c *LangChainClient) ChatWithTools(ctx context.Context, systemMessage string, messages []Message, streamCtx *StreamingContext) (*LangChainResponse, error) {
-	const maxIterations = 8
+	maxIterations := iterationsOrDefault(c.Params)
 
 	workingMessages := make([]Message, 0, len(messages)+6)
 	workingMessages = append(workingMessages, messages...)
 
 	var lastResp *LangChainResponse
 	for iter := 0; iter < maxIterations; iter++ {
-		// Prepare streaming context for this iteration
-		var currentStreamCtx *StreamingContext
-		if streamCtx != nil && streamCtx.Client != nil {
-			// Create a copy to avoid modifying the original
-			currentStreamCtx = &StreamingContext{
-				Hub:            streamCtx.Hub,
-				Client:         streamCtx.Client,
-				BoardId:        streamCtx.BoardId,
-				BufferedChunks: make([]string, 0),
-				ShouldStream:   false, // Start with buffering - we'll decide after the call
-			}
+		// Stop early if the generation was cancelled
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
-		
-		// Make the call with streaming enabled (but buffered)
-		lr, err := c.callLangChainWithMessages(ctx, systemMessage, workingMessages, currentStreamCtx)
+
+		lr, err := c.callLangChainWithMessages(ctx, systemMessage, workingMessages, streamCtx)
 		if err != nil {
 			return nil, fmt.Errorf("callLangChainWithMessages: %w", err)
 		}
 		lastResp = lr
 
-		// If no function calls, this is the final iteration - send buffered chunks
+		stopReason := lr.stopReason()
+		streamCtx.emit(StreamEvent{Type: EventIterationEnd, Iteration: iter + 1, StopReason: stopReason})
+
+		// If no function calls, we're done
 		if len(lr.FunctionCalls) == 0 {
-			// Final iteration - send all buffered chunks to the client
-			if currentStreamCtx != nil && len(currentStreamCtx.BufferedChunks) > 0 {
-				for _, chunk := range currentStreamCtx.BufferedChunks {
-					payload := &libraries.ChatMessageResponsePayload{
-						Message: chunk,
-					}
-					if currentStreamCtx.BoardId != "" {
-						payload.BoardId = currentStreamCtx.BoardId
-					}
-					libraries.SendChatMessageResponse(currentStreamCtx.Hub, currentStreamCtx.Client, libraries.WebSocketMessageTypeChatResponse, payload)
-				}
-			}
 			return lr, nil
 		}
-		
-		// There are tool calls - discard buffered chunks (they were tool-related)
-		// The buffered chunks will be ignored since we're in an intermediate iteration
 
 		// Convert FunctionCalls to common ToolCall format
 		toolCalls := make([]ToolCall, len(lr.FunctionCalls))
 		for i, fc := range lr.FunctionCalls {
 			toolCalls[i] = ToolCall{
-				ID:       "", // LangChain/OpenAI doesn't use IDs in the same way
+				ID:       fc.ID,
 				Name:     fc.Name,
 				Input:    fc.Arguments,
 				Provider: "langchain",
@@ -422,7 +435,7 @@ func (c *LangChainClient) ChatWithTools(ctx context.Context, systemMessage strin
 		}
 
 		// Execute tools using common executor
-		execResults := ExecuteTools(ctx, toolCalls, currentStreamCtx)
+		execResults := ExecuteTools(ctx, toolCalls, streamCtx)
 
 		// Format results for LangChain (OpenAI-compatible)
 		functionResults := []map[string]interface{}{}
@@ -444,11 +457,10 @@ func (c *LangChainClient) ChatWithTools(ctx context.Context, systemMessage strin
 		}
 		for _, fc := range lr.FunctionCalls {
 			assistantParts = append(assistantParts, map[string]interface{}{
-				"type": "function_call",
-				"function": map[string]interface{}{
-					"name":      fc.Name,
-					"arguments": fc.Arguments,
-				},
+				"type":  "tool_use",
+				"id":    fc.ID,
+				"name":  fc.Name,
+				"input": fc.Arguments,
 			})
 		}
 		workingMessages = append(workingMessages, Message{
@@ -456,9 +468,9 @@ func (c *LangChainClient) ChatWithTools(ctx context.Context, systemMessage strin
 			Content: assistantParts,
 		})
 
-		// Append user message with function results
+		// Append the tool results - converted to one tool message per call
 		workingMessages = append(workingMessages, Message{
-			Role:    "user",
+			Role:    "tool",
 			Content: functionResults,
 		})
 
@@ -475,7 +487,10 @@ func (c *LangChainClient) ChatWithTools(ctx context.Context, systemMessage strin
 		time.Sleep(50 * time.Millisecond)
 	}
 
-	return lastResp, fmt.Errorf("max iterations reached (%d) while resolving tools", maxIterations)
+	// out of passes - report it as the stop reason and keep what we have
+	fmt.Printf("[langchain] max iterations reached (%d) while resolving tools\n", maxIterations)
+	lastResp.StopReason = StopReasonMaxIterations
+	return lastResp, nil
 }
 
 func (c *LangChainClient) Chat(ctx context.Context, systemMessage string, messages []Message) (string, error) {
@@ -490,7 +505,10 @@ func (c *LangChainClient) Chat(ctx context.Context, systemMessage string, messag
 
 	// If we have text content, return it
 	if len(resp.TextContent) > 0 {
-		return resp.TextContent[0], nil
+		return strings.Join(resp.TextContent, "\n\n"), nil
+	}
+	if resp.StopReason == StopReasonMaxIterations {
+		return "", nil
 	}
 
 	// If we have function calls but no text, that's normal for function calling
@@ -505,27 +523,27 @@ func (c *LangChainClient) Chat(ctx context.Context, systemMessage string, messag
 	return "", fmt.Errorf("langchain returned no text content and no function calls")
 }
 
-func (c *LangChainClient) ChatStream(ctx context.Context, hub *libraries.Hub, client *libraries.Client, boardId string, systemMessage string, messages []Message) (string, error) {
+func (c *LangChainClient) ChatStream(ctx context.Context, streamCtx *StreamingContext, systemMessage string, messages []Message) (string, error) {
 	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
-	// Create streaming context if client is provided
-	var streamCtx *StreamingContext
-	if client != nil {
-		streamCtx = &StreamingContext{
-			Hub:     hub,
-			Client:  client,
-			BoardId: boardId, // Can be empty string
-		}
-	}
 	resp, err := c.ChatWithTools(ctx, systemMessage, messages, streamCtx)
 	if err != nil {
-		return "", err
+		streamCtx.emitFinish("", err)
+		// return whatever was already streamed so the caller can keep the partial answer
+		return streamCtx.StreamedText(), err
 	}
 
+	stopReason := resp.stopReason()
+
 	// If we have text content, return it
 	if len(resp.TextContent) > 0 {
-		return resp.TextContent[0], nil
+		streamCtx.emitFinish(stopReason, nil)
+		return streamCtx.finalText(strings.Join(resp.TextContent, "\n\n")), nil
+	}
+	if stopReason == StopReasonMaxIterations {
+		streamCtx.emitFinish(stopReason, nil)
+		return streamCtx.finalText(""), nil
 	}
 
 	// If we have function calls but no text, that's normal for function calling
@@ -534,10 +552,64 @@ func (c *LangChainClient) ChatStream(ctx context.Context, hub *libraries.Hub, cl
 	if len(resp.FunctionCalls) > 0 {
 		// This shouldn't happen if ChatWithTools is working correctly
 		// as it should continue until there's a final text response
-		return "", fmt.Errorf("function calls were made but no final text response was generated")
+		err = fmt.Errorf("function calls were made but no final text response was generated")
+	} else {
+		err = fmt.Errorf("langchain returned no text content and no function calls")
 	}
+	streamCtx.emitFinish("", err)
+	return "", err
+}
 
-	return "", fmt.Errorf("langchain returned no text content and no function calls")
+// langChainToolResultMessages converts a message of tool_result blocks into
+// tool messages tied to their tool_call_id. Returns false for any other message.
+func langChainToolResultMessages(m Message) ([]llms.MessageContent, bool) {
+	blocks, ok := m.Content.([]map[string]interface{})
+	if !ok || len(blocks) == 0 {
+		return nil, false
+	}
+	for _, block := range blocks {
+		if blockType, _ := block["type"].(string); blockType != "tool_result" {
+			return nil, false
+		}
+	}
+
+	msgContents := make([]llms.MessageContent, 0, len(blocks))
+	for _, block := range blocks {
+		id, _ := block["tool_call_id"].(string)
+		name, _ := block["name"].(string)
+		content, _ := block["content"].(string)
+		msgContents = append(msgContents, llms.MessageContent{
+			Role: llms.ChatMessageTypeTool,
+			Parts: []llms.ContentPart{llms.ToolCallResponse{
+				ToolCallID: id,
+				Name:       name,
+				Content:    content,
+			}},
+		})
+	}
+	return msgContents, true
+}
+
+// langChainToolCallDelta mirrors the tool call deltas langchaingo passes to the streaming func
+type langChainToolCallDelta struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// parseLangChainToolCallDeltas detects a streamed chunk that carries tool call deltas instead of text
+func parseLangChainToolCallDeltas(chunk []byte) ([]langChainToolCallDelta, bool) {
+	if len(chunk) == 0 || chunk[0] != '[' {
+		return nil, false
+	}
+	var deltas []langChainToolCallDelta
+	if err := json.Unmarshal(chunk, &deltas); err != nil || len(deltas) == 0 {
+		return nil, false
+	}
+	return deltas, true
 }
 
 /*
@@ -2,7 +2,6 @@ package llmHandlers
 
 import (
 	"context"
-	"melina-studio-backend/internal/libraries"
 )
 
 type MessageRole string
@@ -15,7 +14,8 @@ const (
 
 type Client interface {
 	Chat(ctx context.Context, systemMessage string, messages []Message) (string, error)
-	ChatStream(ctx context.Context, hub *libraries.Hub, client *libraries.Client, boardId string, systemMessage string, messages []Message) (string, error)
+	// ChatStream reports progress through streamCtx events and returns the final answer
+	ChatStream(ctx context.Context, streamCtx *StreamingContext, systemMessage string, messages []Message) (string, error)
 }
 
 /*
@@ -0,0 +1,183 @@
+package llmHandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"melina-studio-backend/internal/models"
+	"os"
+	"strings"
+	"sync"
+)
+
+// MockTurn is one scripted model response
+type MockTurn struct {
+	Text       string         `json:"text,omitempty"`
+	Chunks     []string       `json:"chunks,omitempty"` // streamed in order; Text is streamed as one chunk when empty
+	ToolCalls  []MockToolCall `json:"tool_calls,omitempty"`
+	Error      string         `json:"error,omitempty"` // the call fails with this error
+	StopReason string         `json:"stop_reason,omitempty"`
+}
+
+// MockToolCall is a scripted tool call; tools run through the real registry
+type MockToolCall struct {
+	ID    string                 `json:"id,omitempty"`
+	Name  string                 `json:"name"`
+	Input map[string]interface{} `json:"input"`
+}
+
+// MockClient implements Client by playing scripted turns, one per model call.
+// It lets the workflow, agent and tools run without network access.
+type MockClient struct {
+	Params models.GenerationParams
+
+	mu       sync.Mutex
+	turns    []MockTurn
+	next     int
+	requests [][]Message
+}
+
+// NewMockClient creates a mock client. When turns is empty, the script is read
+// from the JSON file in MOCK_LLM_SCRIPT.
+func NewMockClient(turns []MockTurn, params models.GenerationParams) (*MockClient, error) {
+	if len(turns) == 0 {
+		path := os.Getenv("MOCK_LLM_SCRIPT")
+		if path == "" {
+			return nil, fmt.Errorf("mock provider needs scripted turns or MOCK_LLM_SCRIPT")
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read mock script: %w", err)
+		}
+		if err := json.Unmarshal(data, &turns); err != nil {
+			return nil, fmt.Errorf("decode mock script %s: %w", path, err)
+		}
+	}
+	return &MockClient{turns: turns, Params: params}, nil
+}
+
+// Requests returns the messages the mock received on every model call
+func (c *MockClient) Requests() [][]Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([][]Message(nil), c.requests...)
+}
+
+// nextTurn records the request and returns the next scripted turn
+func (c *MockClient) nextTurn(messages []Message) (MockTurn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.requests = append(c.requests, append([]Message(nil), messages...))
+	if c.next >= len(c.turns) {
+		return MockTurn{}, fmt.Errorf("mock script exhausted after %d turns", len(c.turns))
+	}
+	turn := c.turns[c.next]
+	c.next++
+	return turn, nil
+}
+
+// chatWithTools mirrors the providers' tool loop on top of the scripted turns
+func (c *MockClient) chatWithTools(ctx context.Context, messages []Message, streamCtx *StreamingContext) (*MockTurn, error) {
+	maxIterations := iterationsOrDefault(c.Params)
+
+	workingMessages := append([]Message(nil), messages...)
+	var lastTurn *MockTurn
+	for iter := 0; iter < maxIterations; iter++ {
+		// Stop early if the generation was cancelled
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		turn, err := c.nextTurn(workingMessages)
+		if err != nil {
+			return nil, err
+		}
+		if turn.Error != "" {
+			return nil, fmt.Errorf("mock error: %s", turn.Error)
+		}
+
+		chunks := turn.Chunks
+		if len(chunks) == 0 && turn.Text != "" {
+			chunks = []string{turn.Text}
+		}
+		for _, chunk := range chunks {
+			streamCtx.emitText(chunk)
+		}
+		if turn.Text == "" {
+			turn.Text = strings.Join(turn.Chunks, "")
+		}
+
+		stopReason := turn.StopReason
+		if stopReason == "" {
+			stopReason = StopReasonEndTurn
+			if len(turn.ToolCalls) > 0 {
+				stopReason = StopReasonToolUse
+			}
+		}
+		streamCtx.emit(StreamEvent{Type: EventIterationEnd, Iteration: iter + 1, StopReason: stopReason})
+
+		turn.StopReason = stopReason
+		lastTurn = &turn
+		if len(turn.ToolCalls) == 0 {
+			return lastTurn, nil
+		}
+
+		toolCalls := make([]ToolCall, len(turn.ToolCalls))
+		assistantParts := []map[string]interface{}{}
+		if turn.Text != "" {
+			assistantParts = append(assistantParts, map[string]interface{}{"type": "text", "text": turn.Text})
+		}
+		for i, tc := range turn.ToolCalls {
+			id := tc.ID
+			if id == "" {
+				id = fmt.Sprintf("mock_call_%d_%d", iter+1, i+1)
+			}
+			argsJSON, _ := json.Marshal(tc.Input)
+			streamCtx.emit(StreamEvent{Type: EventToolCallStart, ToolCallID: id, ToolName: tc.Name})
+			streamCtx.emit(StreamEvent{Type: EventToolCallArgsDelta, ToolCallID: id, ArgsDelta: string(argsJSON)})
+
+			toolCalls[i] = ToolCall{ID: id, Name: tc.Name, Input: tc.Input, Provider: "mock"}
+			assistantParts = append(assistantParts, map[string]interface{}{
+				"type":  "tool_use",
+				"id":    id,
+				"name":  tc.Name,
+				"input": tc.Input,
+			})
+		}
+
+		execResults := ExecuteTools(ctx, toolCalls, streamCtx)
+
+		toolResults := make([]map[string]interface{}, 0, len(execResults))
+		for _, r := range execResults {
+			toolResults = append(toolResults, FormatAnthropicToolResult(r))
+		}
+		workingMessages = append(workingMessages,
+			Message{Role: "assistant", Content: assistantParts},
+			Message{Role: "user", Content: toolResults},
+		)
+	}
+
+	// out of passes - report it as the stop reason and keep what we have
+	lastTurn.StopReason = StopReasonMaxIterations
+	return lastTurn, nil
+}
+
+func (c *MockClient) Chat(ctx context.Context, systemMessage string, messages []Message) (string, error) {
+	turn, err := c.chatWithTools(ctx, messages, nil)
+	if err != nil {
+		return "", err
+	}
+	return turn.Text, nil
+}
+
+func (c *MockClient) ChatStream(ctx context.Context, streamCtx *StreamingContext, systemMessage string, messages []Message) (string, error) {
+	turn, err := c.chatWithTools(ctx, messages, streamCtx)
+	if err != nil {
+		streamCtx.emitFinish("", err)
+		// return whatever was already streamed so the caller can keep the partial answer
+		return streamCtx.StreamedText(), err
+	}
+	streamCtx.emitFinish(turn.StopReason, nil)
+	return streamCtx.finalText(turn.Text), nil
+}
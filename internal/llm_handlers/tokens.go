@@ -0,0 +1,59 @@
+package llmHandlers
+
+// imageTokenEstimate is a rough flat cost for an image block
+const imageTokenEstimate = 1600
+
+// EstimateTokens approximates the token count of a text (about 4 characters per token)
+func EstimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// EstimateMessageTokens approximates the token count of a conversation
+func EstimateMessageTokens(messages []Message) int {
+	total := 0
+	for _, m := range messages {
+		switch content := m.Content.(type) {
+		case string:
+			total += EstimateTokens(content)
+		case []map[string]interface{}:
+			for _, block := range content {
+				switch block["type"] {
+				case "image":
+					total += imageTokenEstimate
+				default:
+					if text, ok := block["text"].(string); ok {
+						total += EstimateTokens(text)
+					}
+					if text, ok := block["content"].(string); ok {
+						total += EstimateTokens(text)
+					}
+				}
+			}
+		}
+	}
+	return total
+}
+
+// defaultHistoryTokenBudget is used for providers without their own entry
+const defaultHistoryTokenBudget = 8000
+
+// historyTokenBudgets is how much chat history each provider gets per request.
+// It leaves room for the system prompt, tool schemas, board images and the answer.
+var historyTokenBudgets = map[Provider]int{
+	ProviderVertexAnthropic:  24000,
+	ProviderAnthropic:        24000,
+	ProviderLangChainOpenAI:  24000,
+	ProviderGemini:           32000,
+	ProviderLangChainGroq:    6000,
+	ProviderOpenAICompatible: 4000,
+}
+
+// HistoryTokenBudget returns the chat history budget of a provider.
+// CHAT_HISTORY_TOKEN_BUDGET overrides it for every provider.
+func HistoryTokenBudget(provider Provider) int {
+	budget, ok := historyTokenBudgets[provider]
+	if !ok {
+		budget = defaultHistoryTokenBudget
+	}
+	return envInt("CHAT_HISTORY_TOKEN_BUDGET", budget)
+}
@@ -6,24 +6,53 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 )
 
 // ToolHandler is the function signature for tool handlers.
 // Input is the tool input as map[string]interface{} and it returns any result or an error.
 type ToolHandler func(ctx context.Context, input map[string]interface{}) (interface{}, error)
 
+// ToolConcurrency declares how a tool may run alongside other calls of the same batch
+type ToolConcurrency int
+
+const (
+	// ToolSerial tools run alone, after every earlier call of the batch finished (default)
+	ToolSerial ToolConcurrency = iota
+	// ToolReadOnly tools don't change anything and may run concurrently with each other
+	ToolReadOnly
+	// ToolCommutative tools change state, but the outcome doesn't depend on their order
+	ToolCommutative
+)
+
+// defaultMaxToolWorkers is used when MAX_TOOL_WORKERS is not set
+const defaultMaxToolWorkers = 4
+
+// registeredTool is a handler together with its concurrency mode
+type registeredTool struct {
+	handler     ToolHandler
+	concurrency ToolConcurrency
+}
+
 // toolHandlers is the registry that maps tool name -> handler.
 var (
 	toolHandlersMu sync.RWMutex
-	toolHandlers   = make(map[string]ToolHandler)
+	toolHandlers   = make(map[string]registeredTool)
 )
 
 // RegisterTool registers a ToolHandler under the given name.
 // If a handler already exists, it will be overwritten.
+// The tool is executed serially; use RegisterToolWithConcurrency to allow parallel calls.
 func RegisterTool(name string, h ToolHandler) {
+	RegisterToolWithConcurrency(name, ToolSerial, h)
+}
+
+// RegisterToolWithConcurrency registers a ToolHandler that may run concurrently
+// with other calls according to the given mode.
+func RegisterToolWithConcurrency(name string, concurrency ToolConcurrency, h ToolHandler) {
 	toolHandlersMu.Lock()
 	defer toolHandlersMu.Unlock()
-	toolHandlers[name] = h
+	toolHandlers[name] = registeredTool{handler: h, concurrency: concurrency}
 }
 
 // UnregisterTool removes a registered tool handler.
@@ -37,8 +66,20 @@ func UnregisterTool(name string) {
 func getToolHandler(name string) (ToolHandler, bool) {
 	toolHandlersMu.RLock()
 	defer toolHandlersMu.RUnlock()
-	h, ok := toolHandlers[name]
-	return h, ok
+	t, ok := toolHandlers[name]
+	return t.handler, ok
+}
+
+// getToolConcurrency returns the concurrency mode of a tool; unknown tools are serial
+func getToolConcurrency(name string) ToolConcurrency {
+	toolHandlersMu.RLock()
+	defer toolHandlersMu.RUnlock()
+	return toolHandlers[name].concurrency
+}
+
+// maxToolWorkers reads the worker limit for parallel tool calls from MAX_TOOL_WORKERS
+func maxToolWorkers() int {
+	return envInt("MAX_TOOL_WORKERS", defaultMaxToolWorkers)
 }
 
 // ToolCall represents a generic tool call that can be used across providers
@@ -57,6 +98,7 @@ type ToolExecutionResult struct {
 	Error      error                  // Error if execution failed
 	HasImage   bool                   // Whether result contains image content
 	ImageData  *ImageContent          // Image data if HasImage is true
+	Duration   time.Duration          // How long the handler ran
 }
 
 // ImageContent contains image data extracted from tool results
@@ -67,103 +109,183 @@ type ImageContent struct {
 	MediaType string
 }
 
-// ExecuteTools executes a batch of tool calls and returns results
+// ExecuteTools executes a batch of tool calls and returns results.
+// Consecutive calls of read-only (or commutative) tools run concurrently, serial
+// tools act as barriers. Results and tool_result events keep the order of toolCalls.
 func ExecuteTools(ctx context.Context, toolCalls []ToolCall , streamCtx *StreamingContext) []ToolExecutionResult {
-	results := make([]ToolExecutionResult, 0, len(toolCalls))
+	results := make([]ToolExecutionResult, len(toolCalls))
 
 	// Pass StreamingContext through context if available
 	if streamCtx != nil {
 		ctx = context.WithValue(ctx, "streamingContext", streamCtx)
 	}
 
-	for _, tc := range toolCalls {
-		result := ToolExecutionResult{
-			ToolCallID: tc.ID,
-			ToolName:   tc.Name,
-		}
+	workers := make(chan struct{}, maxToolWorkers())
 
-		// Handle empty input (streaming artifact) - return error result instead of skipping
-		// This is important because Claude requires a tool_result for every tool_use
-		if len(tc.Input) == 0 {
-			result.Error = fmt.Errorf("tool input was empty (streaming artifact) - please retry with valid parameters")
-			results = append(results, result)
-			fmt.Printf("[%s] EMPTY INPUT for tool %s (id=%s) - returning error result\n", tc.Provider, tc.Name, tc.ID)
-			continue
+	for waveStart := 0; waveStart < len(toolCalls); {
+		// a wave is a run of calls that may execute together
+		mode := getToolConcurrency(toolCalls[waveStart].Name)
+		waveEnd := waveStart + 1
+		if mode != ToolSerial {
+			for waveEnd < len(toolCalls) && getToolConcurrency(toolCalls[waveEnd].Name) == mode {
+				waveEnd++
+			}
 		}
 
-		// Find handler
-		handler, ok := getToolHandler(tc.Name)
-		if !ok {
-			result.Error = fmt.Errorf("unknown tool: %s", tc.Name)
-			results = append(results, result)
-			fmt.Printf("[%s] UNKNOWN TOOL: %s\n", tc.Provider, tc.Name)
-			continue
+		done := make([]chan struct{}, waveEnd-waveStart)
+		for i := waveStart; i < waveEnd; i++ {
+			done[i-waveStart] = make(chan struct{})
+			go func(i int) {
+				defer close(done[i-waveStart])
+				workers <- struct{}{}
+				defer func() { <-workers }()
+				results[i] = executeToolCall(ctx, toolCalls[i], i+1, len(toolCalls), streamCtx)
+			}(i)
 		}
 
-		// Ensure input is map[string]interface{}
-		input := make(map[string]interface{})
-		if tc.Input != nil {
-			for k, v := range tc.Input {
-				input[k] = v
-			}
+		// every result (including errors) is reported as a tool_result event, in the model's order
+		for i := waveStart; i < waveEnd; i++ {
+			<-done[i-waveStart]
+			streamCtx.emit(StreamEvent{
+				Type:       EventToolResult,
+				ToolCallID: results[i].ToolCallID,
+				ToolName:   results[i].ToolName,
+				Result:     &results[i],
+				ToolInput:  toolCalls[i].Input,
+				ToolIndex:  i + 1,
+				ToolTotal:  len(toolCalls),
+			})
 		}
 
-		fmt.Printf("[%s] executing tool: %s", tc.Provider, tc.Name)
-		if tc.ID != "" {
-			fmt.Printf(" (id=%s)", tc.ID)
+		waveStart = waveEnd
+	}
+
+	return results
+}
+
+// executeToolCall runs a single tool call; index is its 1-based position in a batch of total calls
+func executeToolCall(ctx context.Context, tc ToolCall, index int, total int, streamCtx *StreamingContext) ToolExecutionResult {
+	result := ToolExecutionResult{
+		ToolCallID: tc.ID,
+		ToolName:   tc.Name,
+	}
+
+	// Skip pending tools once the generation is cancelled - still return a result
+	// for each call so the tool_use/tool_result pairing stays intact
+	if err := ctx.Err(); err != nil {
+		result.Error = fmt.Errorf("tool execution cancelled: %w", err)
+		return result
+	}
+
+	// Handle empty input (streaming artifact) - return error result instead of skipping
+	// This is important because Claude requires a tool_result for every tool_use
+	if len(tc.Input) == 0 {
+		result.Error = fmt.Errorf("tool input was empty (streaming artifact) - please retry with valid parameters")
+		fmt.Printf("[%s] EMPTY INPUT for tool %s (id=%s) - returning error result\n", tc.Provider, tc.Name, tc.ID)
+		return result
+	}
+
+	// Find handler
+	handler, ok := getToolHandler(tc.Name)
+	if !ok {
+		result.Error = fmt.Errorf("unknown tool: %s", tc.Name)
+		fmt.Printf("[%s] UNKNOWN TOOL: %s\n", tc.Provider, tc.Name)
+		return result
+	}
+
+	// Ensure input is map[string]interface{}
+	input := make(map[string]interface{})
+	if tc.Input != nil {
+		for k, v := range tc.Input {
+			input[k] = v
 		}
-		fmt.Printf(" with input=%#v\n", input)
-
-		// Execute handler with panic recovery
-		var execResult interface{}
-		var handlerErr error
-		func() {
-			defer func() {
-				if r := recover(); r != nil {
-					handlerErr = fmt.Errorf("tool execution panicked: %v", r)
-					fmt.Printf("[%s] PANIC in tool %s: %v\n", tc.Provider, tc.Name, r)
-				}
-			}()
-			
-			execResult, handlerErr = handler(ctx, input)
+	}
+
+	idSuffix := ""
+	if tc.ID != "" {
+		idSuffix = fmt.Sprintf(" (id=%s)", tc.ID)
+	}
+	fmt.Printf("[%s] executing tool: %s%s with input=%#v\n", tc.Provider, tc.Name, idSuffix, input)
+
+	streamCtx.emit(StreamEvent{
+		Type:       EventToolStart,
+		ToolCallID: tc.ID,
+		ToolName:   tc.Name,
+		ToolInput:  input,
+		ToolIndex:  index,
+		ToolTotal:  total,
+	})
+
+	// Execute handler with panic recovery
+	var execResult interface{}
+	var handlerErr error
+	startedAt := time.Now()
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				handlerErr = fmt.Errorf("tool execution panicked: %v", r)
+				fmt.Printf("[%s] PANIC in tool %s: %v\n", tc.Provider, tc.Name, r)
+			}
 		}()
-		
-		// Handle errors (but don't stop the workflow - continue with other tools)
-		if handlerErr != nil {
-			result.Error = handlerErr
-			results = append(results, result)
-			fmt.Printf("[%s] ERROR in tool %s: %v (continuing with other tools)\n", tc.Provider, tc.Name, handlerErr)
-			continue
-		}
 
-		result.Result = execResult
-
-		// Check if result contains image content
-		if resultMap, ok := execResult.(map[string]interface{}); ok {
-			if hasImage, _ := resultMap["_imageContent"].(bool); hasImage {
-				result.HasImage = true
-				imageBase64, _ := resultMap["image"].(string)
-				boardId, _ := resultMap["boardId"].(string)
-				format, _ := resultMap["format"].(string)
-
-				mediaType := "image/png"
-				if format != "" {
-					mediaType = fmt.Sprintf("image/%s", format)
-				}
-
-				result.ImageData = &ImageContent{
-					BoardID:     boardId,
-					ImageBase64: imageBase64,
-					Format:      format,
-					MediaType:   mediaType,
-				}
+		execResult, handlerErr = handler(ctx, input)
+	}()
+	result.Duration = time.Since(startedAt)
+
+	// Handle errors (but don't stop the workflow - continue with other tools)
+	if handlerErr != nil {
+		result.Error = handlerErr
+		fmt.Printf("[%s] ERROR in tool %s: %v (continuing with other tools)\n", tc.Provider, tc.Name, handlerErr)
+		return result
+	}
+
+	result.Result = execResult
+
+	// Check if result contains image content
+	if resultMap, ok := execResult.(map[string]interface{}); ok {
+		if hasImage, _ := resultMap["_imageContent"].(bool); hasImage {
+			result.HasImage = true
+			imageBase64, _ := resultMap["image"].(string)
+			boardId, _ := resultMap["boardId"].(string)
+			format, _ := resultMap["format"].(string)
+
+			mediaType := "image/png"
+			if format != "" {
+				mediaType = fmt.Sprintf("image/%s", format)
 			}
-		}
 
-		results = append(results, result)
+			result.ImageData = &ImageContent{
+				BoardID:     boardId,
+				ImageBase64: imageBase64,
+				Format:      format,
+				MediaType:   mediaType,
+			}
+		}
 	}
 
-	return results
+	return result
+}
+
+// ModelResult returns the part of a tool result meant for the model and the tool trail.
+// Shapes carried for the websocket relay are left out, their ids and the message stay.
+func ModelResult(result interface{}) interface{} {
+	resultMap, ok := result.(map[string]interface{})
+	if !ok {
+		return result
+	}
+	_, created := resultMap["_shapeContent"]
+	_, updated := resultMap["_shapeUpdates"]
+	if !created && !updated {
+		return result
+	}
+	stripped := make(map[string]interface{}, len(resultMap))
+	for key, value := range resultMap {
+		if key == "shape" || key == "shapes" {
+			continue
+		}
+		stripped[key] = value
+	}
+	return stripped
 }
 
 // FormatAnthropicToolResult formats a ToolExecutionResult for Anthropic's API
@@ -211,7 +333,7 @@ func FormatAnthropicToolResult(result ToolExecutionResult) map[string]interface{
 		}
 	} else if resultMap, ok := result.Result.(map[string]interface{}); ok {
 		// Regular result - convert to string
-		b, _ := json.Marshal(resultMap)
+		b, _ := json.Marshal(ModelResult(resultMap))
 		content = string(b)
 	} else {
 		// Regular string result
@@ -268,7 +390,7 @@ func FormatGeminiToolResult(result ToolExecutionResult) (functionResponse map[st
 			},
 		)
 	} else if resultMap, ok := result.Result.(map[string]interface{}); ok {
-		resultJSON, _ = json.Marshal(resultMap)
+		resultJSON, _ = json.Marshal(ModelResult(resultMap))
 	} else {
 		resultJSON, _ = json.Marshal(result.Result)
 	}
@@ -290,11 +412,10 @@ func FormatLangChainToolResult(result ToolExecutionResult) (functionResponse map
 	if result.Error != nil {
 		resultJSON, _ := json.Marshal(map[string]string{"error": result.Error.Error()})
 		return map[string]interface{}{
-			"type": "function_response",
-			"function": map[string]interface{}{
-				"name":     result.ToolName,
-				"response": string(resultJSON),
-			},
+			"type":         "tool_result",
+			"tool_call_id": result.ToolCallID,
+			"name":         result.ToolName,
+			"content":      string(resultJSON),
 		}, imageBlocks
 	}
 
@@ -325,16 +446,15 @@ func FormatLangChainToolResult(result ToolExecutionResult) (functionResponse map
 			},
 		)
 	} else if resultMap, ok := result.Result.(map[string]interface{}); ok {
-		resultJSON, _ = json.Marshal(resultMap)
+		resultJSON, _ = json.Marshal(ModelResult(resultMap))
 	} else {
 		resultJSON, _ = json.Marshal(result.Result)
 	}
 
 	return map[string]interface{}{
-		"type": "function_response",
-		"function": map[string]interface{}{
-			"name":     result.ToolName,
-			"response": string(resultJSON),
-		},
+		"type":         "tool_result",
+		"tool_call_id": result.ToolCallID,
+		"name":         result.ToolName,
+		"content":      string(resultJSON),
 	}, imageBlocks
 }
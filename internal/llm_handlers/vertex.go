@@ -2,71 +2,43 @@ package llmHandlers
 
 import (
 	"context"
-	"melina-studio-backend/internal/libraries"
 	"melina-studio-backend/internal/models"
-	"strings"
 )
 
-// VertexAnthropicClient implements llm.Client using your libraries.ChatWithTools
+// VertexAnthropicClient implements llm.Client using Claude on Vertex AI
 type VertexAnthropicClient struct {
-	// optional config fields (project, modelID) if needed
-	Tools []map[string]interface{} // optional metadata you send to Claude
+	Model  string                   // optional: falls back to CLAUDE_VERTEX_MODEL
+	Tools  []map[string]interface{} // optional metadata you send to Claude
+	Params models.GenerationParams
 }
 
-func NewVertexAnthropicClient(tools []map[string]interface{}) *VertexAnthropicClient {
-	return &VertexAnthropicClient{Tools: tools}
+func NewVertexAnthropicClient(model string, tools []map[string]interface{}, params models.GenerationParams) *VertexAnthropicClient {
+	return &VertexAnthropicClient{Model: model, Tools: tools, Params: params}
 }
 
 // Chat returns a single string answer (convenience wrapper).
 func (c *VertexAnthropicClient) Chat(ctx context.Context, systemMessage string, messages []Message) (string, error) {
-	// Convert llmMessage -> libraries.Message
-	msgs := make([]Message, 0, len(messages))
-	for _, m := range messages {
-		msgs = append(msgs, Message{
-			Role:    models.Role(m.Role),
-			Content: m.Content,
-		})
-	}
-
-	resp, err := ChatWithTools(ctx, systemMessage, msgs, c.Tools , nil)
+	endpoint, err := NewVertexAnthropicEndpoint(ctx, c.Model, c.Params)
 	if err != nil {
 		return "", err
 	}
-	return strings.Join(resp.TextContent, "\n\n"), nil
+	return chatClaude(ctx, endpoint, c.Tools, systemMessage, messages)
 }
 
-func (c *VertexAnthropicClient) ChatStream(ctx context.Context, hub *libraries.Hub, client *libraries.Client, boardId string, systemMessage string, messages []Message) (string, error) {
-	// fmt.Print("Calling VertexAnthropicClient ChatStream")
-	// return "", fmt.Errorf("vertex anthropic chat stream not implemented")
-	// Convert llmMessage -> libraries.Message
-	msgs := make([]Message, 0, len(messages))
-	for _, m := range messages {
-		msgs = append(msgs, Message{
-			Role:    models.Role(m.Role),
-			Content: m.Content,
-		})
-	}
-
-	var streamCtx *StreamingContext
-	if client != nil {
-		streamCtx = &StreamingContext{
-			Hub:     hub,
-			Client:  client,
-			BoardId: boardId, // Can be empty string
-		}
-	}
-	resp, err := ChatWithTools(ctx, systemMessage, msgs, c.Tools, streamCtx)
+func (c *VertexAnthropicClient) ChatStream(ctx context.Context, streamCtx *StreamingContext, systemMessage string, messages []Message) (string, error) {
+	endpoint, err := NewVertexAnthropicEndpoint(ctx, c.Model, c.Params)
 	if err != nil {
+		streamCtx.emitFinish("", err)
 		return "", err
 	}
-	return strings.Join(resp.TextContent, "\n\n"), nil
+	return chatStreamClaude(ctx, endpoint, c.Tools, streamCtx, systemMessage, messages)
 }
 
 /*
 
 func initVertexAnthropic() llm.Client {
 	tools := []map[string]interface{}{} // optional metadata if you want to advertise tools
-	client := llm.NewVertexAnthropicClient(tools)
+	client := llm.NewVertexAnthropicClient("", tools, models.GenerationParams{})
 	return client
 }
 
@@ -3,9 +3,8 @@ package agents
 import (
 	"context"
 	"fmt"
-	"log"
-	"melina-studio-backend/internal/libraries"
 	llmHandlers "melina-studio-backend/internal/llm_handlers"
+	"melina-studio-backend/internal/melina/helpers"
 	"melina-studio-backend/internal/melina/prompts"
 	"melina-studio-backend/internal/melina/tools"
 	"melina-studio-backend/internal/models"
@@ -16,7 +15,36 @@ type Agent struct {
 	llmClient llmHandlers.Client
 }
 
-func NewAgent(provider string) *Agent {
+// NewAgent creates an agent for the provider; params override the generation defaults
+func NewAgent(provider string, params models.GenerationParams) (*Agent, error) {
+	cfg, err := buildConfig(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Params = params
+	llmClient, err := llmHandlers.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize LLM client (%s): %w", provider, err)
+	}
+
+	return &Agent{
+		llmClient: llmClient,
+	}, nil
+}
+
+// ValidateProvider returns an error if a client may not ask for provider.
+// The scripted mock provider is only allowed when ALLOW_MOCK_PROVIDER=true (tests, dev).
+func ValidateProvider(provider string) error {
+	if provider == "mock" && os.Getenv("ALLOW_MOCK_PROVIDER") != "true" {
+		return fmt.Errorf("provider mock is not available")
+	}
+	_, err := buildConfig(provider)
+	return err
+}
+
+// buildConfig returns the client config of a provider, with its board tools
+func buildConfig(provider string) (llmHandlers.Config, error) {
 	var cfg llmHandlers.Config
 
 	switch provider {
@@ -43,36 +71,57 @@ func NewAgent(provider string) *Agent {
 		tools := tools.GetAnthropicTools()
 		cfg = llmHandlers.Config{
 			Provider: llmHandlers.ProviderVertexAnthropic,
+			Model:    os.Getenv("CLAUDE_VERTEX_MODEL"),
 			Tools:    tools,
 		}
+
+	case "anthropic":
+		cfg = llmHandlers.Config{
+			Provider: llmHandlers.ProviderAnthropic,
+			Model:    os.Getenv("ANTHROPIC_MODEL"),
+			APIKey:   os.Getenv("ANTHROPIC_API_KEY"),
+			Tools:    tools.GetAnthropicTools(),
+		}
+
+	case "openai_compatible":
+		cfg = llmHandlers.Config{
+			Provider: llmHandlers.ProviderOpenAICompatible,
+			Model:    os.Getenv("LOCAL_LLM_MODEL"),
+			BaseURL:  os.Getenv("LOCAL_LLM_BASE_URL"),
+			APIKey:   os.Getenv("LOCAL_LLM_API_KEY"),
+			Tools:    tools.GetOpenAITools(),
+		}
 	case "gemini":
 		cfg = llmHandlers.Config{
 			Provider: llmHandlers.ProviderGemini,
 			Tools:    tools.GetGeminiTools(),
 		}
 
-	default:
-		log.Fatalf("Unknown provider: %s. Valid options: openai, groq, vertex_anthropic", provider)
-	}
+	case "mock":
+		cfg = llmHandlers.Config{
+			Provider: llmHandlers.ProviderMock,
+			Tools:    tools.GetAnthropicTools(),
+		}
 
-	llmClient, err := llmHandlers.New(cfg)
-	if err != nil {
-		log.Fatalf("Failed to initialize LLM client (%s): %v", provider, err)
+	default:
+		return cfg, fmt.Errorf("Unknown provider: %s. Valid options: openai, groq, vertex_anthropic, anthropic, openai_compatible, gemini, mock", provider)
 	}
 
-	return &Agent{
-		llmClient: llmClient,
-	}
+	return cfg, nil
 }
 
 // ProcessRequest processes a user message with optional board image
 // boardId can be empty string if no image should be included
-func (a *Agent) ProcessRequest(ctx context.Context, message string, chatHistory []llmHandlers.Message, boardId string) (string, error) {
+// images are attached to the user message, they can be nil
+func (a *Agent) ProcessRequest(ctx context.Context, message string, images []helpers.ImageAttachment, chatHistory []llmHandlers.Message, boardId string) (string, error) {
 	// Build messages for the LLM
 	systemMessage := fmt.Sprintf(prompts.MASTER_PROMPT, boardId)
 	
 	// Build user message content - may include image if boardId is provided
 	var userContent interface{} = message
+	if len(images) > 0 {
+		userContent = helpers.FormatMessageWithImages(message, images)
+	}
 	
 	messages := []llmHandlers.Message{}
 
@@ -97,13 +146,17 @@ func (a *Agent) ProcessRequest(ctx context.Context, message string, chatHistory
 
 // ProcessRequestStream processes a user message with optional board image
 // boardId can be empty string if no image should be included
-// client can be nil if streaming is not needed
-func (a *Agent) ProcessRequestStream(ctx context.Context, hub *libraries.Hub, client *libraries.Client, message string, chatHistory []llmHandlers.Message, boardId string) (string, error) {
+// onEvent can be nil if streaming is not needed
+// images are attached to the user message, they can be nil
+func (a *Agent) ProcessRequestStream(ctx context.Context, onEvent llmHandlers.EventHandler, message string, images []helpers.ImageAttachment, chatHistory []llmHandlers.Message, boardId string) (string, error) {
 	// Build messages for the LLM
 	systemMessage := fmt.Sprintf(prompts.MASTER_PROMPT, boardId)
 	
 	// Build user message content - may include image if boardId is provided
 	var userContent interface{} = message
+	if len(images) > 0 {
+		userContent = helpers.FormatMessageWithImages(message, images)
+	}
 	
 	messages := []llmHandlers.Message{}
 
@@ -116,10 +169,12 @@ func (a *Agent) ProcessRequestStream(ctx context.Context, hub *libraries.Hub, cl
 		Content: userContent,
 	})
 
-	// Call the LLM - pass client and boardId for streaming
-	response, err := a.llmClient.ChatStream(ctx, hub, client, boardId, systemMessage, messages)
+	// Call the LLM - events are forwarded to onEvent while it generates
+	streamCtx := llmHandlers.NewStreamingContext(boardId, onEvent)
+	response, err := a.llmClient.ChatStream(ctx, streamCtx, systemMessage, messages)
 	if err != nil {
-		return "", fmt.Errorf("LLM chat error: %w", err)
+		// response holds the partial answer streamed before the error
+		return response, fmt.Errorf("LLM chat error: %w", err)
 	}
 
 	return response, nil
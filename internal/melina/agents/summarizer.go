@@ -0,0 +1,65 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	llmHandlers "melina-studio-backend/internal/llm_handlers"
+	"melina-studio-backend/internal/melina/prompts"
+	"melina-studio-backend/internal/models"
+	"strings"
+)
+
+// summaryMaxTokens caps the length of a running summary
+const summaryMaxTokens = 1024
+
+// Summarizer condenses older chat history into a running summary.
+// It uses the same provider as the agent but without any tools.
+type Summarizer struct {
+	llmClient llmHandlers.Client
+}
+
+// NewSummarizer creates a tool-less summarizer for the provider
+func NewSummarizer(provider string) (*Summarizer, error) {
+	cfg, err := buildConfig(provider)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Tools = nil
+	cfg.Params = models.GenerationParams{MaxTokens: summaryMaxTokens}
+
+	llmClient, err := llmHandlers.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize summarizer client (%s): %w", provider, err)
+	}
+
+	return &Summarizer{llmClient: llmClient}, nil
+}
+
+// Summarize folds the chats into the previous summary and returns the new summary
+func (s *Summarizer) Summarize(ctx context.Context, previousSummary string, chats []models.Chat) (string, error) {
+	var transcript strings.Builder
+	if previousSummary != "" {
+		transcript.WriteString("<PREVIOUS_SUMMARY>\n")
+		transcript.WriteString(previousSummary)
+		transcript.WriteString("\n</PREVIOUS_SUMMARY>\n\n")
+	}
+	transcript.WriteString("<CONVERSATION>\n")
+	for _, chat := range chats {
+		transcript.WriteString(fmt.Sprintf("%s: %s\n", chat.Role, chat.Content))
+	}
+	transcript.WriteString("</CONVERSATION>")
+
+	messages := []llmHandlers.Message{
+		{
+			Role:    models.RoleUser,
+			Content: transcript.String(),
+		},
+	}
+
+	summary, err := s.llmClient.Chat(ctx, prompts.SUMMARY_PROMPT, messages)
+	if err != nil {
+		return "", fmt.Errorf("LLM summary error: %w", err)
+	}
+
+	return strings.TrimSpace(summary), nil
+}
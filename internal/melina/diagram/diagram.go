@@ -0,0 +1,149 @@
+package diagram
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"melina-studio-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Kind is the type of a parsed diagram
+type Kind string
+
+const (
+	KindFlowchart Kind = "flowchart"
+	KindSequence  Kind = "sequence"
+)
+
+const (
+	maxSourceBytes = 20000
+	maxNodes       = 150
+	maxEdges       = 300
+)
+
+// default styling, matches the defaults of the addShape tool
+const (
+	defaultStroke      = "#1f2937"
+	defaultFill        = "#ffffff"
+	defaultTextFill    = "#111827"
+	labelTextFill      = "#4b5563"
+	lifelineStroke     = "#9ca3af"
+	defaultFontFamily  = "Arial"
+	defaultStrokeWidth = 2.0
+	thickStrokeWidth   = 3.0
+	nodeFontSize       = 16.0
+	labelFontSize      = 14.0
+)
+
+// Result is a diagram laid out as board shapes
+type Result struct {
+	Kind   Kind           `json:"kind"`
+	Shapes []models.Shape `json:"shapes"`
+	Width  float64        `json:"width"`
+	Height float64        `json:"height"`
+}
+
+// Render parses Mermaid (flowchart, sequenceDiagram) or PlantUML (sequence) source
+// and lays it out with its top left corner at (x, y)
+func Render(source string, x, y float64) (*Result, error) {
+	if len(source) > maxSourceBytes {
+		return nil, fmt.Errorf("diagram source is too long (max %d bytes)", maxSourceBytes)
+	}
+	lines := sourceLines(source)
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("diagram source is empty")
+	}
+
+	header := strings.ToLower(strings.Fields(lines[0])[0])
+	switch {
+	case header == "graph" || header == "flowchart":
+		graph, err := parseMermaidFlowchart(lines)
+		if err != nil {
+			return nil, err
+		}
+		return layoutFlowchart(graph, x, y), nil
+	case header == "sequencediagram":
+		seq, err := parseMermaidSequence(lines[1:])
+		if err != nil {
+			return nil, err
+		}
+		return layoutSequence(seq, x, y), nil
+	case strings.HasPrefix(header, "@startuml"):
+		seq, err := parsePlantUMLSequence(lines[1:])
+		if err != nil {
+			return nil, err
+		}
+		return layoutSequence(seq, x, y), nil
+	default:
+		return nil, fmt.Errorf("unsupported diagram type %q: use a Mermaid flowchart or sequenceDiagram, or a PlantUML sequence diagram", header)
+	}
+}
+
+// sourceLines returns the trimmed, non-empty lines of the source without
+// markdown code fences and comments
+func sourceLines(source string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(strings.ReplaceAll(source, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "```") || strings.HasPrefix(line, "%%") || strings.HasPrefix(line, "'") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// textWidth estimates the rendered width of a text, there is no font metrics on the server
+func textWidth(text string, fontSize float64) float64 {
+	return float64(len([]rune(text))) * fontSize * 0.55
+}
+
+func newShape(shapeType string) models.Shape {
+	return models.Shape{ID: uuid.New().String(), Type: shapeType}
+}
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func str(v string) *string {
+	return &v
+}
+
+// textShape returns a text shape centered on (cx, cy)
+func textShape(text string, cx, cy, fontSize float64, fill string) models.Shape {
+	shape := newShape("text")
+	shape.X = float(round(cx - textWidth(text, fontSize)/2))
+	shape.Y = float(round(cy - fontSize/2))
+	shape.Text = str(text)
+	shape.FontSize = float(fontSize)
+	shape.FontFamily = str(defaultFontFamily)
+	shape.Fill = str(fill)
+	return shape
+}
+
+// lineShape returns an arrow or line through the given absolute points;
+// the shape is placed at the first point and its points are relative to it
+func lineShape(shapeType string, points []float64, strokeWidth float64, stroke string) models.Shape {
+	shape := newShape(shapeType)
+	originX, originY := points[0], points[1]
+	relative := make(models.Points, len(points))
+	for i := 0; i < len(points); i += 2 {
+		relative[i] = round(points[i] - originX)
+		relative[i+1] = round(points[i+1] - originY)
+	}
+	shape.X = float(round(originX))
+	shape.Y = float(round(originY))
+	shape.Points = &relative
+	shape.Stroke = str(stroke)
+	shape.StrokeWidth = float(strokeWidth)
+	return shape
+}
+
+// round keeps coordinates short in the payload
+func round(v float64) float64 {
+	return math.Round(v*10) / 10
+}
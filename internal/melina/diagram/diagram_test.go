@@ -0,0 +1,190 @@
+package diagram
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMermaidFlowchart(t *testing.T) {
+	cases := []struct {
+		name      string
+		source    string
+		direction Direction
+		nodes     map[string]NodeShape // id -> shape
+		labels    map[string]string    // id -> label, only the ones worth checking
+		edges     []string             // "from>to:label", ">" when the edge has an arrow head
+	}{
+		{
+			name:      "chain with labels and shapes",
+			source:    "graph LR\nA[Start] --> B{Ok?}\nB -->|yes| C((Done))\nB -- no --- A",
+			direction: DirectionLR,
+			nodes:     map[string]NodeShape{"A": NodeRect, "B": NodeDiamond, "C": NodeEllipse},
+			labels:    map[string]string{"A": "Start", "B": "Ok?", "C": "Done"},
+			edges:     []string{"A>B:", "B>C:yes", "B-A:no"},
+		},
+		{
+			name:      "header statements and fan out",
+			source:    "flowchart TD; A --> B & C\nclassDef hot fill:#f00\nstyle A fill:#0f0",
+			direction: DirectionTB,
+			nodes:     map[string]NodeShape{"A": NodeRect, "B": NodeRect, "C": NodeRect},
+			labels:    map[string]string{"B": "B"},
+			edges:     []string{"A>B:", "A>C:"},
+		},
+		{
+			name:      "quoted label with a line break",
+			source:    "graph BT\nA[\"first<br>second\"] ==> B([stadium])",
+			direction: DirectionBT,
+			nodes:     map[string]NodeShape{"A": NodeRect, "B": NodeEllipse},
+			labels:    map[string]string{"A": "first second", "B": "stadium"},
+			edges:     []string{"A>B:"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			graph, err := parseMermaidFlowchart(sourceLines(tc.source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if graph.direction != tc.direction {
+				t.Fatalf("direction = %s, want %s", graph.direction, tc.direction)
+			}
+			if len(graph.nodes) != len(tc.nodes) {
+				t.Fatalf("parsed %d nodes, want %d", len(graph.nodes), len(tc.nodes))
+			}
+			for id, shape := range tc.nodes {
+				node, ok := graph.nodeIndex[id]
+				if !ok || node.shape != shape {
+					t.Fatalf("node %s = %+v, want shape %s", id, node, shape)
+				}
+			}
+			for id, label := range tc.labels {
+				if got := graph.nodeIndex[id].label; got != label {
+					t.Fatalf("node %s label = %q, want %q", id, got, label)
+				}
+			}
+			edges := make([]string, 0, len(graph.edges))
+			for _, e := range graph.edges {
+				op := "-"
+				if e.arrow {
+					op = ">"
+				}
+				edges = append(edges, e.from+op+e.to+":"+e.label)
+			}
+			if strings.Join(edges, ",") != strings.Join(tc.edges, ",") {
+				t.Fatalf("edges = %v, want %v", edges, tc.edges)
+			}
+		})
+	}
+}
+
+func TestParseSequence(t *testing.T) {
+	cases := []struct {
+		name         string
+		source       string
+		participants []string // labels in order
+		messages     []string // "from>to:text"
+	}{
+		{
+			name:         "mermaid",
+			source:       "sequenceDiagram\nparticipant A as Alice\nactor B as Bob\nA->>B: Hello\nloop every minute\nB-->>+A: Hi\nend",
+			participants: []string{"Alice", "Bob"},
+			messages:     []string{"A>B:Hello", "B>A:Hi"},
+		},
+		{
+			name:         "plantuml",
+			source:       "@startuml\nparticipant \"Web App\" as W\nW -> API : request\nAPI --> W : response\nnote left of W\nnot a message\nend note\nDB <- API : query\n@enduml",
+			participants: []string{"Web App", "API", "DB"},
+			messages:     []string{"W>API:request", "API>W:response", "API>DB:query"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			lines := sourceLines(tc.source)
+			var seq *sequenceDiagram
+			var err error
+			if strings.HasPrefix(lines[0], "@startuml") {
+				seq, err = parsePlantUMLSequence(lines[1:])
+			} else {
+				seq, err = parseMermaidSequence(lines[1:])
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			participants := []string{}
+			for _, p := range seq.participants {
+				participants = append(participants, p.label)
+			}
+			if strings.Join(participants, ",") != strings.Join(tc.participants, ",") {
+				t.Fatalf("participants = %v, want %v", participants, tc.participants)
+			}
+			messages := []string{}
+			for _, m := range seq.messages {
+				messages = append(messages, m.from+">"+m.to+":"+m.text)
+			}
+			if strings.Join(messages, ",") != strings.Join(tc.messages, ",") {
+				t.Fatalf("messages = %v, want %v", messages, tc.messages)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	cases := []struct {
+		name   string
+		source string
+		kind   Kind
+		shapes int
+	}{
+		// two nodes with an outline and a label each, and one arrow
+		{"flowchart", "graph TD\nA --> B", KindFlowchart, 5},
+		{"fenced flowchart", "```mermaid\nflowchart LR\nA[One] --> B[Two]\n```", KindFlowchart, 5},
+		{"mermaid sequence", "sequenceDiagram\nA->>B: hi", KindSequence, 0},
+		{"plantuml sequence", "@startuml\nA -> B : hi\n@enduml", KindSequence, 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := Render(tc.source, 100, 200)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Kind != tc.kind {
+				t.Fatalf("kind = %s, want %s", result.Kind, tc.kind)
+			}
+			if tc.shapes > 0 && len(result.Shapes) != tc.shapes {
+				t.Fatalf("rendered %d shapes, want %d", len(result.Shapes), tc.shapes)
+			}
+			if len(result.Shapes) == 0 || result.Width <= 0 || result.Height <= 0 {
+				t.Fatalf("empty result %+v", result)
+			}
+			ids := map[string]bool{}
+			for _, shape := range result.Shapes {
+				if ids[shape.ID] {
+					t.Fatalf("duplicate shape id %s", shape.ID)
+				}
+				ids[shape.ID] = true
+			}
+		})
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		source string
+	}{
+		{"empty", "  \n\n"},
+		{"unsupported type", "pie title Pets\n\"Dogs\" : 3"},
+		{"unknown direction", "graph XY\nA --> B"},
+		{"unclosed node", "graph TD\nA[Start --> B"},
+		{"missing link", "graph TD\nA B"},
+		{"unsupported sequence statement", "sequenceDiagram\nA = B"},
+		{"too long", "graph TD\n" + strings.Repeat("A --> B\n", maxSourceBytes/8+1)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if result, err := Render(tc.source, 0, 0); err == nil {
+				t.Fatalf("Render(%q) = %+v, want an error", tc.source, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,406 @@
+package diagram
+
+import (
+	"math"
+	"sort"
+
+	"melina-studio-backend/internal/models"
+)
+
+const (
+	minNodeWidth   = 140.0
+	nodeHeight     = 60.0
+	nodePadding    = 32.0
+	nodeGap        = 60.0  // between nodes of the same layer
+	layerGap       = 100.0 // between layers
+	orderingPasses = 4
+)
+
+// LayeredNode is a box placed by LayeredLayout
+type LayeredNode struct {
+	ID   string
+	W, H float64
+}
+
+// Point is a position on the board
+type Point struct {
+	X, Y float64
+}
+
+type placedNode struct {
+	id     string
+	layer  int
+	order  int
+	w, h   float64
+	cx, cy float64
+}
+
+// layoutFlowchart lays the graph out with LayeredLayout and draws its nodes and edges
+func layoutFlowchart(g *flowGraph, originX, originY float64) *Result {
+	nodes := make([]LayeredNode, 0, len(g.nodes))
+	for _, n := range g.nodes {
+		w := math.Max(minNodeWidth, textWidth(n.label, nodeFontSize)+nodePadding)
+		h := nodeHeight
+		if n.shape == NodeDiamond {
+			// the label has to fit inside the diamond
+			w, h = w*1.4, h*1.4
+		}
+		nodes = append(nodes, LayeredNode{ID: n.id, W: w, H: h})
+	}
+	edges := make([][2]string, 0, len(g.edges))
+	for _, e := range g.edges {
+		edges = append(edges, [2]string{e.from, e.to})
+	}
+
+	centers, width, height := LayeredLayout(nodes, edges, g.direction)
+
+	outlines := make(map[string]*Outline, len(nodes))
+	for i, n := range g.nodes {
+		c := centers[n.id]
+		outlines[n.id] = &Outline{Shape: n.shape, CX: originX + c.X, CY: originY + c.Y, W: nodes[i].W, H: nodes[i].H}
+	}
+
+	result := &Result{Kind: KindFlowchart, Width: width, Height: height}
+	shapeIds := make(map[string]string, len(g.nodes))
+	for _, n := range g.nodes {
+		shapes := nodeShapes(n, outlines[n.id])
+		shapeIds[n.id] = shapes[0].ID
+		result.Shapes = append(result.Shapes, shapes...)
+	}
+	for _, e := range g.edges {
+		shape := edgeShape(e, outlines[e.from], outlines[e.to])
+		// edges are connectors, so they follow their nodes when those move
+		if e.from != e.to {
+			shape.FromShapeId = str(shapeIds[e.from])
+			shape.ToShapeId = str(shapeIds[e.to])
+		}
+		result.Shapes = append(result.Shapes, shape)
+	}
+	return result
+}
+
+// LayeredLayout places boxes in layers (a simplified Sugiyama layout): cycles
+// are broken, nodes are layered by longest path, then ordered inside their
+// layer by the barycenter of their neighbours. Every step only depends on the
+// order of nodes, so the same input always gives the same layout.
+// It returns the node centers relative to the top left corner, and the size.
+func LayeredLayout(nodes []LayeredNode, edges [][2]string, direction Direction) (map[string]Point, float64, float64) {
+	placed := make(map[string]*placedNode, len(nodes))
+	ids := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		if _, ok := placed[n.ID]; ok {
+			continue
+		}
+		placed[n.ID] = &placedNode{id: n.ID, w: n.W, h: n.H}
+		ids = append(ids, n.ID)
+	}
+	known := edges[:0:0]
+	for _, e := range edges {
+		if placed[e[0]] != nil && placed[e[1]] != nil {
+			known = append(known, e)
+		}
+	}
+
+	acyclic := acyclicEdges(ids, known)
+	assignLayers(ids, placed, acyclic)
+	layers := orderLayers(ids, placed, acyclic)
+	width, height := assignCoordinates(direction, layers)
+
+	centers := make(map[string]Point, len(placed))
+	for id, p := range placed {
+		centers[id] = Point{X: p.cx, Y: p.cy}
+	}
+	return centers, width, height
+}
+
+// acyclicEdges returns the edges with the back edges of a depth first search
+// reversed, and self loops left out
+func acyclicEdges(ids []string, edges [][2]string) [][2]string {
+	outgoing := map[string][]string{}
+	for _, e := range edges {
+		if e[0] != e[1] {
+			outgoing[e[0]] = append(outgoing[e[0]], e[1])
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := map[string]int{}
+	acyclic := [][2]string{}
+	var visit func(id string)
+	visit = func(id string) {
+		state[id] = visiting
+		for _, to := range outgoing[id] {
+			switch state[to] {
+			case visiting:
+				acyclic = append(acyclic, [2]string{to, id})
+			case done:
+				acyclic = append(acyclic, [2]string{id, to})
+			default:
+				acyclic = append(acyclic, [2]string{id, to})
+				visit(to)
+			}
+		}
+		state[id] = done
+	}
+	for _, id := range ids {
+		if state[id] == unvisited {
+			visit(id)
+		}
+	}
+	return acyclic
+}
+
+// assignLayers puts every node one layer below its deepest predecessor
+func assignLayers(ids []string, nodes map[string]*placedNode, edges [][2]string) {
+	incoming := map[string]int{}
+	outgoing := map[string][]string{}
+	for _, e := range edges {
+		incoming[e[1]]++
+		outgoing[e[0]] = append(outgoing[e[0]], e[1])
+	}
+
+	// kahn's algorithm, the queue starts in input order
+	queue := []string{}
+	for _, id := range ids {
+		if incoming[id] == 0 {
+			queue = append(queue, id)
+		}
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, to := range outgoing[id] {
+			if layer := nodes[id].layer + 1; layer > nodes[to].layer {
+				nodes[to].layer = layer
+			}
+			incoming[to]--
+			if incoming[to] == 0 {
+				queue = append(queue, to)
+			}
+		}
+	}
+}
+
+// orderLayers groups the nodes by layer and reduces crossings with barycenter sweeps
+func orderLayers(ids []string, nodes map[string]*placedNode, edges [][2]string) [][]*placedNode {
+	layers := [][]*placedNode{}
+	for _, id := range ids {
+		p := nodes[id]
+		for len(layers) <= p.layer {
+			layers = append(layers, nil)
+		}
+		p.order = len(layers[p.layer])
+		layers[p.layer] = append(layers[p.layer], p)
+	}
+
+	neighbours := map[string][]*placedNode{}
+	for _, e := range edges {
+		neighbours[e[0]] = append(neighbours[e[0]], nodes[e[1]])
+		neighbours[e[1]] = append(neighbours[e[1]], nodes[e[0]])
+	}
+
+	sweep := func(layer []*placedNode, fixedLayer int) {
+		barycenter := make(map[*placedNode]float64, len(layer))
+		for _, p := range layer {
+			sum, count := 0.0, 0
+			for _, n := range neighbours[p.id] {
+				if n.layer == fixedLayer {
+					sum += float64(n.order)
+					count++
+				}
+			}
+			if count == 0 {
+				// nodes without neighbours in the fixed layer keep their place
+				barycenter[p] = float64(p.order)
+				continue
+			}
+			barycenter[p] = sum / float64(count)
+		}
+		sort.SliceStable(layer, func(i, j int) bool {
+			return barycenter[layer[i]] < barycenter[layer[j]]
+		})
+		for i, p := range layer {
+			p.order = i
+		}
+	}
+
+	for pass := 0; pass < orderingPasses; pass++ {
+		if pass%2 == 0 {
+			for i := 1; i < len(layers); i++ {
+				sweep(layers[i], i-1)
+			}
+		} else {
+			for i := len(layers) - 2; i >= 0; i-- {
+				sweep(layers[i], i+1)
+			}
+		}
+	}
+	return layers
+}
+
+// assignCoordinates sets the node centers relative to the diagram's top left
+// corner and returns the diagram size
+func assignCoordinates(direction Direction, layers [][]*placedNode) (float64, float64) {
+	horizontal := direction == DirectionLR || direction == DirectionRL
+
+	// along: position across layers, across: position inside a layer
+	alongSize := func(p *placedNode) float64 {
+		if horizontal {
+			return p.w
+		}
+		return p.h
+	}
+	acrossSize := func(p *placedNode) float64 {
+		if horizontal {
+			return p.h
+		}
+		return p.w
+	}
+
+	layerDepth := make([]float64, len(layers))
+	layerLength := make([]float64, len(layers))
+	maxLength := 0.0
+	for i, layer := range layers {
+		for j, p := range layer {
+			layerDepth[i] = math.Max(layerDepth[i], alongSize(p))
+			layerLength[i] += acrossSize(p)
+			if j > 0 {
+				layerLength[i] += nodeGap
+			}
+		}
+		maxLength = math.Max(maxLength, layerLength[i])
+	}
+
+	along := 0.0
+	for i, layer := range layers {
+		// center every layer on the longest one
+		across := (maxLength - layerLength[i]) / 2
+		for _, p := range layer {
+			a := along + layerDepth[i]/2
+			c := across + acrossSize(p)/2
+			if horizontal {
+				p.cx, p.cy = a, c
+			} else {
+				p.cx, p.cy = c, a
+			}
+			across += acrossSize(p) + nodeGap
+		}
+		along += layerDepth[i]
+		if i < len(layers)-1 {
+			along += layerGap
+		}
+	}
+
+	width, height := maxLength, along
+	if horizontal {
+		width, height = along, maxLength
+	}
+	for _, layer := range layers {
+		for _, p := range layer {
+			switch direction {
+			case DirectionBT:
+				p.cy = height - p.cy
+			case DirectionRL:
+				p.cx = width - p.cx
+			}
+		}
+	}
+	return width, height
+}
+
+// nodeShapes returns the outline and the label of a node
+func nodeShapes(n *flowNode, p *Outline) []models.Shape {
+	var outline models.Shape
+	switch n.shape {
+	case NodeEllipse:
+		// konva ellipses are positioned by their center
+		outline = newShape("ellipse")
+		outline.X = float(round(p.CX))
+		outline.Y = float(round(p.CY))
+		outline.W = float(round(p.W))
+		outline.H = float(round(p.H))
+	case NodeDiamond:
+		outline = newShape("polygon")
+		outline.X = float(round(p.CX))
+		outline.Y = float(round(p.CY))
+		points := models.Points{0, round(-p.H / 2), round(p.W / 2), 0, 0, round(p.H / 2), round(-p.W / 2), 0}
+		outline.Points = &points
+	default:
+		outline = newShape("rect")
+		outline.X = float(round(p.CX - p.W/2))
+		outline.Y = float(round(p.CY - p.H/2))
+		outline.W = float(round(p.W))
+		outline.H = float(round(p.H))
+	}
+	outline.Stroke = str(defaultStroke)
+	outline.Fill = str(defaultFill)
+	outline.StrokeWidth = float(defaultStrokeWidth)
+
+	return []models.Shape{outline, textShape(n.label, p.CX, p.CY, nodeFontSize, defaultTextFill)}
+}
+
+// edgeShape returns the arrow (or line) of an edge; the label is drawn by the client on the connector
+func edgeShape(e flowEdge, from, to *Outline) models.Shape {
+	shapeType := "line"
+	if e.arrow {
+		shapeType = "arrow"
+	}
+	strokeWidth := defaultStrokeWidth
+	if e.thick {
+		strokeWidth = thickStrokeWidth
+	}
+
+	var points []float64
+	if from == to {
+		// self loop on the right side of the node
+		right := from.CX + from.W/2
+		loop := right + 30
+		points = []float64{right, from.CY - from.H/4, loop, from.CY - from.H/4, loop, from.CY + from.H/4, right, from.CY + from.H/4}
+	} else {
+		dx, dy := to.CX-from.CX, to.CY-from.CY
+		startX, startY := from.BoundaryPoint(dx, dy)
+		endX, endY := to.BoundaryPoint(-dx, -dy)
+		points = []float64{startX, startY, endX, endY}
+	}
+
+	shape := lineShape(shapeType, points, strokeWidth, defaultStroke)
+	if e.label != "" {
+		shape.Label = str(e.label)
+	}
+	return shape
+}
+
+// Outline is a node outline on the board, positioned by its center
+type Outline struct {
+	Shape  NodeShape
+	CX, CY float64
+	W, H   float64
+}
+
+// BoundaryPoint returns where a ray from the center in direction (dx, dy) leaves the outline
+func (p *Outline) BoundaryPoint(dx, dy float64) (float64, float64) {
+	if dx == 0 && dy == 0 {
+		return p.CX, p.CY
+	}
+	hw, hh := p.W/2, p.H/2
+	var t float64
+	switch p.Shape {
+	case NodeEllipse:
+		t = 1 / math.Sqrt((dx/hw)*(dx/hw)+(dy/hh)*(dy/hh))
+	case NodeDiamond:
+		t = 1 / (math.Abs(dx)/hw + math.Abs(dy)/hh)
+	default:
+		t = math.Inf(1)
+		if dx != 0 {
+			t = hw / math.Abs(dx)
+		}
+		if dy != 0 {
+			t = math.Min(t, hh/math.Abs(dy))
+		}
+	}
+	return p.CX + dx*t, p.CY + dy*t
+}
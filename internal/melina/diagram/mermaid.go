@@ -0,0 +1,242 @@
+package diagram
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Direction is the rank direction of a flowchart
+type Direction string
+
+const (
+	DirectionTB Direction = "TB"
+	DirectionBT Direction = "BT"
+	DirectionLR Direction = "LR"
+	DirectionRL Direction = "RL"
+)
+
+// NodeShape is how a flowchart node is drawn
+type NodeShape string
+
+const (
+	NodeRect    NodeShape = "rect"
+	NodeEllipse NodeShape = "ellipse"
+	NodeDiamond NodeShape = "diamond"
+)
+
+type flowNode struct {
+	id    string
+	label string
+	shape NodeShape
+}
+
+type flowEdge struct {
+	from, to string
+	label    string
+	arrow    bool
+	thick    bool
+}
+
+type flowGraph struct {
+	direction Direction
+	nodes     []*flowNode // in declaration order, which keeps the layout deterministic
+	nodeIndex map[string]*flowNode
+	edges     []flowEdge
+}
+
+var (
+	nodeIdRe = regexp.MustCompile(`^[A-Za-z0-9_]+`)
+	// A -- text --> B, A == text ==> B, A -. text .-> B
+	textLinkRe = regexp.MustCompile(`^(--|==|-\.)\s+(.+?)\s+(-{2,}>|={2,}>|\.-+>|-{3,}|={3,}|\.-+)`)
+	// A --> B, A --- B, A ==> B, A -.-> B, A <--> B, with an optional |label|
+	linkRe = regexp.MustCompile(`^<?(-{2,}|={2,}|-\.+-)([>xo]?)\s*(?:\|([^|]*)\|)?`)
+)
+
+// node delimiters, longest first so (( )) is not read as ( )
+var nodeDelimiters = []struct {
+	open, close string
+	shape       NodeShape
+}{
+	{"(((", ")))", NodeEllipse},
+	{"((", "))", NodeEllipse},
+	{"([", "])", NodeEllipse},
+	{"[[", "]]", NodeRect},
+	{"[(", ")]", NodeRect},
+	{"{{", "}}", NodeDiamond},
+	{"[/", "/]", NodeRect},
+	{"[\\", "\\]", NodeRect},
+	{"[", "]", NodeRect},
+	{"(", ")", NodeRect},
+	{"{", "}", NodeDiamond},
+	{">", "]", NodeRect},
+}
+
+// statements that only style or group nodes, they don't change the layout
+var ignoredFlowchartKeywords = []string{"classDef", "class", "style", "linkStyle", "click", "subgraph", "end", "direction"}
+
+func parseMermaidFlowchart(lines []string) (*flowGraph, error) {
+	graph := &flowGraph{direction: DirectionTB, nodeIndex: map[string]*flowNode{}}
+
+	// the header may be followed by statements on the same line: graph TD; A-->B
+	headerLine, firstStatements, _ := strings.Cut(lines[0], ";")
+	header := strings.Fields(headerLine)
+	if len(header) > 1 {
+		switch strings.ToUpper(header[1]) {
+		case "TB", "TD":
+			graph.direction = DirectionTB
+		case "BT":
+			graph.direction = DirectionBT
+		case "LR":
+			graph.direction = DirectionLR
+		case "RL":
+			graph.direction = DirectionRL
+		default:
+			return nil, fmt.Errorf("unknown flowchart direction %q", header[1])
+		}
+	}
+
+	lines = append([]string{firstStatements}, lines[1:]...)
+	for i, line := range lines {
+		for _, statement := range strings.Split(line, ";") {
+			statement = strings.TrimSpace(statement)
+			if statement == "" || isIgnoredStatement(statement) {
+				continue
+			}
+			if err := graph.parseStatement(statement); err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+		}
+	}
+
+	if len(graph.nodes) == 0 {
+		return nil, fmt.Errorf("flowchart has no nodes")
+	}
+	if len(graph.nodes) > maxNodes || len(graph.edges) > maxEdges {
+		return nil, fmt.Errorf("flowchart is too large (max %d nodes and %d edges)", maxNodes, maxEdges)
+	}
+	return graph, nil
+}
+
+func isIgnoredStatement(statement string) bool {
+	word := strings.Fields(statement)[0]
+	for _, keyword := range ignoredFlowchartKeywords {
+		if word == keyword {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStatement parses a chain like `A[Start] --> B{Ok?} -->|yes| C & D`
+func (g *flowGraph) parseStatement(statement string) error {
+	rest := statement
+	previous, rest, err := g.parseNodeGroup(rest)
+	if err != nil {
+		return err
+	}
+
+	for rest != "" {
+		edge, next, err := parseLink(rest)
+		if err != nil {
+			return err
+		}
+		targets, after, err := g.parseNodeGroup(next)
+		if err != nil {
+			return err
+		}
+		for _, from := range previous {
+			for _, to := range targets {
+				e := edge
+				e.from, e.to = from, to
+				g.edges = append(g.edges, e)
+			}
+		}
+		previous, rest = targets, after
+	}
+	return nil
+}
+
+// parseNodeGroup parses `A` or `A[label] & B(label)` and returns the node ids
+func (g *flowGraph) parseNodeGroup(s string) ([]string, string, error) {
+	ids := []string{}
+	for {
+		id, rest, err := g.parseNode(strings.TrimSpace(s))
+		if err != nil {
+			return nil, "", err
+		}
+		ids = append(ids, id)
+		rest = strings.TrimSpace(rest)
+		if !strings.HasPrefix(rest, "&") {
+			return ids, rest, nil
+		}
+		s = rest[1:]
+	}
+}
+
+// parseNode parses one node reference and declares or updates the node
+func (g *flowGraph) parseNode(s string) (string, string, error) {
+	id := nodeIdRe.FindString(s)
+	if id == "" {
+		return "", "", fmt.Errorf("expected a node id at %q", s)
+	}
+	rest := s[len(id):]
+
+	label, shape, hasLabel := "", NodeRect, false
+	for _, d := range nodeDelimiters {
+		if !strings.HasPrefix(rest, d.open) {
+			continue
+		}
+		end := strings.Index(rest[len(d.open):], d.close)
+		if end < 0 {
+			return "", "", fmt.Errorf("node %s is missing a closing %q", id, d.close)
+		}
+		label = cleanLabel(rest[len(d.open) : len(d.open)+end])
+		shape, hasLabel = d.shape, true
+		rest = rest[len(d.open)+end+len(d.close):]
+		break
+	}
+
+	node, ok := g.nodeIndex[id]
+	if !ok {
+		node = &flowNode{id: id, label: id, shape: NodeRect}
+		g.nodeIndex[id] = node
+		g.nodes = append(g.nodes, node)
+	}
+	if hasLabel {
+		node.label, node.shape = label, shape
+	}
+	return id, rest, nil
+}
+
+// parseLink parses the link operator at the start of s
+func parseLink(s string) (flowEdge, string, error) {
+	s = strings.TrimSpace(s)
+	if m := textLinkRe.FindStringSubmatch(s); m != nil {
+		end := m[3]
+		edge := flowEdge{
+			label: cleanLabel(m[2]),
+			arrow: strings.HasSuffix(end, ">"),
+			thick: strings.HasPrefix(m[1], "="),
+		}
+		return edge, s[len(m[0]):], nil
+	}
+	if m := linkRe.FindStringSubmatch(s); m != nil {
+		edge := flowEdge{
+			label: cleanLabel(m[3]),
+			arrow: m[2] != "",
+			thick: strings.HasPrefix(m[1], "="),
+		}
+		return edge, s[len(m[0]):], nil
+	}
+	return flowEdge{}, "", fmt.Errorf("expected a link at %q", s)
+}
+
+// cleanLabel strips quotes and the markup mermaid allows in labels
+func cleanLabel(label string) string {
+	label = strings.TrimSpace(label)
+	label = strings.Trim(label, `"`)
+	label = strings.ReplaceAll(label, "<br>", " ")
+	label = strings.ReplaceAll(label, "<br/>", " ")
+	return strings.TrimSpace(label)
+}
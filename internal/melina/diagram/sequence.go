@@ -0,0 +1,261 @@
+package diagram
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"melina-studio-backend/internal/models"
+)
+
+const (
+	participantHeight = 50.0
+	participantGap    = 80.0
+	messageGap        = 60.0
+	selfMessageWidth  = 40.0
+)
+
+type participant struct {
+	id    string
+	label string
+	actor bool
+}
+
+type sequenceMessage struct {
+	from, to string
+	text     string
+	arrow    bool
+}
+
+type sequenceDiagram struct {
+	participants []*participant // in declaration order, then order of first use
+	index        map[string]*participant
+	messages     []sequenceMessage
+}
+
+func newSequenceDiagram() *sequenceDiagram {
+	return &sequenceDiagram{index: map[string]*participant{}}
+}
+
+// participant declares a participant, or updates its label when it exists
+func (s *sequenceDiagram) participant(id, label string, actor bool) *participant {
+	p, ok := s.index[id]
+	if !ok {
+		p = &participant{id: id, label: id}
+		s.index[id] = p
+		s.participants = append(s.participants, p)
+	}
+	if label != "" {
+		p.label = label
+	}
+	p.actor = p.actor || actor
+	return p
+}
+
+func (s *sequenceDiagram) addMessage(from, to, text string, arrow bool) {
+	s.participant(from, "", false)
+	s.participant(to, "", false)
+	s.messages = append(s.messages, sequenceMessage{from: from, to: to, text: text, arrow: arrow})
+}
+
+func (s *sequenceDiagram) validate() error {
+	if len(s.participants) == 0 {
+		return fmt.Errorf("sequence diagram has no participants")
+	}
+	if len(s.participants) > maxNodes || len(s.messages) > maxEdges {
+		return fmt.Errorf("sequence diagram is too large (max %d participants and %d messages)", maxNodes, maxEdges)
+	}
+	return nil
+}
+
+var (
+	// participant Alice, participant A as Alice, actor Bob
+	mermaidParticipantRe = regexp.MustCompile(`^(participant|actor)\s+(\S+)(?:\s+as\s+(.+))?$`)
+	// A->>B: text, A-->>+B: text, A-xB: text, A-)B: text
+	mermaidMessageRe = regexp.MustCompile(`^([^\s:\-+>]+)\s*(-{1,2}>>|-{1,2}>|-{1,2}x|-{1,2}\))\s*[+-]?\s*([^\s:\-+>]+)\s*(?::\s*(.*))?$`)
+)
+
+// blocks and annotations that don't add participants or messages
+var ignoredSequenceKeywords = []string{
+	"autonumber", "note", "loop", "alt", "else", "opt", "par", "and", "critical", "break",
+	"rect", "end", "activate", "deactivate", "box", "title",
+}
+
+func isIgnoredSequenceLine(line string) bool {
+	word := strings.ToLower(strings.Fields(line)[0])
+	for _, keyword := range ignoredSequenceKeywords {
+		if word == keyword {
+			return true
+		}
+	}
+	return false
+}
+
+func parseMermaidSequence(lines []string) (*sequenceDiagram, error) {
+	seq := newSequenceDiagram()
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, ";")
+		if m := mermaidParticipantRe.FindStringSubmatch(line); m != nil {
+			seq.participant(m[2], cleanLabel(m[3]), m[1] == "actor")
+			continue
+		}
+		if m := mermaidMessageRe.FindStringSubmatch(line); m != nil {
+			// -> and --> are drawn without an arrow head
+			seq.addMessage(m[1], m[3], cleanLabel(m[4]), strings.TrimLeft(m[2], "-") != ">")
+			continue
+		}
+		if isIgnoredSequenceLine(line) {
+			continue
+		}
+		return nil, fmt.Errorf("line %d: unsupported sequence statement %q", i+2, line)
+	}
+	return seq, seq.validate()
+}
+
+var (
+	// participant "Long name" as A, actor Bob, database DB
+	plantUMLParticipantRe = regexp.MustCompile(`^(participant|actor|boundary|control|entity|database|collections|queue)\s+(?:"([^"]+)"|(\S+))(?:\s+as\s+(\S+))?`)
+	// A -> B : text, A --> B, A ->> B, A <- B, A -[#red]> B
+	plantUMLMessageRe = regexp.MustCompile(`^("[^"]+"|[^\s\-<>:]+)\s*(<{1,2}-{1,2}|-{1,2}(?:\[[^\]]*\])?>{1,2}|-{1,2}(?:\[[^\]]*\])?[\\/]{1,2})\s*("[^"]+"|[^\s\-<>:]+)\s*(?::\s*(.*))?$`)
+)
+
+// plantuml statements that don't add participants or messages
+var ignoredPlantUMLPrefixes = []string{"skinparam", "hide", "show", "==", "...", "|||", "||", "group", "ref", "destroy", "create", "return", "header", "footer", "legend", "endlegend", "newpage", "hnote", "rnote"}
+
+func parsePlantUMLSequence(lines []string) (*sequenceDiagram, error) {
+	seq := newSequenceDiagram()
+	inNote := false
+	for i, line := range lines {
+		if strings.HasPrefix(line, "@enduml") {
+			break
+		}
+		// multi line notes run until "end note"
+		lower := strings.ToLower(line)
+		if inNote {
+			inNote = lower != "end note" && lower != "endnote"
+			continue
+		}
+		if (strings.HasPrefix(lower, "note") || strings.HasPrefix(lower, "hnote") || strings.HasPrefix(lower, "rnote")) && !strings.Contains(line, ":") {
+			inNote = true
+			continue
+		}
+		if m := plantUMLParticipantRe.FindStringSubmatch(line); m != nil {
+			label := m[2] + m[3]
+			id := m[4]
+			if id == "" {
+				id = label
+			}
+			seq.participant(id, label, m[1] == "actor")
+			continue
+		}
+		if m := plantUMLMessageRe.FindStringSubmatch(line); m != nil {
+			from, to := strings.Trim(m[1], `"`), strings.Trim(m[3], `"`)
+			if strings.HasPrefix(m[2], "<") {
+				from, to = to, from
+			}
+			seq.addMessage(from, to, cleanLabel(m[4]), true)
+			continue
+		}
+		if isIgnoredSequenceLine(line) || hasAnyPrefix(line, ignoredPlantUMLPrefixes) {
+			continue
+		}
+		return nil, fmt.Errorf("line %d: unsupported sequence statement %q", i+2, line)
+	}
+	return seq, seq.validate()
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(strings.ToLower(s), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// layoutSequence draws participants in a row with their lifelines, and every
+// message as a horizontal arrow one step below the previous one
+func layoutSequence(seq *sequenceDiagram, originX, originY float64) *Result {
+	centers := make(map[string]float64, len(seq.participants))
+	widths := make(map[string]float64, len(seq.participants))
+	x := originX
+	for _, p := range seq.participants {
+		w := math.Max(minNodeWidth, textWidth(p.label, nodeFontSize)+nodePadding)
+		widths[p.id] = w
+		centers[p.id] = x + w/2
+		x += w + participantGap
+	}
+	width := x - participantGap - originX
+
+	// widen the gaps for long message labels
+	for _, m := range seq.messages {
+		if m.from == m.to || m.text == "" {
+			continue
+		}
+		distance := math.Abs(centers[m.to] - centers[m.from])
+		if need := textWidth(m.text, labelFontSize) + 40; need > distance {
+			shift := need - distance
+			right := math.Max(centers[m.from], centers[m.to])
+			for _, p := range seq.participants {
+				if centers[p.id] >= right {
+					centers[p.id] += shift
+				}
+			}
+			width += shift
+		}
+	}
+
+	top := originY + participantHeight
+	bottom := top + messageGap*float64(len(seq.messages)+1)
+
+	result := &Result{Kind: KindSequence, Width: width, Height: bottom - originY}
+	for _, p := range seq.participants {
+		cx, w := centers[p.id], widths[p.id]
+		var box models.Shape
+		if p.actor {
+			box = newShape("ellipse")
+			box.X = float(round(cx))
+			box.Y = float(round(originY + participantHeight/2))
+		} else {
+			box = newShape("rect")
+			box.X = float(round(cx - w/2))
+			box.Y = float(round(originY))
+		}
+		box.W = float(round(w))
+		box.H = float(participantHeight)
+		box.Stroke = str(defaultStroke)
+		box.Fill = str(defaultFill)
+		box.StrokeWidth = float(defaultStrokeWidth)
+
+		result.Shapes = append(result.Shapes,
+			box,
+			textShape(p.label, cx, originY+participantHeight/2, nodeFontSize, defaultTextFill),
+			lineShape("line", []float64{cx, top, cx, bottom}, 1, lifelineStroke),
+		)
+	}
+
+	for i, m := range seq.messages {
+		y := top + messageGap*float64(i+1)
+		shapeType := "line"
+		if m.arrow {
+			shapeType = "arrow"
+		}
+
+		fromX, toX := centers[m.from], centers[m.to]
+		var points []float64
+		labelX := (fromX + toX) / 2
+		if m.from == m.to {
+			points = []float64{fromX, y - messageGap/3, fromX + selfMessageWidth, y - messageGap/3, fromX + selfMessageWidth, y, fromX, y}
+			labelX = fromX + selfMessageWidth + textWidth(m.text, labelFontSize)/2 + 6
+		} else {
+			points = []float64{fromX, y, toX, y}
+		}
+
+		result.Shapes = append(result.Shapes, lineShape(shapeType, points, defaultStrokeWidth, defaultStroke))
+		if m.text != "" {
+			result.Shapes = append(result.Shapes, textShape(m.text, labelX, y-labelFontSize, labelFontSize, labelTextFill))
+		}
+	}
+	return result
+}
@@ -31,3 +31,32 @@ func FormatMessageWithImage(text string, imageData []byte) interface{} {
 		},
 	}
 }
+
+
+// ImageAttachment is an image sent along with a chat message
+type ImageAttachment struct {
+	MediaType string // e.g. image/png
+	Data      []byte
+}
+
+// FormatMessageWithImages formats a message with several images as text + image blocks.
+// Every provider converts image blocks to its native multimodal format.
+func FormatMessageWithImages(text string, images []ImageAttachment) interface{} {
+	blocks := []map[string]interface{}{
+		{
+			"type": "text",
+			"text": text,
+		},
+	}
+	for _, image := range images {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "image",
+			"source": map[string]interface{}{
+				"type":       "base64",
+				"media_type": image.MediaType,
+				"data":       base64.StdEncoding.EncodeToString(image.Data),
+			},
+		})
+	}
+	return blocks
+}
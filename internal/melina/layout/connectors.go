@@ -0,0 +1,205 @@
+package layout
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"melina-studio-backend/internal/melina/diagram"
+	"melina-studio-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Anchor is the side of a shape a connector is attached to
+type Anchor string
+
+const (
+	AnchorAuto   Anchor = "auto"
+	AnchorTop    Anchor = "top"
+	AnchorRight  Anchor = "right"
+	AnchorBottom Anchor = "bottom"
+	AnchorLeft   Anchor = "left"
+)
+
+// ValidAnchor reports whether a is a known anchor; empty means auto
+func ValidAnchor(a string) bool {
+	switch Anchor(a) {
+	case "", AnchorAuto, AnchorTop, AnchorRight, AnchorBottom, AnchorLeft:
+		return true
+	}
+	return false
+}
+
+// ConnectorEnd is a shape a connector end is attached to
+type ConnectorEnd struct {
+	Type   models.Type
+	Bounds Bounds
+	Anchor Anchor
+}
+
+// connectorRefs returns the shapes a line or arrow references
+func connectorRefs(data map[string]interface{}) (string, string) {
+	from, _ := data["fromShapeId"].(string)
+	to, _ := data["toShapeId"].(string)
+	return from, to
+}
+
+func hasRefs(data map[string]interface{}) bool {
+	from, to := connectorRefs(data)
+	return from != "" || to != ""
+}
+
+// anchorPoint returns where a connector leaves the shape, heading to (towardX, towardY)
+func anchorPoint(end *ConnectorEnd, towardX, towardY float64) (float64, float64) {
+	b := end.Bounds
+	switch end.Anchor {
+	case AnchorTop:
+		return b.CenterX(), b.Y
+	case AnchorRight:
+		return b.Right(), b.CenterY()
+	case AnchorBottom:
+		return b.CenterX(), b.Bottom()
+	case AnchorLeft:
+		return b.X, b.CenterY()
+	}
+
+	// auto: where the line to the other end crosses the outline
+	shape := diagram.NodeRect
+	if end.Type == models.Ellipse || end.Type == models.Circle {
+		shape = diagram.NodeEllipse
+	}
+	outline := &diagram.Outline{Shape: shape, CX: b.CenterX(), CY: b.CenterY(), W: b.W, H: b.H}
+	return outline.BoundaryPoint(towardX-b.CenterX(), towardY-b.CenterY())
+}
+
+// RouteConnector draws a connector straight between its ends. An end that is
+// nil keeps its current position.
+func RouteConnector(data map[string]interface{}, from, to *ConnectorEnd) {
+	x, _ := number(data, "x")
+	y, _ := number(data, "y")
+	points := Points(data)
+	x1, y1, x2, y2 := x, y, x, y
+	if n := len(points); n >= 4 {
+		x1, y1 = x+points[0], y+points[1]
+		x2, y2 = x+points[n-2], y+points[n-1]
+	}
+
+	// aim every attached end at the center of the other shape, or at the free end
+	targetX, targetY := x2, y2
+	if to != nil {
+		targetX, targetY = to.Bounds.CenterX(), to.Bounds.CenterY()
+	}
+	sourceX, sourceY := x1, y1
+	if from != nil {
+		sourceX, sourceY = from.Bounds.CenterX(), from.Bounds.CenterY()
+	}
+	if from != nil {
+		x1, y1 = anchorPoint(from, targetX, targetY)
+	}
+	if to != nil {
+		x2, y2 = anchorPoint(to, sourceX, sourceY)
+	}
+
+	data["x"] = roundCoord(x1)
+	data["y"] = roundCoord(y1)
+	data["points"] = []float64{0, 0, roundCoord(x2 - x1), roundCoord(y2 - y1)}
+}
+
+// Reconnect recomputes the connectors attached to the changed shapes, and the
+// changed connectors themselves. It returns the connector rows with new data.
+func Reconnect(shapes []models.BoardData, changedIds []string) ([]models.BoardData, error) {
+	changed := map[string]bool{}
+	for _, id := range changedIds {
+		changed[id] = true
+	}
+
+	ends := map[string]*ConnectorEnd{}
+	for _, row := range shapes {
+		if row.Type == models.Line || row.Type == models.Arrow {
+			continue
+		}
+		data := map[string]interface{}{}
+		if err := json.Unmarshal(row.Data, &data); err != nil {
+			continue
+		}
+		if bounds, ok := ShapeBounds(row.Type, data); ok {
+			ends[row.UUID.String()] = &ConnectorEnd{Type: row.Type, Bounds: bounds}
+		}
+	}
+
+	updated := []models.BoardData{}
+	for _, row := range shapes {
+		if row.Type != models.Line && row.Type != models.Arrow {
+			continue
+		}
+		data := map[string]interface{}{}
+		if err := json.Unmarshal(row.Data, &data); err != nil {
+			continue
+		}
+		fromId, toId := connectorRefs(data)
+		if !changed[row.UUID.String()] && !changed[fromId] && !changed[toId] {
+			continue
+		}
+		if fromId != "" && fromId == toId {
+			// loops on one shape are drawn by the client
+			continue
+		}
+		// ends whose shape is gone stay where they are
+		from, to := connectorEnd(ends, fromId, data["fromAnchor"]), connectorEnd(ends, toId, data["toAnchor"])
+		if from == nil && to == nil {
+			continue
+		}
+
+		before := string(row.Data)
+		RouteConnector(data, from, to)
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		if string(raw) == before {
+			continue
+		}
+		row.Data = raw
+		updated = append(updated, row)
+	}
+	return updated, nil
+}
+
+func connectorEnd(ends map[string]*ConnectorEnd, shapeId string, anchor interface{}) *ConnectorEnd {
+	end, ok := ends[shapeId]
+	if !ok {
+		return nil
+	}
+	side, _ := anchor.(string)
+	return &ConnectorEnd{Type: end.Type, Bounds: end.Bounds, Anchor: Anchor(side)}
+}
+
+// ShapeStore loads and saves the shapes of a board, repo.BoardDataRepoInterface
+// implements it (the repo uses this package to compute bounds)
+type ShapeStore interface {
+	GetBoardData(boardId uuid.UUID) ([]models.BoardData, error)
+	UpdateShapesData(boardId uuid.UUID, shapes []models.BoardData) error
+}
+
+// ReconnectBoard reroutes the connectors of a board attached to the changed
+// shapes, saves them and returns them
+func ReconnectBoard(boardDataRepo ShapeStore, boardId uuid.UUID, changedIds []string) ([]models.BoardData, error) {
+	if len(changedIds) == 0 {
+		return nil, nil
+	}
+	shapes, err := boardDataRepo.GetBoardData(boardId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get board shapes: %w", err)
+	}
+	updated, err := Reconnect(shapes, changedIds)
+	if err != nil {
+		return nil, err
+	}
+	if len(updated) == 0 {
+		return nil, nil
+	}
+	if err := boardDataRepo.UpdateShapesData(boardId, updated); err != nil {
+		return nil, fmt.Errorf("failed to save connectors: %w", err)
+	}
+	return updated, nil
+}
@@ -0,0 +1,178 @@
+package layout
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+
+	"melina-studio-backend/internal/models"
+)
+
+// Bounds is an axis aligned bounding box
+type Bounds struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	W float64 `json:"w"`
+	H float64 `json:"h"`
+}
+
+func (b Bounds) Right() float64   { return b.X + b.W }
+func (b Bounds) Bottom() float64  { return b.Y + b.H }
+func (b Bounds) CenterX() float64 { return b.X + b.W/2 }
+func (b Bounds) CenterY() float64 { return b.Y + b.H/2 }
+func (b Bounds) Area() float64    { return b.W * b.H }
+
+// Contains reports whether the point is inside b grown by margin on every side
+func (b Bounds) Contains(x, y, margin float64) bool {
+	return x >= b.X-margin && x <= b.Right()+margin && y >= b.Y-margin && y <= b.Bottom()+margin
+}
+
+// ContainsBounds reports whether o lies completely inside b
+func (b Bounds) ContainsBounds(o Bounds) bool {
+	return o.X >= b.X && o.Y >= b.Y && o.Right() <= b.Right() && o.Bottom() <= b.Bottom()
+}
+
+// Union returns the smallest bounds around both
+func (b Bounds) Union(o Bounds) Bounds {
+	x, y := math.Min(b.X, o.X), math.Min(b.Y, o.Y)
+	return Bounds{X: x, Y: y, W: math.Max(b.Right(), o.Right()) - x, H: math.Max(b.Bottom(), o.Bottom()) - y}
+}
+
+// default text metrics, the server has no font measurements
+const (
+	defaultFontSize = 16.0
+	charWidthRatio  = 0.55
+	lineHeightRatio = 1.2
+)
+
+// ShapeBounds computes the bounding box of a shape from its stored data.
+// ok is false when the data has no usable geometry.
+func ShapeBounds(shapeType models.Type, data map[string]interface{}) (Bounds, bool) {
+	x, hasX := number(data, "x")
+	y, _ := number(data, "y")
+
+	switch shapeType {
+	case models.Rect, models.Image:
+		w, _ := number(data, "w")
+		h, _ := number(data, "h")
+		return normalized(x, y, w, h), hasX
+	case models.Ellipse:
+		// ellipses are positioned by their center
+		w, _ := number(data, "w")
+		h, _ := number(data, "h")
+		return normalized(x-w/2, y-h/2, w, h), hasX
+	case models.Circle:
+		r, _ := number(data, "r")
+		return Bounds{X: x - r, Y: y - r, W: 2 * r, H: 2 * r}, hasX
+	case models.Text:
+		text, _ := data["text"].(string)
+		fontSize, ok := number(data, "fontSize")
+		if !ok || fontSize <= 0 {
+			fontSize = defaultFontSize
+		}
+		lines := strings.Split(text, "\n")
+		longest := 0
+		for _, line := range lines {
+			longest = max(longest, len([]rune(line)))
+		}
+		return Bounds{X: x, Y: y, W: float64(longest) * fontSize * charWidthRatio, H: float64(len(lines)) * fontSize * lineHeightRatio}, hasX
+	case models.Line, models.Arrow, models.Polygon, models.Pencil:
+		points := Points(data)
+		if len(points) < 2 {
+			return Bounds{X: x, Y: y}, hasX
+		}
+		minX, minY := math.Inf(1), math.Inf(1)
+		maxX, maxY := math.Inf(-1), math.Inf(-1)
+		for i := 0; i+1 < len(points); i += 2 {
+			minX, maxX = math.Min(minX, points[i]), math.Max(maxX, points[i])
+			minY, maxY = math.Min(minY, points[i+1]), math.Max(maxY, points[i+1])
+		}
+		// points are relative to x, y
+		return Bounds{X: x + minX, Y: y + minY, W: maxX - minX, H: maxY - minY}, true
+	default:
+		return Bounds{}, false
+	}
+}
+
+// SetRowBounds stores the bounding box of a shape row in its bbox columns
+func SetRowBounds(row *models.BoardData) {
+	row.MinX, row.MinY, row.MaxX, row.MaxY = nil, nil, nil, nil
+	data := map[string]interface{}{}
+	if err := json.Unmarshal(row.Data, &data); err != nil {
+		return
+	}
+	b, ok := ShapeBounds(row.Type, data)
+	if !ok {
+		return
+	}
+	minX, minY, maxX, maxY := b.X, b.Y, b.Right(), b.Bottom()
+	row.MinX, row.MinY, row.MaxX, row.MaxY = &minX, &minY, &maxX, &maxY
+}
+
+// normalized keeps width and height positive for shapes drawn right to left
+func normalized(x, y, w, h float64) Bounds {
+	if w < 0 {
+		x, w = x+w, -w
+	}
+	if h < 0 {
+		y, h = y+h, -h
+	}
+	return Bounds{X: x, Y: y, W: w, H: h}
+}
+
+// Translate moves a shape by (dx, dy)
+func Translate(data map[string]interface{}, dx, dy float64) {
+	if dx == 0 && dy == 0 {
+		return
+	}
+	// pencil strokes are often stored without x, y: move their points instead
+	if _, hasX := data["x"]; !hasX && len(Points(data)) > 0 {
+		points := Points(data)
+		for i := 0; i+1 < len(points); i += 2 {
+			points[i] = roundCoord(points[i] + dx)
+			points[i+1] = roundCoord(points[i+1] + dy)
+		}
+		data["points"] = points
+		return
+	}
+	x, _ := number(data, "x")
+	y, _ := number(data, "y")
+	data["x"] = roundCoord(x + dx)
+	data["y"] = roundCoord(y + dy)
+}
+
+// Points returns the points of a shape as floats
+func Points(data map[string]interface{}) []float64 {
+	switch raw := data["points"].(type) {
+	case []float64:
+		return append([]float64(nil), raw...)
+	case []interface{}:
+		points := make([]float64, 0, len(raw))
+		for _, p := range raw {
+			if v, ok := p.(float64); ok {
+				points = append(points, v)
+			}
+		}
+		return points
+	}
+	return nil
+}
+
+// ShapeMap returns a stored shape in the format the client uses: the data with its id and type
+func ShapeMap(row models.BoardData) map[string]interface{} {
+	shape := map[string]interface{}{}
+	_ = json.Unmarshal(row.Data, &shape)
+	shape["id"] = row.UUID.String()
+	shape["type"] = string(row.Type)
+	return shape
+}
+
+func number(data map[string]interface{}, key string) (float64, bool) {
+	v, ok := data[key].(float64)
+	return v, ok
+}
+
+// roundCoord keeps coordinates short in the stored data
+func roundCoord(v float64) float64 {
+	return math.Round(v*10) / 10
+}
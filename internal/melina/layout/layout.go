@@ -0,0 +1,527 @@
+package layout
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"melina-studio-backend/internal/melina/diagram"
+	"melina-studio-backend/internal/models"
+)
+
+// Operation is a layout primitive
+type Operation string
+
+const (
+	OperationAlign      Operation = "align"
+	OperationDistribute Operation = "distribute"
+	OperationGrid       Operation = "grid"
+	OperationTree       Operation = "tree"
+	OperationFlow       Operation = "flow"
+	OperationSnap       Operation = "snap"
+)
+
+const (
+	defaultGridGap  = 40.0
+	defaultGridSize = 20.0
+	// how far outside a shape a connector end may be and still count as attached
+	attachMargin = 12.0
+)
+
+// Request describes one layout operation. The shapes are picked by ShapeIds,
+// or by Region (shapes fully inside it); with neither every shape on the board is used.
+type Request struct {
+	Operation Operation `json:"operation"`
+	ShapeIds  []string  `json:"shapeIds,omitempty"`
+	Region    *Bounds   `json:"region,omitempty"`
+
+	// align: left, center, right, top, middle, bottom
+	Align string `json:"align,omitempty"`
+	// distribute: horizontal or vertical
+	Axis string `json:"axis,omitempty"`
+	// grid: number of columns, defaults to a square grid
+	Columns int `json:"columns,omitempty"`
+	// distribute and grid: space between shapes; distribute spreads them evenly when unset
+	Gap *float64 `json:"gap,omitempty"`
+	// snap: grid cell size
+	GridSize float64 `json:"gridSize,omitempty"`
+	// tree and flow: TB, BT, LR or RL (tree defaults to TB, flow to LR)
+	Direction string `json:"direction,omitempty"`
+}
+
+// ErrNothingToArrange is returned when the selection has no shapes to move
+var ErrNothingToArrange = errors.New("no shapes to arrange")
+
+type item struct {
+	row      *models.BoardData
+	data     map[string]interface{}
+	bounds   Bounds
+	dx, dy   float64
+	attached []*item // labels that move with this shape
+}
+
+func (it *item) connector() bool {
+	return it.row.Type == models.Line || it.row.Type == models.Arrow
+}
+
+// Apply runs the operation on the shapes of a board and returns the rows that
+// changed, with their new data
+func Apply(shapes []models.BoardData, req Request) ([]models.BoardData, error) {
+	all := make([]*item, 0, len(shapes))
+	for i := range shapes {
+		data := map[string]interface{}{}
+		if err := json.Unmarshal(shapes[i].Data, &data); err != nil {
+			continue
+		}
+		bounds, ok := ShapeBounds(shapes[i].Type, data)
+		if !ok {
+			continue
+		}
+		all = append(all, &item{row: &shapes[i], data: data, bounds: bounds})
+	}
+
+	items, connectors := selectItems(all, req)
+	if len(items) == 0 {
+		return nil, ErrNothingToArrange
+	}
+
+	var err error
+	switch req.Operation {
+	case OperationAlign:
+		err = align(items, req.Align)
+	case OperationDistribute:
+		err = distribute(items, req.Axis, req.Gap)
+	case OperationGrid:
+		grid(items, req.Columns, req.Gap)
+	case OperationSnap:
+		snap(items, req.GridSize)
+	case OperationTree, OperationFlow:
+		err = layered(items, connectors, req)
+	default:
+		err = fmt.Errorf("unknown layout operation %q", req.Operation)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := applyMoves(items, connectors, req.Operation == OperationTree || req.Operation == OperationFlow)
+	if err != nil {
+		return nil, err
+	}
+	return withReconnected(shapes, updated)
+}
+
+// withReconnected adds the connectors that reference the updated shapes, rerouted
+// to the new positions
+func withReconnected(shapes []models.BoardData, updated []models.BoardData) ([]models.BoardData, error) {
+	byId := map[string]int{}
+	changedIds := make([]string, 0, len(updated))
+	for i, row := range updated {
+		byId[row.UUID.String()] = i
+		changedIds = append(changedIds, row.UUID.String())
+	}
+	current := make([]models.BoardData, len(shapes))
+	for i, row := range shapes {
+		if j, ok := byId[row.UUID.String()]; ok {
+			row = updated[j]
+		}
+		current[i] = row
+	}
+
+	connectors, err := Reconnect(current, changedIds)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range connectors {
+		if j, ok := byId[row.UUID.String()]; ok {
+			updated[j] = row
+			continue
+		}
+		updated = append(updated, row)
+	}
+	return updated, nil
+}
+
+// selectItems returns the shapes to arrange and every connector of the board.
+// Lines and arrows are never arranged themselves, they follow the shapes they connect;
+// texts inside an arranged shape move with it.
+func selectItems(all []*item, req Request) ([]*item, []*item) {
+	selectedIds := map[string]bool{}
+	for _, id := range req.ShapeIds {
+		selectedIds[id] = true
+	}
+
+	selected := []*item{}
+	connectors := []*item{}
+	for _, it := range all {
+		if it.connector() {
+			connectors = append(connectors, it)
+			continue
+		}
+		switch {
+		case len(req.ShapeIds) > 0:
+			if selectedIds[it.row.UUID.String()] {
+				selected = append(selected, it)
+			}
+		case req.Region != nil:
+			if req.Region.ContainsBounds(it.bounds) {
+				selected = append(selected, it)
+			}
+		default:
+			selected = append(selected, it)
+		}
+	}
+
+	// attach texts to the smallest selected shape around their center
+	items := []*item{}
+	for _, it := range selected {
+		if it.row.Type == models.Text {
+			var container *item
+			for _, other := range selected {
+				if other == it || other.row.Type == models.Text || other.row.Type == models.Pencil {
+					continue
+				}
+				if other.bounds.Contains(it.bounds.CenterX(), it.bounds.CenterY(), 0) &&
+					(container == nil || other.bounds.Area() < container.bounds.Area()) {
+					container = other
+				}
+			}
+			if container != nil {
+				container.attached = append(container.attached, it)
+				continue
+			}
+		}
+		items = append(items, it)
+	}
+	return items, connectors
+}
+
+func selectionBounds(items []*item) Bounds {
+	bounds := items[0].bounds
+	for _, it := range items[1:] {
+		bounds = bounds.Union(it.bounds)
+	}
+	return bounds
+}
+
+// readingOrder sorts items top to bottom, then left to right
+func readingOrder(items []*item) []*item {
+	sorted := append([]*item(nil), items...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].bounds, sorted[j].bounds
+		if a.Y != b.Y {
+			return a.Y < b.Y
+		}
+		return a.X < b.X
+	})
+	return sorted
+}
+
+func align(items []*item, mode string) error {
+	s := selectionBounds(items)
+	for _, it := range items {
+		b := it.bounds
+		switch mode {
+		case "left":
+			it.dx = s.X - b.X
+		case "center":
+			it.dx = s.CenterX() - b.CenterX()
+		case "right":
+			it.dx = s.Right() - b.Right()
+		case "top":
+			it.dy = s.Y - b.Y
+		case "middle":
+			it.dy = s.CenterY() - b.CenterY()
+		case "bottom":
+			it.dy = s.Bottom() - b.Bottom()
+		default:
+			return fmt.Errorf("align must be one of left, center, right, top, middle, bottom")
+		}
+	}
+	return nil
+}
+
+func distribute(items []*item, axis string, gap *float64) error {
+	if axis != "horizontal" && axis != "vertical" {
+		return fmt.Errorf("axis must be horizontal or vertical")
+	}
+	horizontal := axis == "horizontal"
+	start := func(b Bounds) float64 {
+		if horizontal {
+			return b.X
+		}
+		return b.Y
+	}
+	size := func(b Bounds) float64 {
+		if horizontal {
+			return b.W
+		}
+		return b.H
+	}
+
+	sorted := append([]*item(nil), items...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return start(sorted[i].bounds) < start(sorted[j].bounds)
+	})
+
+	spacing := 0.0
+	if gap != nil {
+		spacing = *gap
+	} else {
+		if len(sorted) < 3 {
+			return fmt.Errorf("distribute needs at least 3 shapes, or a gap")
+		}
+		// keep the outer shapes in place and share the free space between the rest
+		first, last := sorted[0].bounds, sorted[len(sorted)-1].bounds
+		total := start(last) + size(last) - start(first)
+		for _, it := range sorted {
+			total -= size(it.bounds)
+		}
+		spacing = total / float64(len(sorted)-1)
+	}
+
+	position := start(sorted[0].bounds)
+	for _, it := range sorted {
+		delta := position - start(it.bounds)
+		if horizontal {
+			it.dx = delta
+		} else {
+			it.dy = delta
+		}
+		position += size(it.bounds) + spacing
+	}
+	return nil
+}
+
+func grid(items []*item, columns int, gap *float64) {
+	if columns <= 0 {
+		columns = int(math.Ceil(math.Sqrt(float64(len(items)))))
+	}
+	spacing := defaultGridGap
+	if gap != nil {
+		spacing = *gap
+	}
+
+	cellW, cellH := 0.0, 0.0
+	for _, it := range items {
+		cellW = math.Max(cellW, it.bounds.W)
+		cellH = math.Max(cellH, it.bounds.H)
+	}
+
+	origin := selectionBounds(items)
+	for i, it := range readingOrder(items) {
+		col, row := i%columns, i/columns
+		// center every shape in its cell
+		cx := origin.X + float64(col)*(cellW+spacing) + cellW/2
+		cy := origin.Y + float64(row)*(cellH+spacing) + cellH/2
+		it.dx = cx - it.bounds.CenterX()
+		it.dy = cy - it.bounds.CenterY()
+	}
+}
+
+func snap(items []*item, gridSize float64) {
+	if gridSize <= 0 {
+		gridSize = defaultGridSize
+	}
+	for _, it := range items {
+		it.dx = math.Round(it.bounds.X/gridSize)*gridSize - it.bounds.X
+		it.dy = math.Round(it.bounds.Y/gridSize)*gridSize - it.bounds.Y
+	}
+}
+
+// layered arranges the items as a tree or flow along the connectors between them
+func layered(items []*item, connectors []*item, req Request) error {
+	direction := diagram.DirectionTB
+	if req.Operation == OperationFlow {
+		direction = diagram.DirectionLR
+	}
+	switch req.Direction {
+	case "":
+	case "TB", "TD":
+		direction = diagram.DirectionTB
+	case "BT":
+		direction = diagram.DirectionBT
+	case "LR":
+		direction = diagram.DirectionLR
+	case "RL":
+		direction = diagram.DirectionRL
+	default:
+		return fmt.Errorf("direction must be one of TB, BT, LR, RL")
+	}
+
+	ordered := readingOrder(items)
+	nodes := make([]diagram.LayeredNode, 0, len(ordered))
+	for _, it := range ordered {
+		nodes = append(nodes, diagram.LayeredNode{ID: it.row.UUID.String(), W: it.bounds.W, H: it.bounds.H})
+	}
+	edges := [][2]string{}
+	for _, c := range connectors {
+		from, to := connectorEnds(c, items)
+		if from != nil && to != nil && from != to {
+			edges = append(edges, [2]string{from.row.UUID.String(), to.row.UUID.String()})
+		}
+	}
+
+	centers, _, _ := diagram.LayeredLayout(nodes, edges, direction)
+	origin := selectionBounds(items)
+	for _, it := range items {
+		c := centers[it.row.UUID.String()]
+		it.dx = origin.X + c.X - it.bounds.CenterX()
+		it.dy = origin.Y + c.Y - it.bounds.CenterY()
+	}
+	return nil
+}
+
+// endpoints returns the absolute first and last point of a connector
+func endpoints(c *item) (float64, float64, float64, float64, bool) {
+	points := Points(c.data)
+	if len(points) < 4 {
+		return 0, 0, 0, 0, false
+	}
+	x, _ := number(c.data, "x")
+	y, _ := number(c.data, "y")
+	n := len(points)
+	return x + points[0], y + points[1], x + points[n-2], y + points[n-1], true
+}
+
+// connectorEnds returns the items the two ends of a connector are attached to:
+// the referenced shapes, or else the shapes under its end points
+func connectorEnds(c *item, items []*item) (*item, *item) {
+	if hasRefs(c.data) {
+		fromId, toId := connectorRefs(c.data)
+		return itemById(items, fromId), itemById(items, toId)
+	}
+	x1, y1, x2, y2, ok := endpoints(c)
+	if !ok {
+		return nil, nil
+	}
+	return itemAt(items, x1, y1), itemAt(items, x2, y2)
+}
+
+func itemById(items []*item, id string) *item {
+	for _, it := range items {
+		if it.row.UUID.String() == id {
+			return it
+		}
+	}
+	return nil
+}
+
+// itemAt returns the smallest item around a point
+func itemAt(items []*item, x, y float64) *item {
+	var found *item
+	for _, it := range items {
+		if it.bounds.Contains(x, y, attachMargin) && (found == nil || it.bounds.Area() < found.bounds.Area()) {
+			found = it
+		}
+	}
+	return found
+}
+
+// applyMoves writes the moves to the shape data, drags attached connectors along
+// (or reroutes them straight between the shapes when reroute is set) and returns
+// the changed rows
+func applyMoves(items []*item, connectors []*item, reroute bool) ([]models.BoardData, error) {
+	changed := map[*item]bool{}
+
+	// connector ends are matched against the positions before the move;
+	// connectors that reference their shapes are rerouted afterwards
+	for _, c := range connectors {
+		if hasRefs(c.data) {
+			continue
+		}
+		from, to := connectorEnds(c, items)
+		if from == nil && to == nil {
+			continue
+		}
+		if reroute && from != nil && to != nil && from != to {
+			rerouteConnector(c, from, to)
+			changed[c] = true
+			continue
+		}
+		if moveConnector(c, from, to) {
+			changed[c] = true
+		}
+	}
+
+	for _, it := range items {
+		if it.dx == 0 && it.dy == 0 {
+			continue
+		}
+		for _, shape := range append([]*item{it}, it.attached...) {
+			Translate(shape.data, it.dx, it.dy)
+			changed[shape] = true
+		}
+	}
+
+	rows := []models.BoardData{}
+	for _, it := range append(items, connectors...) {
+		for _, shape := range append([]*item{it}, it.attached...) {
+			if !changed[shape] {
+				continue
+			}
+			data, err := json.Marshal(shape.data)
+			if err != nil {
+				return nil, err
+			}
+			row := *shape.row
+			row.Data = data
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// moveConnector moves the ends of a connector with the shapes they are attached to;
+// when both ends move the same way the whole connector moves and keeps its bends
+func moveConnector(c *item, from, to *item) bool {
+	delta := func(it *item) (float64, float64) {
+		if it == nil {
+			return 0, 0
+		}
+		return it.dx, it.dy
+	}
+	fdx, fdy := delta(from)
+	tdx, tdy := delta(to)
+	if fdx == 0 && fdy == 0 && tdx == 0 && tdy == 0 {
+		return false
+	}
+	if fdx == tdx && fdy == tdy {
+		Translate(c.data, fdx, fdy)
+		return true
+	}
+
+	points := Points(c.data)
+	n := len(points)
+	points[0], points[1] = roundCoord(points[0]+fdx), roundCoord(points[1]+fdy)
+	points[n-2], points[n-1] = roundCoord(points[n-2]+tdx), roundCoord(points[n-1]+tdy)
+	c.data["points"] = points
+	return true
+}
+
+// rerouteConnector draws a connector straight between the new outlines of two shapes
+func rerouteConnector(c *item, from, to *item) {
+	outline := func(it *item) *diagram.Outline {
+		shape := diagram.NodeRect
+		if it.row.Type == models.Ellipse || it.row.Type == models.Circle {
+			shape = diagram.NodeEllipse
+		}
+		return &diagram.Outline{
+			Shape: shape,
+			CX:    it.bounds.CenterX() + it.dx,
+			CY:    it.bounds.CenterY() + it.dy,
+			W:     it.bounds.W,
+			H:     it.bounds.H,
+		}
+	}
+	a, b := outline(from), outline(to)
+	dx, dy := b.CX-a.CX, b.CY-a.CY
+	x1, y1 := a.BoundaryPoint(dx, dy)
+	x2, y2 := b.BoundaryPoint(-dx, -dy)
+
+	c.data["x"] = roundCoord(x1)
+	c.data["y"] = roundCoord(y1)
+	c.data["points"] = []float64{0, 0, roundCoord(x2 - x1), roundCoord(y2 - y1)}
+}
@@ -0,0 +1,151 @@
+package layout
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"melina-studio-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func testRow(t *testing.T, shapeType models.Type, data map[string]interface{}) models.BoardData {
+	t.Helper()
+	raw, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return models.BoardData{UUID: uuid.New(), Type: shapeType, Data: raw}
+}
+
+func rect(t *testing.T, x, y, w, h float64) models.BoardData {
+	return testRow(t, models.Rect, map[string]interface{}{"x": x, "y": y, "w": w, "h": h})
+}
+
+// positions returns the x, y of the updated rows by id
+func positions(t *testing.T, rows []models.BoardData) map[uuid.UUID][2]float64 {
+	t.Helper()
+	moved := map[uuid.UUID][2]float64{}
+	for _, row := range rows {
+		data := map[string]interface{}{}
+		if err := json.Unmarshal(row.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+		x, _ := number(data, "x")
+		y, _ := number(data, "y")
+		moved[row.UUID] = [2]float64{x, y}
+	}
+	return moved
+}
+
+func TestApply(t *testing.T) {
+	a, b, c := rect(t, 10, 10, 40, 40), rect(t, 55, 100, 20, 20), rect(t, 200, 37, 40, 40)
+	gap := 10.0
+
+	cases := []struct {
+		name string
+		req  Request
+		want map[uuid.UUID][2]float64 // position of every row that should change
+	}{
+		{
+			name: "align left",
+			req:  Request{Operation: OperationAlign, Align: "left"},
+			want: map[uuid.UUID][2]float64{b.UUID: {10, 100}, c.UUID: {10, 37}},
+		},
+		{
+			name: "align top of a selection",
+			req:  Request{Operation: OperationAlign, Align: "top", ShapeIds: []string{a.UUID.String(), c.UUID.String()}},
+			want: map[uuid.UUID][2]float64{c.UUID: {200, 10}},
+		},
+		{
+			name: "distribute with a gap",
+			req:  Request{Operation: OperationDistribute, Axis: "horizontal", Gap: &gap},
+			want: map[uuid.UUID][2]float64{b.UUID: {60, 100}, c.UUID: {90, 37}},
+		},
+		{
+			name: "snap",
+			req:  Request{Operation: OperationSnap, GridSize: 20},
+			want: map[uuid.UUID][2]float64{a.UUID: {20, 20}, b.UUID: {60, 100}, c.UUID: {200, 40}},
+		},
+		{
+			name: "region picks shapes fully inside",
+			req:  Request{Operation: OperationSnap, GridSize: 20, Region: &Bounds{X: 0, Y: 0, W: 100, H: 60}},
+			want: map[uuid.UUID][2]float64{a.UUID: {20, 20}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			shapes := []models.BoardData{a, b, c}
+			updated, err := Apply(shapes, tc.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := positions(t, updated)
+			if len(got) != len(tc.want) {
+				t.Fatalf("updated %v, want %v", got, tc.want)
+			}
+			for id, want := range tc.want {
+				if got[id] != want {
+					t.Fatalf("shape %s at %v, want %v", id, got[id], want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	shapes := []models.BoardData{rect(t, 0, 0, 10, 10), rect(t, 50, 0, 10, 10)}
+
+	cases := []struct {
+		name string
+		req  Request
+		is   error
+	}{
+		{"unknown operation", Request{Operation: "shuffle"}, nil},
+		{"bad align mode", Request{Operation: OperationAlign, Align: "diagonal"}, nil},
+		{"distribute two shapes without a gap", Request{Operation: OperationDistribute, Axis: "vertical"}, nil},
+		{"bad direction", Request{Operation: OperationTree, Direction: "up"}, nil},
+		{"nothing selected", Request{Operation: OperationSnap, ShapeIds: []string{uuid.NewString()}}, ErrNothingToArrange},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Apply(shapes, tc.req)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if tc.is != nil && !errors.Is(err, tc.is) {
+				t.Fatalf("error = %v, want %v", err, tc.is)
+			}
+		})
+	}
+}
+
+func TestApplyMovesAttachedShapes(t *testing.T) {
+	box := rect(t, 10, 10, 100, 60)
+	label := testRow(t, models.Text, map[string]interface{}{"x": 30.0, "y": 30.0, "text": "hi"})
+	other := rect(t, 200, 10, 40, 40)
+	arrow := testRow(t, models.Arrow, map[string]interface{}{
+		"x": 0.0, "y": 0.0, "points": []float64{110, 40, 200, 30},
+		"fromShapeId": other.UUID.String(), "toShapeId": box.UUID.String(),
+	})
+
+	ids := []string{box.UUID.String(), label.UUID.String(), other.UUID.String()}
+	updated, err := Apply([]models.BoardData{box, label, other, arrow}, Request{Operation: OperationAlign, Align: "right", ShapeIds: ids})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := positions(t, updated)
+	if got[box.UUID] != [2]float64{140, 10} {
+		t.Fatalf("box at %v, want (140, 10)", got[box.UUID])
+	}
+	if got[label.UUID] != [2]float64{160, 30} {
+		t.Fatalf("label at %v, want (160, 30) inside the box", got[label.UUID])
+	}
+	if _, ok := got[other.UUID]; ok {
+		t.Fatal("the right most shape did not move and should not be updated")
+	}
+	if _, ok := got[arrow.UUID]; !ok {
+		t.Fatal("the arrow attached to the moved box was not rerouted")
+	}
+}
@@ -0,0 +1,69 @@
+package layout
+
+import (
+	"math"
+	"os"
+	"strconv"
+)
+
+// default distance in pixels a simplified stroke may stray from the drawn one
+const defaultSimplifyTolerance = 1.0
+
+// SimplifyTolerance returns the pencil simplification tolerance,
+// PENCIL_SIMPLIFY_TOLERANCE overrides it and 0 turns simplification off
+func SimplifyTolerance() float64 {
+	if v, err := strconv.ParseFloat(os.Getenv("PENCIL_SIMPLIFY_TOLERANCE"), 64); err == nil && v >= 0 {
+		return v
+	}
+	return defaultSimplifyTolerance
+}
+
+// Simplify drops points of a flat x, y array with Ramer-Douglas-Peucker: every
+// removed point is within tolerance of the simplified path. The ends are kept.
+func Simplify(points []float64, tolerance float64) []float64 {
+	n := len(points) / 2
+	if tolerance <= 0 || n <= 2 {
+		return points
+	}
+
+	keep := make([]bool, n)
+	keep[0], keep[n-1] = true, true
+	// ranges still to check, a stack instead of recursion for long strokes
+	stack := [][2]int{{0, n - 1}}
+	for len(stack) > 0 {
+		first, last := stack[len(stack)-1][0], stack[len(stack)-1][1]
+		stack = stack[:len(stack)-1]
+
+		farthest, maxDist := -1, tolerance
+		for i := first + 1; i < last; i++ {
+			d := segmentDistance(points[2*i], points[2*i+1], points[2*first], points[2*first+1], points[2*last], points[2*last+1])
+			if d > maxDist {
+				farthest, maxDist = i, d
+			}
+		}
+		if farthest < 0 {
+			continue
+		}
+		keep[farthest] = true
+		stack = append(stack, [2]int{first, farthest}, [2]int{farthest, last})
+	}
+
+	simplified := make([]float64, 0, len(points))
+	for i := 0; i < n; i++ {
+		if keep[i] {
+			simplified = append(simplified, points[2*i], points[2*i+1])
+		}
+	}
+	return simplified
+}
+
+// segmentDistance is the distance from (px, py) to the segment (ax, ay)-(bx, by)
+func segmentDistance(px, py, ax, ay, bx, by float64) float64 {
+	dx, dy := bx-ax, by-ay
+	lengthSq := dx*dx + dy*dy
+	if lengthSq == 0 {
+		return math.Hypot(px-ax, py-ay)
+	}
+	t := math.Max(0, math.Min(1, ((px-ax)*dx+(py-ay)*dy)/lengthSq))
+	return math.Hypot(px-(ax+t*dx), py-(ay+t*dy))
+}
@@ -0,0 +1,65 @@
+package layout
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSimplify(t *testing.T) {
+	wave := make([]float64, 0, 400)
+	for i := 0; i < 200; i++ {
+		x := float64(i) * 2
+		wave = append(wave, x, 30*math.Sin(x/25))
+	}
+
+	cases := []struct {
+		name      string
+		points    []float64
+		tolerance float64
+		maxPoints int // upper bound on the simplified points, 0 to skip
+	}{
+		{"straight line", []float64{0, 0, 1, 1, 2, 2, 3, 3, 4, 4}, 0.5, 2},
+		{"jitter below tolerance", []float64{0, 0, 10, 0.4, 20, -0.3, 30, 0.2, 40, 0}, 1, 2},
+		{"corner is kept", []float64{0, 0, 5, 0, 10, 0, 10, 5, 10, 10}, 1, 3},
+		{"sine wave", wave, 1, 60},
+		{"tight tolerance", wave, 0.01, 0},
+		{"two points", []float64{3, 4, 5, 6}, 1, 2},
+		{"closed stroke", []float64{0, 0, 10, 0, 10, 10, 0, 10, 0, 0}, 1, 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			simplified := Simplify(tc.points, tc.tolerance)
+
+			n, m := len(tc.points), len(simplified)
+			if m < 4 || m%2 != 0 {
+				t.Fatalf("simplified to %d coordinates", m)
+			}
+			if simplified[0] != tc.points[0] || simplified[1] != tc.points[1] ||
+				simplified[m-2] != tc.points[n-2] || simplified[m-1] != tc.points[n-1] {
+				t.Fatalf("ends not kept: got %v ... %v", simplified[:2], simplified[m-2:])
+			}
+			if tc.maxPoints > 0 && m/2 > tc.maxPoints {
+				t.Fatalf("kept %d points, want at most %d", m/2, tc.maxPoints)
+			}
+
+			// every dropped point is within tolerance of the simplified path
+			for i := 0; i+1 < n; i += 2 {
+				best := math.Inf(1)
+				for j := 0; j+3 < m; j += 2 {
+					d := segmentDistance(tc.points[i], tc.points[i+1], simplified[j], simplified[j+1], simplified[j+2], simplified[j+3])
+					best = math.Min(best, d)
+				}
+				if best > tc.tolerance+1e-9 {
+					t.Fatalf("point (%v, %v) is %v from the simplified path, tolerance %v", tc.points[i], tc.points[i+1], best, tc.tolerance)
+				}
+			}
+		})
+	}
+}
+
+func TestSimplifyDisabled(t *testing.T) {
+	points := []float64{0, 0, 1, 0.1, 2, 0}
+	if got := Simplify(points, 0); len(got) != len(points) {
+		t.Fatalf("tolerance 0 simplified %v to %v", points, got)
+	}
+}
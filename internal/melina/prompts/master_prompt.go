@@ -72,6 +72,10 @@ var MASTER_PROMPT = `
         Retrieves the current board image.
         Requires boardId.
       </TOOL>
+      <TOOL name="getShapes">
+        Lists shapes with their ids, types, geometry and bounds, in drawing order (later shapes are on top).
+        Requires boardId; bbox and types narrow it down. Use it on large boards or to get shape ids.
+      </TOOL>
       <TOOL name="addShape">
         Adds a shape to the board in react konva format.
         Requires boardId, shapeType, x, y, width, height, radius, stroke, fill, strokeWidth, text, fontSize, fontFamily.
@@ -103,6 +107,10 @@ var MASTER_PROMPT = `
           ### arrow — Arrow
             Properties: points (array), stroke, strokeWidth
             Rendered as Line, draggable, selectable
+          ### connectors — line or arrow attached to shapes
+            Properties: fromShapeId, toShapeId, fromAnchor, toAnchor (top, right, bottom, left or auto), label
+            Pass the shape ids returned by earlier addShape calls instead of x, y and points;
+            the server draws the connector and keeps it attached when the shapes move
           ### eraser — Eraser tool
             Properties: points (array), stroke, strokeWidth
             Rendered as Line
@@ -116,6 +124,28 @@ var MASTER_PROMPT = `
             Draggable, resizable, selectable
         </SHAPES>
       </TOOL>
+      <TOOL name="renderDiagram">
+        Draws a whole flowchart or sequence diagram from Mermaid or PlantUML source.
+        Requires boardId and source; x, y set the top left corner.
+        Use it for flowcharts, process diagrams and sequence diagrams instead of placing shapes one by one.
+      </TOOL>
+      <TOOL name="insertTemplate">
+        Inserts a ready-made layout: Retrospective, Kanban, Wireframe or a template the user saved.
+        Requires boardId and template (title or id); x, y set the top left corner.
+      </TOOL>
+      <TOOL name="getComments">
+        Lists the unresolved comment threads on the board and the shape or position each one is pinned to.
+        Requires boardId. To address comments: read them, make the changes, then call resolveComment for each.
+      </TOOL>
+      <TOOL name="resolveComment">
+        Resolves a comment thread, with an optional reply saying what was done.
+        Requires boardId and commentId.
+      </TOOL>
+      <TOOL name="arrangeShapes">
+        Moves existing shapes: align, distribute, grid, tree, flow or snap.
+        Requires boardId and operation; pick shapes with shapeIds or a region, or leave both out for the whole board.
+        Use it when the user asks to tidy up, align or space out shapes.
+      </TOOL>
     </AVAILABLE>
 
     <USAGE_RULES>
@@ -0,0 +1,118 @@
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"melina-studio-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// built-in templates have fixed ids so clients and the agent can refer to them
+var (
+	retroTemplateId     = uuid.MustParse("7b0c5a3e-1f6d-4c2a-9e57-000000000001")
+	kanbanTemplateId    = uuid.MustParse("7b0c5a3e-1f6d-4c2a-9e57-000000000002")
+	wireframeTemplateId = uuid.MustParse("7b0c5a3e-1f6d-4c2a-9e57-000000000003")
+)
+
+const (
+	templateStroke = "#1f2937"
+	templateText   = "#111827"
+	templateFont   = "Inter"
+)
+
+// BuiltIns returns the templates that ship with the server. They have no
+// thumbnail, clients draw the preview from the shapes.
+func BuiltIns() []models.BoardTemplate {
+	return []models.BoardTemplate{
+		columnsTemplate(retroTemplateId, "Retrospective", "Three columns for a team retro", []column{
+			{"Went well", "#dcfce7"},
+			{"To improve", "#fee2e2"},
+			{"Action items", "#dbeafe"},
+		}),
+		columnsTemplate(kanbanTemplateId, "Kanban", "To do, in progress and done columns", []column{
+			{"To do", "#f3f4f6"},
+			{"In progress", "#fef9c3"},
+			{"Done", "#dcfce7"},
+		}),
+		wireframeTemplate(),
+	}
+}
+
+type column struct {
+	title string
+	fill  string
+}
+
+func columnsTemplate(id uuid.UUID, title, description string, columns []column) models.BoardTemplate {
+	const width, height, gap = 300.0, 560.0, 40.0
+	b := &templateBuilder{templateId: id}
+	b.text(title, 0, 0, 28)
+	for i, col := range columns {
+		x := float64(i) * (width + gap)
+		b.add(models.Rect, map[string]interface{}{"x": x, "y": 60, "w": width, "h": height, "fill": col.fill, "stroke": templateStroke, "strokeWidth": 1})
+		b.text(col.title, x+16, 76, 20)
+	}
+	total := float64(len(columns))*(width+gap) - gap
+	return b.template(title, description, total, 60+height)
+}
+
+func wireframeTemplate() models.BoardTemplate {
+	b := &templateBuilder{templateId: wireframeTemplateId}
+	frame := func(x, y, w, h float64) {
+		b.add(models.Rect, map[string]interface{}{"x": x, "y": y, "w": w, "h": h, "fill": "#ffffff", "stroke": templateStroke, "strokeWidth": 2})
+	}
+	block := func(x, y, w, h float64) {
+		b.add(models.Rect, map[string]interface{}{"x": x, "y": y, "w": w, "h": h, "fill": "#e5e7eb", "stroke": "#9ca3af", "strokeWidth": 1})
+	}
+
+	// desktop page: header, sidebar and content
+	frame(0, 0, 1200, 800)
+	block(0, 0, 1200, 64)
+	b.text("Logo", 24, 22, 20)
+	block(0, 64, 220, 736)
+	block(260, 104, 900, 320)
+	block(260, 464, 430, 296)
+	block(730, 464, 430, 296)
+
+	// mobile screen next to it
+	frame(1280, 0, 375, 800)
+	block(1280, 0, 375, 64)
+	b.text("Logo", 1300, 22, 20)
+	block(1300, 84, 335, 200)
+	block(1300, 304, 335, 120)
+	block(1300, 444, 335, 120)
+
+	return b.template("Wireframe", "Desktop and mobile page frames", 1655, 800)
+}
+
+// templateBuilder collects the shapes of a built-in template
+type templateBuilder struct {
+	templateId uuid.UUID
+	shapes     []models.TemplateShape
+}
+
+func (b *templateBuilder) add(shapeType models.Type, data map[string]interface{}) {
+	raw, _ := json.Marshal(data)
+	// stable ids, they are replaced when the template is used
+	id := uuid.NewSHA1(b.templateId, []byte(fmt.Sprint(len(b.shapes)))).String()
+	b.shapes = append(b.shapes, models.TemplateShape{ID: id, Type: shapeType, Data: raw, ZIndex: len(b.shapes)})
+}
+
+func (b *templateBuilder) text(text string, x, y, fontSize float64) {
+	b.add(models.Text, map[string]interface{}{"x": x, "y": y, "text": text, "fontSize": fontSize, "fontFamily": templateFont, "fill": templateText})
+}
+
+func (b *templateBuilder) template(title, description string, width, height float64) models.BoardTemplate {
+	shapes, _ := json.Marshal(b.shapes)
+	return models.BoardTemplate{
+		UUID:        b.templateId,
+		Title:       title,
+		Description: description,
+		Shapes:      shapes,
+		Width:       width,
+		Height:      height,
+		BuiltIn:     true,
+	}
+}
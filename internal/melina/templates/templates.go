@@ -0,0 +1,138 @@
+package templates
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"melina-studio-backend/internal/melina/layout"
+	"melina-studio-backend/internal/models"
+	"melina-studio-backend/internal/repo"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrTemplateNotFound is returned when no built-in or owned template matches
+var ErrTemplateNotFound = errors.New("template not found")
+
+// FromBoard turns the shapes of a board into template shapes moved so the
+// top left corner of the content is at 0, 0. It returns the content size.
+func FromBoard(rows []models.BoardData) ([]models.TemplateShape, float64, float64, error) {
+	var content *layout.Bounds
+	datas := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		data := map[string]interface{}{}
+		if err := json.Unmarshal(row.Data, &data); err != nil {
+			return nil, 0, 0, fmt.Errorf("invalid data for shape %s: %w", row.UUID, err)
+		}
+		datas[i] = data
+		if b, ok := layout.ShapeBounds(row.Type, data); ok {
+			if content == nil {
+				content = &b
+			} else {
+				union := content.Union(b)
+				content = &union
+			}
+		}
+	}
+	if content == nil {
+		return nil, 0, 0, fmt.Errorf("the board has no shapes")
+	}
+
+	shapes := make([]models.TemplateShape, 0, len(rows))
+	for i, row := range rows {
+		layout.Translate(datas[i], -content.X, -content.Y)
+		raw, err := json.Marshal(datas[i])
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		shapes = append(shapes, models.TemplateShape{ID: row.UUID.String(), Type: row.Type, Data: raw, ZIndex: i})
+	}
+	return shapes, content.W, content.H, nil
+}
+
+// Instantiate returns the shapes of a template placed with its top left corner
+// at x, y. Every shape gets a fresh id and connectors point to the new ids.
+func Instantiate(template *models.BoardTemplate, x, y float64) ([]models.BoardData, error) {
+	var shapes []models.TemplateShape
+	if err := json.Unmarshal(template.Shapes, &shapes); err != nil {
+		return nil, fmt.Errorf("invalid template shapes: %w", err)
+	}
+
+	ids := make(map[string]string, len(shapes))
+	for _, shape := range shapes {
+		ids[shape.ID] = uuid.New().String()
+	}
+
+	rows := make([]models.BoardData, 0, len(shapes))
+	for _, shape := range shapes {
+		data := map[string]interface{}{}
+		if err := json.Unmarshal(shape.Data, &data); err != nil {
+			return nil, fmt.Errorf("invalid data for template shape %s: %w", shape.ID, err)
+		}
+		layout.Translate(data, x, y)
+		for _, key := range []string{"fromShapeId", "toShapeId"} {
+			if ref, ok := data[key].(string); ok {
+				if newId, ok := ids[ref]; ok {
+					data[key] = newId
+				} else {
+					delete(data, key)
+				}
+			}
+		}
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, models.BoardData{
+			UUID: uuid.MustParse(ids[shape.ID]),
+			Type: shape.Type,
+			Data: raw,
+		})
+	}
+	return rows, nil
+}
+
+// List returns the built-in templates followed by the templates of an owner
+func List(templateRepo repo.BoardTemplateRepoInterface, ownerId uuid.UUID) ([]models.BoardTemplate, error) {
+	owned, err := templateRepo.GetTemplates(ownerId)
+	if err != nil {
+		return nil, err
+	}
+	return append(BuiltIns(), owned...), nil
+}
+
+// Find returns a built-in or stored template by id
+func Find(templateRepo repo.BoardTemplateRepoInterface, templateId uuid.UUID) (*models.BoardTemplate, error) {
+	for _, template := range BuiltIns() {
+		if template.UUID == templateId {
+			return &template, nil
+		}
+	}
+	template, err := templateRepo.GetTemplate(templateId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTemplateNotFound
+	}
+	return template, err
+}
+
+// FindByRef finds a template by id or by title among the built-in ones and the
+// ones of an owner, the way the agent refers to templates
+func FindByRef(templateRepo repo.BoardTemplateRepoInterface, ownerId uuid.UUID, ref string) (*models.BoardTemplate, error) {
+	ref = strings.TrimSpace(ref)
+	if templateId, err := uuid.Parse(ref); err == nil {
+		return Find(templateRepo, templateId)
+	}
+	available, err := List(templateRepo, ownerId)
+	if err != nil {
+		return nil, err
+	}
+	for _, template := range available {
+		if strings.EqualFold(template.Title, ref) {
+			return Find(templateRepo, template.UUID)
+		}
+	}
+	return nil, ErrTemplateNotFound
+}
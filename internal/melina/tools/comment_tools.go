@@ -0,0 +1,155 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"melina-studio-backend/internal/config"
+	"melina-studio-backend/internal/melina/layout"
+	"melina-studio-backend/internal/models"
+	"melina-studio-backend/internal/repo"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GetCommentsHandler is the handler for the getComments tool
+// Returns the comment threads of a board with what each one is pinned to
+func GetCommentsHandler(ctx context.Context, input map[string]interface{}) (interface{}, error) {
+	boardIdStr, ok := input["boardId"].(string)
+	if !ok || boardIdStr == "" {
+		return nil, fmt.Errorf("boardId is required and must be a non-empty string")
+	}
+	boardId, err := uuid.Parse(boardIdStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid boardId: %w", err)
+	}
+
+	filter := repo.CommentFilter{}
+	if includeResolved, _ := input["includeResolved"].(bool); !includeResolved {
+		resolved := false
+		filter.Resolved = &resolved
+	}
+	threads, err := repo.NewCommentRepository(config.DB).GetThreads(boardId, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get comments: %w", err)
+	}
+	shapes, err := repo.NewBoardDataRepository(config.DB).GetBoardData(boardId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get board shapes: %w", err)
+	}
+	shapeIndex := make(map[string]models.BoardData, len(shapes))
+	for _, row := range shapes {
+		shapeIndex[row.UUID.String()] = row
+	}
+
+	result := make([]map[string]interface{}, 0, len(threads))
+	for _, thread := range threads {
+		comments := make([]map[string]interface{}, 0, len(thread.Replies)+1)
+		for _, comment := range append([]models.Comment{thread}, thread.Replies...) {
+			comments = append(comments, map[string]interface{}{
+				"id":     comment.UUID.String(),
+				"author": comment.AuthorName,
+				"body":   comment.Body,
+			})
+		}
+		result = append(result, map[string]interface{}{
+			"id":       thread.UUID.String(),
+			"resolved": thread.Resolved,
+			"anchor":   commentAnchor(thread, shapeIndex),
+			"comments": comments,
+		})
+	}
+
+	return map[string]interface{}{
+		"boardId": boardIdStr,
+		"success": true,
+		"message": fmt.Sprintf("Found %d comment threads", len(result)),
+		"threads": result,
+	}, nil
+}
+
+// commentAnchor describes what a thread is pinned to, with the shape's bounds and text
+func commentAnchor(thread models.Comment, shapeIndex map[string]models.BoardData) map[string]interface{} {
+	if thread.ShapeId == nil {
+		return map[string]interface{}{"x": thread.X, "y": thread.Y}
+	}
+	anchor := map[string]interface{}{"shapeId": *thread.ShapeId}
+	row, ok := shapeIndex[*thread.ShapeId]
+	if !ok {
+		anchor["deleted"] = true
+		return anchor
+	}
+	anchor["shapeType"] = string(row.Type)
+	if row.MinX != nil {
+		anchor["bounds"] = layout.Bounds{X: *row.MinX, Y: *row.MinY, W: *row.MaxX - *row.MinX, H: *row.MaxY - *row.MinY}
+	}
+	data := map[string]interface{}{}
+	if err := json.Unmarshal(row.Data, &data); err == nil {
+		if text, ok := data["text"].(string); ok {
+			anchor["text"] = text
+		}
+	}
+	return anchor
+}
+
+// ResolveCommentHandler is the handler for the resolveComment tool
+// Returns a map with special key "_commentEvents", each event is sent as a comment_* message
+func ResolveCommentHandler(ctx context.Context, input map[string]interface{}) (interface{}, error) {
+	boardIdStr, ok := input["boardId"].(string)
+	if !ok || boardIdStr == "" {
+		return nil, fmt.Errorf("boardId is required and must be a non-empty string")
+	}
+	boardId, err := uuid.Parse(boardIdStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid boardId: %w", err)
+	}
+	commentIdStr, _ := input["commentId"].(string)
+	commentId, err := uuid.Parse(commentIdStr)
+	if err != nil {
+		return nil, fmt.Errorf("commentId is required and must be the id of a comment thread")
+	}
+
+	commentRepo := repo.NewCommentRepository(config.DB)
+	thread, err := commentRepo.GetComment(boardId, commentId)
+	if err != nil {
+		return nil, fmt.Errorf("comment %s not found on the board", commentIdStr)
+	}
+	if thread.ParentUUID != nil {
+		if thread, err = commentRepo.GetComment(boardId, *thread.ParentUUID); err != nil {
+			return nil, fmt.Errorf("failed to get comment thread: %w", err)
+		}
+	}
+
+	events := []map[string]interface{}{}
+	if reply, _ := input["reply"].(string); reply != "" {
+		mentions, _ := json.Marshal(models.ParseMentions(reply))
+		threadId := thread.UUID
+		comment := &models.Comment{
+			BoardUUID:  boardId,
+			ParentUUID: &threadId,
+			AuthorID:   uuid.Nil,
+			AuthorName: models.MelinaAuthorName,
+			Body:       reply,
+			Mentions:   mentions,
+		}
+		if _, err := commentRepo.CreateComment(comment); err != nil {
+			return nil, fmt.Errorf("failed to reply to comment: %w", err)
+		}
+		events = append(events, map[string]interface{}{"type": "comment_created", "comment": comment})
+	}
+
+	now := time.Now()
+	if err := commentRepo.UpdateComment(boardId, thread.UUID, map[string]interface{}{"resolved": true, "resolved_at": now, "resolved_by": nil}); err != nil {
+		return nil, fmt.Errorf("failed to resolve comment: %w", err)
+	}
+	thread.Resolved, thread.ResolvedAt = true, &now
+	events = append(events, map[string]interface{}{"type": "comment_updated", "comment": thread})
+
+	return map[string]interface{}{
+		"_commentEvents": events,
+		"boardId":        boardIdStr,
+		"success":        true,
+		"message":        fmt.Sprintf("Resolved comment thread %s", thread.UUID),
+	}, nil
+}
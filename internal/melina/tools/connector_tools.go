@@ -0,0 +1,90 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"melina-studio-backend/internal/config"
+	"melina-studio-backend/internal/melina/layout"
+	"melina-studio-backend/internal/models"
+	"melina-studio-backend/internal/repo"
+
+	"github.com/google/uuid"
+)
+
+// saveToolShapes stores shapes created by a tool right away, so later tool calls
+// (connectors, layouts) can find them; the client saving them again is an update
+func saveToolShapes(boardId string, shapes ...map[string]interface{}) error {
+	boardUUID, err := uuid.Parse(boardId)
+	if err != nil {
+		return fmt.Errorf("invalid boardId: %w", err)
+	}
+	boardDataRepo := repo.NewBoardDataRepository(config.DB)
+	for _, shape := range shapes {
+		raw, err := json.Marshal(shape)
+		if err != nil {
+			return err
+		}
+		var shapeData models.Shape
+		if err := json.Unmarshal(raw, &shapeData); err != nil {
+			return err
+		}
+		if err := boardDataRepo.SaveShapeData(boardUUID, &shapeData); err != nil {
+			return fmt.Errorf("failed to save shape: %w", err)
+		}
+	}
+	return nil
+}
+
+// routeToolConnector computes the points of a line or arrow from the shapes it references
+func routeToolConnector(boardId string, shape map[string]interface{}) error {
+	boardUUID, err := uuid.Parse(boardId)
+	if err != nil {
+		return fmt.Errorf("invalid boardId: %w", err)
+	}
+	shapes, err := repo.NewBoardDataRepository(config.DB).GetBoardData(boardUUID)
+	if err != nil {
+		return fmt.Errorf("failed to get board shapes: %w", err)
+	}
+
+	end := func(idKey, anchorKey string) (*layout.ConnectorEnd, error) {
+		shapeId, _ := shape[idKey].(string)
+		if shapeId == "" {
+			return nil, nil
+		}
+		anchor, _ := shape[anchorKey].(string)
+		if !layout.ValidAnchor(anchor) {
+			return nil, fmt.Errorf("%s must be one of top, right, bottom, left or auto", anchorKey)
+		}
+		for _, row := range shapes {
+			if row.UUID.String() != shapeId {
+				continue
+			}
+			data := map[string]interface{}{}
+			if err := json.Unmarshal(row.Data, &data); err != nil {
+				return nil, err
+			}
+			bounds, ok := layout.ShapeBounds(row.Type, data)
+			if !ok {
+				return nil, fmt.Errorf("shape %s has no position to connect to", shapeId)
+			}
+			return &layout.ConnectorEnd{Type: row.Type, Bounds: bounds, Anchor: layout.Anchor(anchor)}, nil
+		}
+		return nil, fmt.Errorf("shape %s not found on the board", shapeId)
+	}
+
+	from, err := end("fromShapeId", "fromAnchor")
+	if err != nil {
+		return err
+	}
+	to, err := end("toShapeId", "toAnchor")
+	if err != nil {
+		return err
+	}
+	if (from == nil) != (to == nil) {
+		if _, hasPoints := shape["points"]; !hasPoints {
+			return fmt.Errorf("a connector with one free end needs points for that end")
+		}
+	}
+	layout.RouteConnector(shape, from, to)
+	return nil
+}
@@ -0,0 +1,82 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	llmHandlers "melina-studio-backend/internal/llm_handlers"
+	"melina-studio-backend/internal/melina/diagram"
+	"melina-studio-backend/internal/models"
+)
+
+// default top left corner of a diagram when the model doesn't pick one
+const defaultDiagramOrigin = 100.0
+
+// RenderDiagramHandler is the handler for the renderDiagram tool
+// Like addShape it returns "_shapeContent", with every created shape in "shapes" for the relay
+func RenderDiagramHandler(ctx context.Context, input map[string]interface{}) (interface{}, error) {
+	streamCtx, ok := ctx.Value("streamingContext").(*llmHandlers.StreamingContext)
+	if !ok || streamCtx == nil {
+		return nil, fmt.Errorf("streaming context not available - cannot send shapes via WebSocket")
+	}
+	if !streamCtx.IsStreaming() {
+		return nil, fmt.Errorf("WebSocket connection not available - cannot send shapes")
+	}
+
+	boardId, ok := input["boardId"].(string)
+	if !ok || boardId == "" {
+		return nil, fmt.Errorf("boardId is required and must be a non-empty string")
+	}
+	source, ok := input["source"].(string)
+	if !ok || source == "" {
+		return nil, fmt.Errorf("source is required and must be a non-empty string")
+	}
+	x, ok := input["x"].(float64)
+	if !ok {
+		x = defaultDiagramOrigin
+	}
+	y, ok := input["y"].(float64)
+	if !ok {
+		y = defaultDiagramOrigin
+	}
+
+	result, err := diagram.Render(source, x, y)
+	if err != nil {
+		return nil, err
+	}
+
+	shapes, err := shapesToMaps(result.Shapes)
+	if err != nil {
+		return nil, err
+	}
+	// saved now so the diagram's connectors stay attached when its nodes move
+	if err := saveToolShapes(boardId, shapes...); err != nil {
+		return nil, err
+	}
+	shapeIds := make([]string, 0, len(result.Shapes))
+	for _, shape := range result.Shapes {
+		shapeIds = append(shapeIds, shape.ID)
+	}
+
+	return map[string]interface{}{
+		"_shapeContent": true,
+		"boardId":       boardId,
+		"success":       true,
+		"shapeIds":      shapeIds,
+		"message":       fmt.Sprintf("Successfully drew a %s diagram with %d shapes at (%.2f, %.2f), size %.0fx%.0f", result.Kind, len(shapeIds), x, y, result.Width, result.Height),
+		"shapes":        shapes,
+	}, nil
+}
+
+// shapesToMaps converts shapes to the map format sent in shape_created messages
+func shapesToMaps(shapes []models.Shape) ([]map[string]interface{}, error) {
+	data, err := json.Marshal(shapes)
+	if err != nil {
+		return nil, err
+	}
+	maps := []map[string]interface{}{}
+	if err := json.Unmarshal(data, &maps); err != nil {
+		return nil, err
+	}
+	return maps, nil
+}
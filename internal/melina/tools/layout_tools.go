@@ -0,0 +1,67 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"melina-studio-backend/internal/config"
+	"melina-studio-backend/internal/melina/layout"
+	"melina-studio-backend/internal/repo"
+
+	"github.com/google/uuid"
+)
+
+// ArrangeShapesHandler is the handler for the arrangeShapes tool
+// Returns a map with special key "_shapeUpdates", every moved shape is sent as shape_updated
+func ArrangeShapesHandler(ctx context.Context, input map[string]interface{}) (interface{}, error) {
+	boardIdStr, ok := input["boardId"].(string)
+	if !ok || boardIdStr == "" {
+		return nil, fmt.Errorf("boardId is required and must be a non-empty string")
+	}
+	boardId, err := uuid.Parse(boardIdStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid boardId: %w", err)
+	}
+
+	// the tool input has the same fields as a layout request
+	var req layout.Request
+	raw, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, fmt.Errorf("invalid layout input: %w", err)
+	}
+
+	boardDataRepo := repo.NewBoardDataRepository(config.DB)
+	shapes, err := boardDataRepo.GetBoardData(boardId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get board shapes: %w", err)
+	}
+	updated, err := layout.Apply(shapes, req)
+	if err != nil {
+		if errors.Is(err, layout.ErrNothingToArrange) {
+			return nil, fmt.Errorf("no shapes matched the selection")
+		}
+		return nil, err
+	}
+	if err := boardDataRepo.UpdateShapesData(boardId, updated); err != nil {
+		return nil, fmt.Errorf("failed to save moved shapes: %w", err)
+	}
+
+	updatedShapes := make([]map[string]interface{}, 0, len(updated))
+	shapeIds := make([]string, 0, len(updated))
+	for _, row := range updated {
+		updatedShapes = append(updatedShapes, layout.ShapeMap(row))
+		shapeIds = append(shapeIds, row.UUID.String())
+	}
+	return map[string]interface{}{
+		"_shapeUpdates": true,
+		"boardId":       boardIdStr,
+		"success":       true,
+		"shapeIds":      shapeIds,
+		"message":       fmt.Sprintf("Applied %s to the board, %d shapes moved", req.Operation, len(updated)),
+		"shapes":        updatedShapes,
+	}, nil
+}
@@ -0,0 +1,85 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"melina-studio-backend/internal/config"
+	"melina-studio-backend/internal/melina/layout"
+	"melina-studio-backend/internal/models"
+	"melina-studio-backend/internal/repo"
+
+	"github.com/google/uuid"
+)
+
+// most shapes returned to the model by one getShapes call
+const maxToolShapes = 200
+
+// GetShapesHandler is the handler for the getShapes tool
+// Returns the shapes of a board, optionally only those in a region, in drawing order
+func GetShapesHandler(ctx context.Context, input map[string]interface{}) (interface{}, error) {
+	boardIdStr, ok := input["boardId"].(string)
+	if !ok || boardIdStr == "" {
+		return nil, fmt.Errorf("boardId is required and must be a non-empty string")
+	}
+	boardId, err := uuid.Parse(boardIdStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid boardId: %w", err)
+	}
+
+	// one more than the cap tells whether the list was cut
+	query := repo.ShapeQuery{Limit: maxToolShapes + 1}
+	if rawBBox, ok := input["bbox"]; ok && rawBBox != nil {
+		raw, err := json.Marshal(rawBBox)
+		if err != nil {
+			return nil, err
+		}
+		var bbox layout.Bounds
+		if err := json.Unmarshal(raw, &bbox); err != nil {
+			return nil, fmt.Errorf("bbox must have numeric x, y, w and h")
+		}
+		query.BBox = &bbox
+	}
+	if types, ok := input["types"].([]interface{}); ok {
+		for _, t := range types {
+			if name, ok := t.(string); ok {
+				query.Types = append(query.Types, models.Type(name))
+			}
+		}
+	}
+
+	rows, err := repo.NewBoardDataRepository(config.DB).QueryShapes(boardId, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get board shapes: %w", err)
+	}
+	truncated := len(rows) > maxToolShapes
+	if truncated {
+		rows = rows[:maxToolShapes]
+	}
+
+	shapes := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		shape := layout.ShapeMap(row)
+		if row.MinX != nil {
+			shape["bounds"] = layout.Bounds{X: *row.MinX, Y: *row.MinY, W: *row.MaxX - *row.MinX, H: *row.MaxY - *row.MinY}
+		}
+		// freehand strokes can have thousands of points, their bounds are enough
+		if row.Type == models.Pencil {
+			shape["pointCount"] = len(layout.Points(shape)) / 2
+			delete(shape, "points")
+		}
+		shapes = append(shapes, shape)
+	}
+	message := fmt.Sprintf("Found %d shapes", len(shapes))
+	if truncated {
+		message += fmt.Sprintf(", only the first %d are listed: narrow the bbox or types to see the rest", maxToolShapes)
+	}
+	return map[string]interface{}{
+		"boardId":   boardIdStr,
+		"success":   true,
+		"message":   message,
+		"count":     len(shapes),
+		"truncated": truncated,
+		"shapes":    shapes,
+	}, nil
+}
@@ -0,0 +1,92 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"melina-studio-backend/internal/config"
+	llmHandlers "melina-studio-backend/internal/llm_handlers"
+	"melina-studio-backend/internal/melina/layout"
+	"melina-studio-backend/internal/melina/templates"
+	"melina-studio-backend/internal/repo"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// InsertTemplateHandler is the handler for the insertTemplate tool
+// Like renderDiagram it returns "_shapeContent", with every created shape in "shapes" for the relay
+func InsertTemplateHandler(ctx context.Context, input map[string]interface{}) (interface{}, error) {
+	streamCtx, ok := ctx.Value("streamingContext").(*llmHandlers.StreamingContext)
+	if !ok || streamCtx == nil {
+		return nil, fmt.Errorf("streaming context not available - cannot send shapes via WebSocket")
+	}
+	if !streamCtx.IsStreaming() {
+		return nil, fmt.Errorf("WebSocket connection not available - cannot send shapes")
+	}
+
+	boardIdStr, ok := input["boardId"].(string)
+	if !ok || boardIdStr == "" {
+		return nil, fmt.Errorf("boardId is required and must be a non-empty string")
+	}
+	boardId, err := uuid.Parse(boardIdStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid boardId: %w", err)
+	}
+	ref, ok := input["template"].(string)
+	if !ok || ref == "" {
+		return nil, fmt.Errorf("template is required and must be a template title or id")
+	}
+	x, ok := input["x"].(float64)
+	if !ok {
+		x = defaultDiagramOrigin
+	}
+	y, ok := input["y"].(float64)
+	if !ok {
+		y = defaultDiagramOrigin
+	}
+
+	board, err := repo.NewBoardRepository(config.DB).GetBoardByID(boardId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get board: %w", err)
+	}
+	templateRepo := repo.NewBoardTemplateRepository(config.DB)
+	template, err := templates.FindByRef(templateRepo, board.UserID, ref)
+	if errors.Is(err, templates.ErrTemplateNotFound) {
+		// tell the model what it can pick from
+		available, listErr := templates.List(templateRepo, board.UserID)
+		if listErr != nil {
+			return nil, err
+		}
+		titles := make([]string, 0, len(available))
+		for _, t := range available {
+			titles = append(titles, t.Title)
+		}
+		return nil, fmt.Errorf("template %q not found, available templates: %s", ref, strings.Join(titles, ", "))
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get template: %w", err)
+	}
+
+	rows, err := templates.Instantiate(template, x, y)
+	if err != nil {
+		return nil, err
+	}
+	if err := repo.NewBoardDataRepository(config.DB).CreateShapes(boardId, rows); err != nil {
+		return nil, fmt.Errorf("failed to save template shapes: %w", err)
+	}
+
+	shapes := make([]map[string]interface{}, 0, len(rows))
+	shapeIds := make([]string, 0, len(rows))
+	for _, row := range rows {
+		shapes = append(shapes, layout.ShapeMap(row))
+		shapeIds = append(shapeIds, row.UUID.String())
+	}
+	return map[string]interface{}{
+		"_shapeContent": true,
+		"boardId":       boardIdStr,
+		"success":       true,
+		"shapeIds":      shapeIds,
+		"message":       fmt.Sprintf("Inserted the %s template with %d shapes at (%.2f, %.2f), size %.0fx%.0f", template.Title, len(shapeIds), x, y, template.Width, template.Height),
+		"shapes":        shapes,
+	}, nil
+}
@@ -3,8 +3,8 @@ package tools
 import (
 	"context"
 	"fmt"
-	"melina-studio-backend/internal/libraries"
 	llmHandlers "melina-studio-backend/internal/llm_handlers"
+	"melina-studio-backend/internal/melina/layout"
 
 	"github.com/google/uuid"
 )
@@ -30,9 +30,38 @@ func GetAnthropicTools() []map[string]interface{} {
 				"required": []string{"boardId"},
 			},
 		},
+		{
+			"name": "getShapes",
+			"description": "Lists the shapes of the board with their ids, types and geometry, optionally only those in a region or of some types. Use it to get the ids of shapes to connect or arrange, or to read the text on a large board without loading everything.",
+			"input_schema": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"boardId": map[string]interface{}{
+						"type":        "string",
+						"description": "The UUID of the board",
+					},
+					"bbox": map[string]interface{}{
+						"type":        "object",
+						"description": "Only shapes overlapping this rectangle (board coordinates). Leave it out for the whole board",
+						"properties": map[string]interface{}{
+							"x": map[string]interface{}{"type": "number"},
+							"y": map[string]interface{}{"type": "number"},
+							"w": map[string]interface{}{"type": "number"},
+							"h": map[string]interface{}{"type": "number"},
+						},
+					},
+					"types": map[string]interface{}{
+						"type":        "array",
+						"items":       map[string]interface{}{"type": "string", "enum": []string{"rect", "circle", "line", "arrow", "ellipse", "polygon", "text", "pencil", "image"}},
+						"description": "Only shapes of these types",
+					},
+				},
+				"required": []string{"boardId"},
+			},
+		},
 		{
 			"name": "addShape",
-			"description": "Adds a shape to the board in react konva format. Supports rect, circle, line, arrow, ellipse, polygon, text, and pencil. For complex shapes like animals, break them down into multiple basic shapes. The shape will appear on the board immediately.",
+			"description": "Adds a shape to the board in react konva format. Supports rect, circle, line, arrow, ellipse, polygon, text, and pencil. For complex shapes like animals, break them down into multiple basic shapes. To connect shapes, add an arrow or line with fromShapeId/toShapeId set to the shapeIds returned for those shapes; it follows them when they move. The shape will appear on the board immediately.",
 			"input_schema": map[string]interface{}{
 				"type": "object",
 				"properties": map[string]interface{}{
@@ -47,11 +76,11 @@ func GetAnthropicTools() []map[string]interface{} {
 					},
 					"x": map[string]interface{}{
 						"type":        "number",
-						"description": "X coordinate (required for most shapes)",
+						"description": "X coordinate (required, except for connectors with fromShapeId/toShapeId)",
 					},
 					"y": map[string]interface{}{
 						"type":        "number",
-						"description": "Y coordinate (required for most shapes)",
+						"description": "Y coordinate (required, except for connectors with fromShapeId/toShapeId)",
 					},
 					"width": map[string]interface{}{
 						"type":        "number",
@@ -94,8 +123,183 @@ func GetAnthropicTools() []map[string]interface{} {
 						"items": map[string]interface{}{"type": "number"},
 						"description": "Array of coordinates [x1, y1, x2, y2, ...] for line, arrow, polygon, or pencil",
 					},
+					"fromShapeId": map[string]interface{}{
+						"type":        "string",
+						"description": "For line and arrow: id of the shape the connector starts at. The connector stays attached when the shape moves; x, y and points are computed",
+					},
+					"toShapeId": map[string]interface{}{
+						"type":        "string",
+						"description": "For line and arrow: id of the shape the connector ends at",
+					},
+					"fromAnchor": map[string]interface{}{
+						"type":        "string",
+						"enum":        []string{"top", "right", "bottom", "left", "auto"},
+						"description": "Side of the start shape to attach to (default: auto)",
+					},
+					"toAnchor": map[string]interface{}{
+						"type":        "string",
+						"enum":        []string{"top", "right", "bottom", "left", "auto"},
+						"description": "Side of the end shape to attach to (default: auto)",
+					},
+					"label": map[string]interface{}{
+						"type":        "string",
+						"description": "For line and arrow: optional label drawn on the connector",
+					},
 				},
-				"required": []string{"boardId", "shapeType", "x", "y"},
+				"required": []string{"boardId", "shapeType"},
+			},
+		},
+		{
+			"name": "renderDiagram",
+			"description": "Draws a flowchart or sequence diagram from Mermaid (flowchart/graph, sequenceDiagram) or PlantUML (sequence) source. The backend lays the diagram out and adds its boxes, labels and arrows to the board. Prefer this over placing shapes one by one for flowcharts and sequence diagrams.",
+			"input_schema": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"boardId": map[string]interface{}{
+						"type":        "string",
+						"description": "The UUID of the board to draw the diagram on",
+					},
+					"source": map[string]interface{}{
+						"type":        "string",
+						"description": "Diagram source, e.g. 'flowchart TD\\n  A[Start] --> B{Ok?}\\n  B -->|yes| C[Done]' or 'sequenceDiagram\\n  Alice->>Bob: Hello'",
+					},
+					"x": map[string]interface{}{
+						"type":        "number",
+						"description": "X coordinate of the diagram's top left corner (default: 100)",
+					},
+					"y": map[string]interface{}{
+						"type":        "number",
+						"description": "Y coordinate of the diagram's top left corner (default: 100)",
+					},
+				},
+				"required": []string{"boardId", "source"},
+			},
+		},
+		{
+			"name": "arrangeShapes",
+			"description": "Moves existing shapes on the board: align, distribute, arrange in a grid, lay out as a tree or flow along their arrows, or snap to a grid. Texts inside a shape move with it and arrows follow the shapes they connect. Use it to tidy up or align shapes.",
+			"input_schema": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"boardId": map[string]interface{}{
+						"type":        "string",
+						"description": "The UUID of the board",
+					},
+					"operation": map[string]interface{}{
+						"type":        "string",
+						"enum":        []string{"align", "distribute", "grid", "tree", "flow", "snap"},
+						"description": "align: line shapes up on one edge or center. distribute: equal spacing along an axis. grid: arrange in rows and columns. tree: layered top-down layout along the arrows between shapes. flow: the same, left to right. snap: snap positions to a grid",
+					},
+					"shapeIds": map[string]interface{}{
+						"type":        "array",
+						"items":       map[string]interface{}{"type": "string"},
+						"description": "Ids of the shapes to arrange",
+					},
+					"region": map[string]interface{}{
+						"type":        "object",
+						"description": "Arrange the shapes fully inside this rectangle; used when shapeIds is empty. With neither, the whole board is arranged",
+						"properties": map[string]interface{}{
+							"x": map[string]interface{}{"type": "number"},
+							"y": map[string]interface{}{"type": "number"},
+							"w": map[string]interface{}{"type": "number"},
+							"h": map[string]interface{}{"type": "number"},
+						},
+					},
+					"align": map[string]interface{}{
+						"type":        "string",
+						"enum":        []string{"left", "center", "right", "top", "middle", "bottom"},
+						"description": "For align: the edge or center to align to",
+					},
+					"axis": map[string]interface{}{
+						"type":        "string",
+						"enum":        []string{"horizontal", "vertical"},
+						"description": "For distribute: the axis to space the shapes along",
+					},
+					"columns": map[string]interface{}{
+						"type":        "number",
+						"description": "For grid: number of columns (default: square grid)",
+					},
+					"gap": map[string]interface{}{
+						"type":        "number",
+						"description": "For distribute and grid: space between shapes (grid default: 40, distribute default: even spacing)",
+					},
+					"gridSize": map[string]interface{}{
+						"type":        "number",
+						"description": "For snap: grid cell size (default: 20)",
+					},
+					"direction": map[string]interface{}{
+						"type":        "string",
+						"enum":        []string{"TB", "BT", "LR", "RL"},
+						"description": "For tree and flow: layout direction (tree default: TB, flow default: LR)",
+					},
+				},
+				"required": []string{"boardId", "operation"},
+			},
+		},
+		{
+			"name": "insertTemplate",
+			"description": "Inserts a board template (a ready-made layout such as Retrospective, Kanban or Wireframe, or one saved by the user) into the board with its top left corner at x, y. Use it when the user asks for one of these starting layouts.",
+			"input_schema": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"boardId": map[string]interface{}{
+						"type":        "string",
+						"description": "The UUID of the board",
+					},
+					"template": map[string]interface{}{
+						"type":        "string",
+						"description": "Title or id of the template, e.g. 'Retrospective', 'Kanban' or 'Wireframe'",
+					},
+					"x": map[string]interface{}{
+						"type":        "number",
+						"description": "X coordinate of the top left corner (default: 100)",
+					},
+					"y": map[string]interface{}{
+						"type":        "number",
+						"description": "Y coordinate of the top left corner (default: 100)",
+					},
+				},
+				"required": []string{"boardId", "template"},
+			},
+		},
+		{
+			"name": "getComments",
+			"description": "Lists the comment threads reviewers left on the board: what each one is pinned to (a shape with its bounds and text, or a position) and the comments in it. By default only unresolved threads are returned. Use it when asked to address or answer comments.",
+			"input_schema": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"boardId": map[string]interface{}{
+						"type":        "string",
+						"description": "The UUID of the board",
+					},
+					"includeResolved": map[string]interface{}{
+						"type":        "boolean",
+						"description": "Also return resolved threads (default: false)",
+					},
+				},
+				"required": []string{"boardId"},
+			},
+		},
+		{
+			"name": "resolveComment",
+			"description": "Marks a comment thread as resolved, optionally replying to it first to say what was changed. Use it after addressing a comment.",
+			"input_schema": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"boardId": map[string]interface{}{
+						"type":        "string",
+						"description": "The UUID of the board",
+					},
+					"commentId": map[string]interface{}{
+						"type":        "string",
+						"description": "The id of the comment thread, as returned by getComments",
+					},
+					"reply": map[string]interface{}{
+						"type":        "string",
+						"description": "Optional reply posted in the thread before resolving it",
+					},
+				},
+				"required": []string{"boardId", "commentId"},
 			},
 		},
 	}
@@ -120,11 +324,43 @@ func GetOpenAITools() []map[string]interface{} {
 				},
 			},
 		},
+		{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        "getShapes",
+				"description": "Lists the shapes of the board with their ids, types and geometry, optionally only those in a region or of some types. Use it to get the ids of shapes to connect or arrange, or to read the text on a large board without loading everything.",
+				"parameters": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"boardId": map[string]interface{}{
+							"type":        "string",
+							"description": "The UUID of the board",
+						},
+						"bbox": map[string]interface{}{
+							"type":        "object",
+							"description": "Only shapes overlapping this rectangle (board coordinates). Leave it out for the whole board",
+							"properties": map[string]interface{}{
+								"x": map[string]interface{}{"type": "number"},
+								"y": map[string]interface{}{"type": "number"},
+								"w": map[string]interface{}{"type": "number"},
+								"h": map[string]interface{}{"type": "number"},
+							},
+						},
+						"types": map[string]interface{}{
+							"type":        "array",
+							"items":       map[string]interface{}{"type": "string", "enum": []string{"rect", "circle", "line", "arrow", "ellipse", "polygon", "text", "pencil", "image"}},
+							"description": "Only shapes of these types",
+						},
+					},
+					"required": []string{"boardId"},
+				},
+			},
+		},
 		{
 			"type": "function",
 			"function": map[string]interface{}{
 				"name":        "addShape",
-				"description": "Adds a shape to the board in react konva format. Supports rect, circle, line, arrow, ellipse, polygon, text, and pencil. For complex shapes like animals, break them down into multiple basic shapes. The shape will appear on the board immediately.",
+				"description": "Adds a shape to the board in react konva format. Supports rect, circle, line, arrow, ellipse, polygon, text, and pencil. For complex shapes like animals, break them down into multiple basic shapes. To connect shapes, add an arrow or line with fromShapeId/toShapeId set to the shapeIds returned for those shapes; it follows them when they move. The shape will appear on the board immediately.",
 				"parameters": map[string]interface{}{
 					"type": "object",
 					"properties": map[string]interface{}{
@@ -139,11 +375,11 @@ func GetOpenAITools() []map[string]interface{} {
 						},
 						"x": map[string]interface{}{
 							"type":        "number",
-							"description": "X coordinate (required for most shapes)",
+							"description": "X coordinate (required, except for connectors with fromShapeId/toShapeId)",
 						},
 						"y": map[string]interface{}{
 							"type":        "number",
-							"description": "Y coordinate (required for most shapes)",
+							"description": "Y coordinate (required, except for connectors with fromShapeId/toShapeId)",
 						},
 						"width": map[string]interface{}{
 							"type":        "number",
@@ -186,8 +422,198 @@ func GetOpenAITools() []map[string]interface{} {
 							"items": map[string]interface{}{"type": "number"},
 							"description": "Array of coordinates [x1, y1, x2, y2, ...] for line, arrow, polygon, or pencil",
 						},
+						"fromShapeId": map[string]interface{}{
+							"type":        "string",
+							"description": "For line and arrow: id of the shape the connector starts at. The connector stays attached when the shape moves; x, y and points are computed",
+						},
+						"toShapeId": map[string]interface{}{
+							"type":        "string",
+							"description": "For line and arrow: id of the shape the connector ends at",
+						},
+						"fromAnchor": map[string]interface{}{
+							"type":        "string",
+							"enum":        []string{"top", "right", "bottom", "left", "auto"},
+							"description": "Side of the start shape to attach to (default: auto)",
+						},
+						"toAnchor": map[string]interface{}{
+							"type":        "string",
+							"enum":        []string{"top", "right", "bottom", "left", "auto"},
+							"description": "Side of the end shape to attach to (default: auto)",
+						},
+						"label": map[string]interface{}{
+							"type":        "string",
+							"description": "For line and arrow: optional label drawn on the connector",
+						},
+					},
+					"required": []string{"boardId", "shapeType"},
+				},
+			},
+		},
+		{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        "renderDiagram",
+				"description": "Draws a flowchart or sequence diagram from Mermaid (flowchart/graph, sequenceDiagram) or PlantUML (sequence) source. The backend lays the diagram out and adds its boxes, labels and arrows to the board. Prefer this over placing shapes one by one for flowcharts and sequence diagrams.",
+				"parameters": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"boardId": map[string]interface{}{
+							"type":        "string",
+							"description": "The UUID of the board to draw the diagram on",
+						},
+						"source": map[string]interface{}{
+							"type":        "string",
+							"description": "Diagram source, e.g. 'flowchart TD\\n  A[Start] --> B{Ok?}\\n  B -->|yes| C[Done]' or 'sequenceDiagram\\n  Alice->>Bob: Hello'",
+						},
+						"x": map[string]interface{}{
+							"type":        "number",
+							"description": "X coordinate of the diagram's top left corner (default: 100)",
+						},
+						"y": map[string]interface{}{
+							"type":        "number",
+							"description": "Y coordinate of the diagram's top left corner (default: 100)",
+						},
+					},
+					"required": []string{"boardId", "source"},
+				},
+			},
+		},
+		{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        "arrangeShapes",
+				"description": "Moves existing shapes on the board: align, distribute, arrange in a grid, lay out as a tree or flow along their arrows, or snap to a grid. Texts inside a shape move with it and arrows follow the shapes they connect. Use it to tidy up or align shapes.",
+				"parameters": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"boardId": map[string]interface{}{
+							"type":        "string",
+							"description": "The UUID of the board",
+						},
+						"operation": map[string]interface{}{
+							"type":        "string",
+							"enum":        []string{"align", "distribute", "grid", "tree", "flow", "snap"},
+							"description": "align: line shapes up on one edge or center. distribute: equal spacing along an axis. grid: arrange in rows and columns. tree: layered top-down layout along the arrows between shapes. flow: the same, left to right. snap: snap positions to a grid",
+						},
+						"shapeIds": map[string]interface{}{
+							"type":        "array",
+							"items":       map[string]interface{}{"type": "string"},
+							"description": "Ids of the shapes to arrange",
+						},
+						"region": map[string]interface{}{
+							"type":        "object",
+							"description": "Arrange the shapes fully inside this rectangle; used when shapeIds is empty. With neither, the whole board is arranged",
+							"properties": map[string]interface{}{
+								"x": map[string]interface{}{"type": "number"},
+								"y": map[string]interface{}{"type": "number"},
+								"w": map[string]interface{}{"type": "number"},
+								"h": map[string]interface{}{"type": "number"},
+							},
+						},
+						"align": map[string]interface{}{
+							"type":        "string",
+							"enum":        []string{"left", "center", "right", "top", "middle", "bottom"},
+							"description": "For align: the edge or center to align to",
+						},
+						"axis": map[string]interface{}{
+							"type":        "string",
+							"enum":        []string{"horizontal", "vertical"},
+							"description": "For distribute: the axis to space the shapes along",
+						},
+						"columns": map[string]interface{}{
+							"type":        "number",
+							"description": "For grid: number of columns (default: square grid)",
+						},
+						"gap": map[string]interface{}{
+							"type":        "number",
+							"description": "For distribute and grid: space between shapes (grid default: 40, distribute default: even spacing)",
+						},
+						"gridSize": map[string]interface{}{
+							"type":        "number",
+							"description": "For snap: grid cell size (default: 20)",
+						},
+						"direction": map[string]interface{}{
+							"type":        "string",
+							"enum":        []string{"TB", "BT", "LR", "RL"},
+							"description": "For tree and flow: layout direction (tree default: TB, flow default: LR)",
+						},
+					},
+					"required": []string{"boardId", "operation"},
+				},
+			},
+		},
+		{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        "insertTemplate",
+				"description": "Inserts a board template (a ready-made layout such as Retrospective, Kanban or Wireframe, or one saved by the user) into the board with its top left corner at x, y. Use it when the user asks for one of these starting layouts.",
+				"parameters": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"boardId": map[string]interface{}{
+							"type":        "string",
+							"description": "The UUID of the board",
+						},
+						"template": map[string]interface{}{
+							"type":        "string",
+							"description": "Title or id of the template, e.g. 'Retrospective', 'Kanban' or 'Wireframe'",
+						},
+						"x": map[string]interface{}{
+							"type":        "number",
+							"description": "X coordinate of the top left corner (default: 100)",
+						},
+						"y": map[string]interface{}{
+							"type":        "number",
+							"description": "Y coordinate of the top left corner (default: 100)",
+						},
+					},
+					"required": []string{"boardId", "template"},
+				},
+			},
+		},
+		{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        "getComments",
+				"description": "Lists the comment threads reviewers left on the board: what each one is pinned to (a shape with its bounds and text, or a position) and the comments in it. By default only unresolved threads are returned. Use it when asked to address or answer comments.",
+				"parameters": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"boardId": map[string]interface{}{
+							"type":        "string",
+							"description": "The UUID of the board",
+						},
+						"includeResolved": map[string]interface{}{
+							"type":        "boolean",
+							"description": "Also return resolved threads (default: false)",
+						},
+					},
+					"required": []string{"boardId"},
+				},
+			},
+		},
+		{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        "resolveComment",
+				"description": "Marks a comment thread as resolved, optionally replying to it first to say what was changed. Use it after addressing a comment.",
+				"parameters": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"boardId": map[string]interface{}{
+							"type":        "string",
+							"description": "The UUID of the board",
+						},
+						"commentId": map[string]interface{}{
+							"type":        "string",
+							"description": "The id of the comment thread, as returned by getComments",
+						},
+						"reply": map[string]interface{}{
+							"type":        "string",
+							"description": "Optional reply posted in the thread before resolving it",
+						},
 					},
-					"required": []string{"boardId", "shapeType", "x", "y"},
+					"required": []string{"boardId", "commentId"},
 				},
 			},
 		},
@@ -246,8 +672,8 @@ func AddShapeHandler(ctx context.Context, input map[string]interface{}) (interfa
 		return nil, fmt.Errorf("invalid streaming context type")
 	}
 
-	// Check if hub and client are available
-	if streamCtx == nil || streamCtx.Hub == nil || streamCtx.Client == nil {
+	// Check if anyone is listening for the created shape
+	if !streamCtx.IsStreaming() {
 		return nil, fmt.Errorf("WebSocket connection not available - cannot send shape")
 	}
 
@@ -276,13 +702,18 @@ func AddShapeHandler(ctx context.Context, input map[string]interface{}) (interfa
 		return nil, fmt.Errorf("invalid shape type: %s", shapeType)
 	}
 
+	// connectors may reference shapes instead of giving coordinates
+	fromShapeId, _ := input["fromShapeId"].(string)
+	toShapeId, _ := input["toShapeId"].(string)
+	isConnector := (shapeType == "line" || shapeType == "arrow") && (fromShapeId != "" || toShapeId != "")
+
 	// Extract and validate coordinates
 	x, ok := input["x"].(float64)
-	if !ok {
+	if !ok && !isConnector {
 		return nil, fmt.Errorf("x coordinate is required and must be a number")
 	}
 	y, ok := input["y"].(float64)
-	if !ok {
+	if !ok && !isConnector {
 		return nil, fmt.Errorf("y coordinate is required and must be a number")
 	}
 	
@@ -321,6 +752,9 @@ func AddShapeHandler(ctx context.Context, input map[string]interface{}) (interfa
 					points = append(points, float64(v))
 				}
 			}
+			if shapeType == "pencil" {
+				points = layout.Simplify(points, layout.SimplifyTolerance())
+			}
 			if len(points) > 0 {
 				shape["points"] = points
 			}
@@ -348,13 +782,30 @@ func AddShapeHandler(ctx context.Context, input map[string]interface{}) (interfa
 		shape["strokeWidth"] = strokeWidth
 	}
 
+	if shapeType == "line" || shapeType == "arrow" {
+		for _, key := range []string{"fromShapeId", "toShapeId", "fromAnchor", "toAnchor", "label"} {
+			if value, ok := input[key].(string); ok && value != "" {
+				shape[key] = value
+			}
+		}
+	}
+	if isConnector {
+		if err := routeToolConnector(boardId, shape); err != nil {
+			return nil, err
+		}
+		x, _ = shape["x"].(float64)
+		y, _ = shape["y"].(float64)
+	}
 
+	// stored right away so later connectors and layouts can reference the shape
+	if err := saveToolShapes(boardId, shape); err != nil {
+		return nil, err
+	}
 
-	// Emit WebSocket event
-	libraries.SendShapeCreatedMessage(streamCtx.Hub, streamCtx.Client, boardId, shape)
-
-	// Return success response
+	// Return success response - the shape reaches the client through the tool_result event
 	return map[string]interface{}{
+		"_shapeContent": true,
+		"boardId":  boardId,
 		"success":  true,
 		"shapeId":  shape["id"],
 		"message":  fmt.Sprintf("Successfully created %s shape at (%.2f, %.2f)", shapeType, x, y),
@@ -364,11 +815,39 @@ func AddShapeHandler(ctx context.Context, input map[string]interface{}) (interfa
 
 // RegisterAllTools registers all tools with the toolHandlers registry
 func RegisterAllTools() {
-	llmHandlers.RegisterTool("getBoardData", func(ctx context.Context, input map[string]interface{}) (interface{}, error) {
+	llmHandlers.RegisterToolWithConcurrency("getBoardData", llmHandlers.ToolReadOnly, func(ctx context.Context, input map[string]interface{}) (interface{}, error) {
 		return GetBoardDataHandler(ctx, input)
 	})
 
-	llmHandlers.RegisterTool("addShape", func(ctx context.Context, input map[string]interface{}) (interface{}, error) {
+	llmHandlers.RegisterToolWithConcurrency("getShapes", llmHandlers.ToolReadOnly, func(ctx context.Context, input map[string]interface{}) (interface{}, error) {
+		return GetShapesHandler(ctx, input)
+	})
+
+	// new shapes are stacked above the existing ones, so creations run in model order
+	llmHandlers.RegisterToolWithConcurrency("addShape", llmHandlers.ToolSerial, func(ctx context.Context, input map[string]interface{}) (interface{}, error) {
 		return AddShapeHandler(ctx, input)
 	})
+
+	// diagrams create new shapes as well
+	llmHandlers.RegisterToolWithConcurrency("renderDiagram", llmHandlers.ToolSerial, func(ctx context.Context, input map[string]interface{}) (interface{}, error) {
+		return RenderDiagramHandler(ctx, input)
+	})
+
+	// templates too
+	llmHandlers.RegisterToolWithConcurrency("insertTemplate", llmHandlers.ToolSerial, func(ctx context.Context, input map[string]interface{}) (interface{}, error) {
+		return InsertTemplateHandler(ctx, input)
+	})
+
+	llmHandlers.RegisterToolWithConcurrency("getComments", llmHandlers.ToolReadOnly, func(ctx context.Context, input map[string]interface{}) (interface{}, error) {
+		return GetCommentsHandler(ctx, input)
+	})
+
+	llmHandlers.RegisterToolWithConcurrency("resolveComment", llmHandlers.ToolSerial, func(ctx context.Context, input map[string]interface{}) (interface{}, error) {
+		return ResolveCommentHandler(ctx, input)
+	})
+
+	// moves depend on where the shapes are, so layouts run one at a time
+	llmHandlers.RegisterToolWithConcurrency("arrangeShapes", llmHandlers.ToolSerial, func(ctx context.Context, input map[string]interface{}) (interface{}, error) {
+		return ArrangeShapesHandler(ctx, input)
+	})
 }
\ No newline at end of file
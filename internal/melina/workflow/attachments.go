@@ -0,0 +1,76 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"melina-studio-backend/internal/melina/helpers"
+	"melina-studio-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// maxAttachmentsPerMessage bounds how many images a single message can carry
+const maxAttachmentsPerMessage = 4
+
+// loadAttachments resolves the attachment ids of a message and reads the images from the blob store
+func (w *Workflow) loadAttachments(ctx context.Context, boardUUID uuid.UUID, attachmentIds []string) ([]models.ChatAttachment, []helpers.ImageAttachment, error) {
+	if len(attachmentIds) == 0 {
+		return nil, nil, nil
+	}
+	if len(attachmentIds) > maxAttachmentsPerMessage {
+		return nil, nil, fmt.Errorf("at most %d attachments per message", maxAttachmentsPerMessage)
+	}
+
+	ids := make([]uuid.UUID, 0, len(attachmentIds))
+	for _, id := range attachmentIds {
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid attachment id: %s", id)
+		}
+		ids = append(ids, parsed)
+	}
+
+	attachments, err := w.attachmentRepo.GetAttachments(boardUUID, ids)
+	if err != nil {
+		return nil, nil, errors.New("attachment not found")
+	}
+
+	images := make([]helpers.ImageAttachment, 0, len(attachments))
+	for _, attachment := range attachments {
+		data, err := w.blobStore.Get(ctx, attachment.StorageKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read attachment %s: %w", attachment.UUID, err)
+		}
+		images = append(images, helpers.ImageAttachment{
+			MediaType: attachment.MediaType,
+			Data:      data,
+		})
+	}
+	return attachments, images, nil
+}
+
+// linkAttachments references the attachments from the saved user message; failures are only logged
+func (w *Workflow) linkAttachments(humanMessageId uuid.UUID, attachments []models.ChatAttachment) {
+	if err := w.attachmentRepo.LinkAttachments(humanMessageId, attachments); err != nil {
+		log.Printf("Failed to link attachments: %v", err)
+	}
+}
+
+// attachmentIdsOf returns the attachment ids referenced by a chat
+func attachmentIdsOf(chat *models.Chat) []string {
+	if len(chat.Attachments) == 0 {
+		return nil
+	}
+	var refs []models.AttachmentRef
+	if err := json.Unmarshal(chat.Attachments, &refs); err != nil {
+		return nil
+	}
+	ids := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		ids = append(ids, ref.UUID.String())
+	}
+	return ids
+}
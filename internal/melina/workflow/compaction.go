@@ -0,0 +1,123 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"log"
+	llmHandlers "melina-studio-backend/internal/llm_handlers"
+	"melina-studio-backend/internal/melina/agents"
+	"melina-studio-backend/internal/models"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultSummaryTokenThreshold is the unsummarized history size that triggers a compaction
+	defaultSummaryTokenThreshold = 6000
+	// summaryKeepRecent is how many of the latest chats stay verbatim after a compaction (3 exchanges)
+	summaryKeepRecent = 6
+	// summaryTimeout bounds a single summarization call
+	summaryTimeout = 2 * time.Minute
+)
+
+// threads with a compaction running, so concurrent chats don't summarize the same history twice
+var compactingThreads sync.Map
+
+// compactionKey identifies the history of one board thread
+type compactionKey struct {
+	board  uuid.UUID
+	thread uuid.UUID
+}
+
+// summaryTokenThreshold reads CHAT_SUMMARY_TOKEN_THRESHOLD, falling back to the default
+func summaryTokenThreshold() int {
+	if v, err := strconv.Atoi(os.Getenv("CHAT_SUMMARY_TOKEN_THRESHOLD")); err == nil && v > 0 {
+		return v
+	}
+	return defaultSummaryTokenThreshold
+}
+
+// compactChatHistoryAsync runs compactChatHistory in the background
+func (w *Workflow) compactChatHistoryAsync(boardUUID uuid.UUID, threadUUID uuid.UUID, provider string) {
+	go func() {
+		if err := w.compactChatHistory(boardUUID, threadUUID, provider); err != nil {
+			log.Printf("Failed to compact chat history for board %s: %v", boardUUID, err)
+		}
+	}()
+}
+
+// compactChatHistory folds the older unsummarized chats of a board thread into its running
+// summary once they grow past the token threshold; the latest chats are kept verbatim
+func (w *Workflow) compactChatHistory(boardUUID uuid.UUID, threadUUID uuid.UUID, provider string) error {
+	key := compactionKey{board: boardUUID, thread: threadUUID}
+	if _, running := compactingThreads.LoadOrStore(key, struct{}{}); running {
+		return nil
+	}
+	defer compactingThreads.Delete(key)
+
+	summary, err := w.chatRepo.GetChatSummary(boardUUID, threadUUID)
+	if err != nil {
+		return fmt.Errorf("get chat summary: %w", err)
+	}
+	var since time.Time
+	if summary != nil {
+		since = summary.SummarizedUntil
+	}
+
+	chats, err := w.chatRepo.GetChatsSince(boardUUID, threadUUID, since, "role", "content", "created_at")
+	if err != nil {
+		return fmt.Errorf("get chats: %w", err)
+	}
+	if len(chats) <= summaryKeepRecent {
+		return nil
+	}
+
+	tokens := 0
+	for _, chat := range chats {
+		tokens += llmHandlers.EstimateTokens(chat.Content)
+	}
+	if tokens < summaryTokenThreshold() {
+		return nil
+	}
+
+	toSummarize := chats[:len(chats)-summaryKeepRecent]
+
+	summarizer, err := agents.NewSummarizer(provider)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), summaryTimeout)
+	defer cancel()
+
+	previousSummary := ""
+	messageCount := 0
+	if summary != nil {
+		previousSummary = summary.Summary
+		messageCount = summary.MessageCount
+	} else {
+		summary = &models.ChatSummary{BoardUUID: boardUUID, ThreadUUID: threadUUID}
+	}
+
+	text, err := summarizer.Summarize(ctx, previousSummary, toSummarize)
+	if err != nil {
+		return err
+	}
+	if text == "" {
+		return fmt.Errorf("summarizer returned an empty summary")
+	}
+
+	summary.Summary = text
+	summary.SummarizedUntil = toSummarize[len(toSummarize)-1].CreatedAt
+	summary.MessageCount = messageCount + len(toSummarize)
+	if err := w.chatRepo.SaveChatSummary(summary); err != nil {
+		return fmt.Errorf("save chat summary: %w", err)
+	}
+
+	fmt.Printf("[compaction] board %s: summarized %d chats (~%d tokens)\n", boardUUID, len(toSummarize), tokens)
+	return nil
+}
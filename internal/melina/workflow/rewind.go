@@ -0,0 +1,148 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	llmHandlers "melina-studio-backend/internal/llm_handlers"
+	"melina-studio-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// rewindRequest asks to answer an earlier turn again instead of a new message
+type rewindRequest struct {
+	EditMessageId       string // user message to replace with Message
+	RegenerateMessageId string // assistant message to answer again
+	Message             string // new text of the edited user message
+	RevertShapes        bool   // delete the shapes created by the removed turns
+}
+
+func (r rewindRequest) isSet() bool {
+	return r.EditMessageId != "" || r.RegenerateMessageId != ""
+}
+
+// rewound is the turn to answer again after a rewind. Nothing is removed until the
+// new answer is saved, so a failed generation leaves the thread as it was.
+type rewound struct {
+	from           *models.Chat // first chat replaced, every later chat of the thread goes too
+	message        string
+	attachmentIds  []string    // attachments of the replaced user message
+	revertShapeIds []uuid.UUID // shapes created by the replaced turns, deleted when requested
+}
+
+// planRewind validates an edit or regenerate and works out the turn to answer again
+func (w *Workflow) planRewind(boardUUID uuid.UUID, threadUUID uuid.UUID, req rewindRequest) (*rewound, error) {
+	if req.EditMessageId != "" && req.RegenerateMessageId != "" {
+		return nil, errors.New("edit_message_id and regenerate_message_id cannot be used together")
+	}
+
+	var from *models.Chat
+	humanMessage := req.Message
+	if req.EditMessageId != "" {
+		chatId, err := uuid.Parse(req.EditMessageId)
+		if err != nil {
+			return nil, errors.New("invalid edit_message_id")
+		}
+		chat, err := w.chatRepo.GetChat(boardUUID, chatId)
+		if err != nil {
+			return nil, errors.New("message to edit not found")
+		}
+		if chat.Role != models.RoleUser {
+			return nil, errors.New("only user messages can be edited")
+		}
+		if humanMessage == "" {
+			return nil, errors.New("edited message cannot be empty")
+		}
+		from = chat
+	} else {
+		chatId, err := uuid.Parse(req.RegenerateMessageId)
+		if err != nil {
+			return nil, errors.New("invalid regenerate_message_id")
+		}
+		chat, err := w.chatRepo.GetChat(boardUUID, chatId)
+		if err != nil {
+			return nil, errors.New("message to regenerate not found")
+		}
+		if chat.Role != models.RoleAssistant {
+			return nil, errors.New("only assistant messages can be regenerated")
+		}
+		// the turn starts at the user message the assistant answered
+		question, err := w.chatRepo.GetPreviousChat(chat, models.RoleUser)
+		if err != nil {
+			return nil, errors.New("no user message to regenerate from")
+		}
+		from = question
+		humanMessage = question.Content
+	}
+
+	if from.ThreadUUID != threadUUID {
+		return nil, errors.New("message belongs to another thread")
+	}
+
+	result := &rewound{
+		from:          from,
+		message:       humanMessage,
+		attachmentIds: attachmentIdsOf(from),
+	}
+	if req.RevertShapes {
+		toolCalls, err := w.chatRepo.GetToolCallsFrom(from)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the shapes to revert: %w", err)
+		}
+		result.revertShapeIds = createdShapeIds(toolCalls)
+	}
+
+	return result, nil
+}
+
+// chatHistory returns the history sent with a turn; a rewound turn only sees the chats before it
+func (w *Workflow) chatHistory(boardUUID uuid.UUID, threadUUID uuid.UUID, turn *rewound, provider string) ([]llmHandlers.Message, error) {
+	budget := llmHandlers.HistoryTokenBudget(llmHandlers.Provider(provider))
+	if turn == nil {
+		return w.chatRepo.GetChatHistory(boardUUID, threadUUID, budget)
+	}
+	return w.chatRepo.GetChatHistoryBefore(boardUUID, threadUUID, turn.from.CreatedAt, budget)
+}
+
+// saveTurn stores an answered turn. A rewound turn replaces the chats it answers again,
+// and the shapes to revert, in the same transaction. Returns the reverted shape ids.
+func (w *Workflow) saveTurn(boardUUID uuid.UUID, threadUUID uuid.UUID, turn *rewound, humanMessage string, aiMessage string, status models.ChatStatus) (uuid.UUID, uuid.UUID, []string, error) {
+	revertedShapeIds := []string{}
+	if turn == nil {
+		humanMessageId, aiMessageId, err := w.chatRepo.CreateHumanAndAiMessages(boardUUID, threadUUID, humanMessage, aiMessage, status)
+		return humanMessageId, aiMessageId, revertedShapeIds, err
+	}
+
+	humanMessageId, aiMessageId, err := w.chatRepo.ReplaceChatsFrom(turn.from, turn.revertShapeIds, humanMessage, aiMessage, status)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, revertedShapeIds, err
+	}
+	for _, id := range turn.revertShapeIds {
+		revertedShapeIds = append(revertedShapeIds, id.String())
+	}
+	return humanMessageId, aiMessageId, revertedShapeIds, nil
+}
+
+// createdShapeIds returns the ids of the shapes created by tool calls
+func createdShapeIds(toolCalls []models.ChatToolCall) []uuid.UUID {
+	shapeIds := []uuid.UUID{}
+	for _, tc := range toolCalls {
+		if tc.Error != "" || len(tc.Result) == 0 {
+			continue
+		}
+		var result struct {
+			ShapeId  string   `json:"shapeId"`
+			ShapeIds []string `json:"shapeIds"`
+		}
+		if err := json.Unmarshal(tc.Result, &result); err != nil {
+			continue
+		}
+		for _, shapeId := range append(result.ShapeIds, result.ShapeId) {
+			if id, err := uuid.Parse(shapeId); err == nil {
+				shapeIds = append(shapeIds, id)
+			}
+		}
+	}
+	return shapeIds
+}
@@ -0,0 +1,42 @@
+package workflow
+
+import (
+	"fmt"
+	"log"
+
+	"melina-studio-backend/internal/libraries"
+	"melina-studio-backend/internal/melina/layout"
+
+	"github.com/google/uuid"
+)
+
+// ProcessShapePatch saves shapes changed on the client and reroutes the connectors attached to them
+func (w *Workflow) ProcessShapePatch(patch *libraries.ShapePatchPayload) ([]map[string]interface{}, error) {
+	boardId, err := uuid.Parse(patch.BoardId)
+	if err != nil {
+		return nil, fmt.Errorf("invalid board ID")
+	}
+	if len(patch.Shapes) == 0 {
+		return nil, fmt.Errorf("no shapes provided")
+	}
+
+	savedIds := make([]string, 0, len(patch.Shapes))
+	for i := range patch.Shapes {
+		if err := w.boardDataRepo.SaveShapeData(boardId, &patch.Shapes[i]); err != nil {
+			log.Printf("Failed to save shape %s: %v", patch.Shapes[i].ID, err)
+			return nil, fmt.Errorf("failed to save shape %s", patch.Shapes[i].ID)
+		}
+		savedIds = append(savedIds, patch.Shapes[i].ID)
+	}
+
+	connectors, err := layout.ReconnectBoard(w.boardDataRepo, boardId, savedIds)
+	if err != nil {
+		log.Printf("Failed to reroute connectors: %v", err)
+		return nil, fmt.Errorf("failed to reroute connectors")
+	}
+	updates := make([]map[string]interface{}, 0, len(connectors))
+	for _, row := range connectors {
+		updates = append(updates, layout.ShapeMap(row))
+	}
+	return updates, nil
+}
@@ -0,0 +1,231 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"melina-studio-backend/internal/libraries"
+	llmHandlers "melina-studio-backend/internal/llm_handlers"
+	"melina-studio-backend/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// longer strings in tool input summaries are truncated
+	maxSummaryStringLen = 80
+	// longer arrays in tool input summaries are replaced by their length
+	maxSummaryArrayLen = 8
+)
+
+// streamRelay turns provider stream events into websocket messages of the generation
+type streamRelay struct {
+	gen        *libraries.Generation
+	stopReason string // set by the done event
+	trail      *toolTrail
+}
+
+func newStreamRelay(gen *libraries.Generation) *streamRelay {
+	boardUUID, _ := uuid.Parse(gen.BoardId)
+	return &streamRelay{gen: gen, trail: newToolTrail(boardUUID)}
+}
+
+// StopReason returns why the generation ended; empty until the done event arrived
+func (r *streamRelay) StopReason() string {
+	return r.stopReason
+}
+
+// ToolCalls returns the tool calls of the generation, ready to be stored with the assistant chat
+func (r *streamRelay) ToolCalls() []models.ChatToolCall {
+	return r.trail.ToolCalls()
+}
+
+// toolTrail collects the tool calls of a generation from its events, for requests
+// that are not relayed to a websocket as well
+type toolTrail struct {
+	boardUUID uuid.UUID
+	toolCalls []models.ChatToolCall
+}
+
+func newToolTrail(boardUUID uuid.UUID) *toolTrail {
+	return &toolTrail{boardUUID: boardUUID}
+}
+
+// ToolCalls returns the collected tool calls, ready to be stored with the assistant chat
+func (t *toolTrail) ToolCalls() []models.ChatToolCall {
+	return t.toolCalls
+}
+
+// handle is an llmHandlers.EventHandler that only records tool results
+func (t *toolTrail) handle(event llmHandlers.StreamEvent) {
+	if event.Type == llmHandlers.EventToolResult {
+		t.record(event)
+	}
+}
+
+// handle is the llmHandlers.EventHandler of the relay; events arrive sequentially
+func (r *streamRelay) handle(event llmHandlers.StreamEvent) {
+	gen := r.gen
+	switch event.Type {
+	case llmHandlers.EventTextDelta:
+		gen.Send(libraries.WebSocketMessageTypeChatResponse, &libraries.ChatMessageResponsePayload{
+			BoardId: gen.BoardId,
+			Message: event.Text,
+		})
+	case llmHandlers.EventToolStart:
+		gen.Send(libraries.WebSocketMessageTypeToolStarted, &libraries.ToolActivityPayload{
+			BoardId:    gen.BoardId,
+			ToolCallId: event.ToolCallID,
+			ToolName:   event.ToolName,
+			Input:      summarizeToolInput(event.ToolInput),
+			Index:      event.ToolIndex,
+			Total:      event.ToolTotal,
+		})
+	case llmHandlers.EventToolResult:
+		relayToolResult(gen, event)
+		relayShapeResult(gen, event.Result)
+		r.trail.record(event)
+	case llmHandlers.EventIterationEnd:
+		gen.Send(libraries.WebSocketMessageTypeIteration, &libraries.IterationPayload{
+			BoardId:    gen.BoardId,
+			Iteration:  event.Iteration,
+			StopReason: event.StopReason,
+		})
+	case llmHandlers.EventDone:
+		r.stopReason = event.StopReason
+	}
+}
+
+// record keeps a tool result for the chat history
+func (t *toolTrail) record(event llmHandlers.StreamEvent) {
+	if event.Result == nil {
+		return
+	}
+
+	toolCall := models.ChatToolCall{
+		BoardUUID:  t.boardUUID,
+		ToolCallID: event.ToolCallID,
+		ToolName:   event.ToolName,
+		Position:   len(t.toolCalls) + 1,
+		DurationMs: event.Result.Duration.Milliseconds(),
+		CreatedAt:  time.Now(),
+	}
+	if input, err := json.Marshal(event.ToolInput); err == nil {
+		toolCall.Input = input
+	}
+	if event.Result.Error != nil {
+		toolCall.Error = event.Result.Error.Error()
+	} else if !event.Result.HasImage {
+		// board snapshots are not worth keeping, and created shapes are already on the board
+		if result, err := json.Marshal(llmHandlers.ModelResult(event.Result.Result)); err == nil {
+			toolCall.Result = result
+		}
+	}
+	t.toolCalls = append(t.toolCalls, toolCall)
+}
+
+// relayToolResult sends tool_finished or tool_failed for a tool result
+func relayToolResult(gen *libraries.Generation, event llmHandlers.StreamEvent) {
+	if event.Result == nil {
+		return
+	}
+	payload := &libraries.ToolActivityPayload{
+		BoardId:    gen.BoardId,
+		ToolCallId: event.ToolCallID,
+		ToolName:   event.ToolName,
+		Index:      event.ToolIndex,
+		Total:      event.ToolTotal,
+		DurationMs: event.Result.Duration.Milliseconds(),
+	}
+	if event.Result.Error != nil {
+		payload.Error = event.Result.Error.Error()
+		gen.Send(libraries.WebSocketMessageTypeToolFailed, payload)
+		return
+	}
+	gen.Send(libraries.WebSocketMessageTypeToolFinished, payload)
+}
+
+// relayShapeResult sends shape_created for tool results that carry new shapes,
+// either one in "shape" or several in "shapes", shape_updated for moved shapes
+// and comment_* messages for comment changes
+func relayShapeResult(gen *libraries.Generation, result *llmHandlers.ToolExecutionResult) {
+	if result == nil || result.Error != nil {
+		return
+	}
+	resultMap, ok := result.Result.(map[string]interface{})
+	if !ok {
+		return
+	}
+	boardId, _ := resultMap["boardId"].(string)
+	if boardId == "" {
+		boardId = gen.BoardId
+	}
+
+	// comments answered or resolved, e.g. from resolveComment
+	if events, ok := resultMap["_commentEvents"].([]map[string]interface{}); ok {
+		for _, event := range events {
+			eventType, _ := event["type"].(string)
+			libraries.SendCommentEvent(gen, libraries.WebSocketMessageType(eventType), boardId, event["comment"])
+		}
+		return
+	}
+
+	// moved shapes, e.g. from arrangeShapes
+	if isUpdate, _ := resultMap["_shapeUpdates"].(bool); isUpdate {
+		if shapes, ok := resultMap["shapes"].([]map[string]interface{}); ok {
+			for _, shape := range shapes {
+				libraries.SendShapeUpdatedMessage(gen, boardId, shape)
+			}
+		}
+		return
+	}
+
+	if isShape, _ := resultMap["_shapeContent"].(bool); !isShape {
+		return
+	}
+	if shape, ok := resultMap["shape"].(map[string]interface{}); ok {
+		libraries.SendShapeCreatedMessage(gen, boardId, shape)
+	}
+	if shapes, ok := resultMap["shapes"].([]map[string]interface{}); ok {
+		for _, shape := range shapes {
+			libraries.SendShapeCreatedMessage(gen, boardId, shape)
+		}
+	}
+}
+
+// summarizeToolInput returns a copy of the tool input that is small enough to show in
+// the activity log: long strings are truncated and long arrays (e.g. points) are
+// replaced by their length
+func summarizeToolInput(input map[string]interface{}) map[string]interface{} {
+	if len(input) == 0 {
+		return nil
+	}
+	summary := make(map[string]interface{}, len(input))
+	for key, value := range input {
+		summary[key] = summarizeValue(value)
+	}
+	return summary
+}
+
+func summarizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if runes := []rune(v); len(runes) > maxSummaryStringLen {
+			return string(runes[:maxSummaryStringLen]) + "..."
+		}
+		return v
+	case []interface{}:
+		if len(v) > maxSummaryArrayLen {
+			return fmt.Sprintf("[%d items]", len(v))
+		}
+		items := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			items = append(items, summarizeValue(item))
+		}
+		return items
+	case map[string]interface{}:
+		return summarizeToolInput(v)
+	default:
+		return v
+	}
+}
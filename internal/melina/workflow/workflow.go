@@ -2,11 +2,17 @@ package workflow
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"melina-studio-backend/internal/libraries"
+	llmHandlers "melina-studio-backend/internal/llm_handlers"
 	"melina-studio-backend/internal/melina/agents"
+	"melina-studio-backend/internal/models"
 	"melina-studio-backend/internal/repo"
+	"os"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
@@ -14,11 +20,90 @@ import (
 
 
 type Workflow struct {
-	chatRepo repo.ChatRepoInterface
+	chatRepo       repo.ChatRepoInterface
+	boardRepo      repo.BoardRepoInterface
+	threadRepo     repo.ChatThreadRepoInterface
+	boardDataRepo  repo.BoardDataRepoInterface
+	attachmentRepo repo.ChatAttachmentRepoInterface
+	blobStore      libraries.BlobStore
 }
 
-func NewWorkflow(chatRepo repo.ChatRepoInterface) *Workflow {
-	return &Workflow{chatRepo: chatRepo}
+func NewWorkflow(chatRepo repo.ChatRepoInterface, boardRepo repo.BoardRepoInterface, threadRepo repo.ChatThreadRepoInterface, boardDataRepo repo.BoardDataRepoInterface, attachmentRepo repo.ChatAttachmentRepoInterface, blobStore libraries.BlobStore) *Workflow {
+	return &Workflow{
+		chatRepo:       chatRepo,
+		boardRepo:      boardRepo,
+		threadRepo:     threadRepo,
+		boardDataRepo:  boardDataRepo,
+		attachmentRepo: attachmentRepo,
+		blobStore:      blobStore,
+	}
+}
+
+// resolveThread returns the thread a message is posted to; it must belong to the board
+// and not be archived. An empty id is the board's default conversation.
+func (w *Workflow) resolveThread(boardUUID uuid.UUID, threadId string) (uuid.UUID, error) {
+	threadUUID, err := repo.ParseThreadID(threadId)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid thread ID: %w", err)
+	}
+	if threadUUID == models.DefaultThreadUUID {
+		return threadUUID, nil
+	}
+
+	thread, err := w.threadRepo.GetThread(boardUUID, threadUUID)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("thread not found: %w", err)
+	}
+	if thread.Archived {
+		return uuid.Nil, errors.New("thread is archived")
+	}
+	return threadUUID, nil
+}
+
+// generationParams layers the board's generation settings and the request overrides
+func (w *Workflow) generationParams(boardUUID uuid.UUID, requestParams *models.GenerationParams) models.GenerationParams {
+	var boardParams models.GenerationParams
+	board, err := w.boardRepo.GetBoardByID(boardUUID)
+	if err != nil {
+		log.Printf("Failed to load board generation settings: %v", err)
+	} else if len(board.GenerationSettings) > 0 {
+		if err := json.Unmarshal(board.GenerationSettings, &boardParams); err != nil {
+			log.Printf("Failed to decode board generation settings: %v", err)
+		}
+	}
+
+	overrides := []models.GenerationParams{boardParams}
+	if requestParams != nil {
+		overrides = append(overrides, *requestParams)
+	}
+	return llmHandlers.ResolveGenerationParams(overrides...)
+}
+
+// threadIdOf returns the thread id sent to clients; empty for the default thread
+func threadIdOf(threadUUID uuid.UUID) string {
+	if threadUUID == models.DefaultThreadUUID {
+		return ""
+	}
+	return threadUUID.String()
+}
+
+// llmProvider returns the provider configured in LLM_PROVIDER, or fallback if it's not set
+func llmProvider(fallback string) string {
+	if provider := os.Getenv("LLM_PROVIDER"); provider != "" {
+		return provider
+	}
+	return fallback
+}
+
+// requestProvider returns the provider asked for by a request, or the configured one
+func requestProvider(requested string, fallback string) (string, error) {
+	if requested == "" {
+		return llmProvider(fallback), nil
+	}
+	if err := agents.ValidateProvider(requested); err != nil {
+		return "", err
+	}
+	return requested, nil
 }
 
 func (w *Workflow) TriggerChatWorkflow(c *fiber.Ctx) error {
@@ -32,7 +117,18 @@ func (w *Workflow) TriggerChatWorkflow(c *fiber.Ctx) error {
 		})
 	}
 	var dto struct {
-		Message string `json:"message"`
+		Message  string                   `json:"message"`
+		ThreadId string                   `json:"thread_id"` // optional: the board's default conversation if empty
+		Params   *models.GenerationParams `json:"params"`    // optional overrides
+		Provider string                   `json:"provider"`  // optional: answer with another provider
+
+		// optional: uploaded images to send with the message
+		AttachmentIds []string `json:"attachment_ids"`
+
+		// optional: answer an earlier turn again, see rewindRequest
+		EditMessageId       string `json:"edit_message_id"`
+		RegenerateMessageId string `json:"regenerate_message_id"`
+		RevertShapes        bool   `json:"revert_shapes"`
 	}
 
 	if err := c.BodyParser(&dto); err != nil {
@@ -41,20 +137,73 @@ func (w *Workflow) TriggerChatWorkflow(c *fiber.Ctx) error {
 		})
 	}
 
-	if dto.Message == "" {
+	rewind := rewindRequest{
+		EditMessageId:       dto.EditMessageId,
+		RegenerateMessageId: dto.RegenerateMessageId,
+		Message:             dto.Message,
+		RevertShapes:        dto.RevertShapes,
+	}
+	if dto.Message == "" && !rewind.isSet() {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": fmt.Sprintf("Message cannot be empty: %v", err),
 		})
 	}
 
-	// Default to gemini if not specified
-	LLM := "groq"
+	threadUUID, err := w.resolveThread(boardUUID, dto.ThreadId)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Default to groq if LLM_PROVIDER is not set
+	LLM, err := requestProvider(dto.Provider, "groq")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	attachments, images, err := w.loadAttachments(c.Context(), boardUUID, dto.AttachmentIds)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// an edit or regenerate replaces the turn and everything after it once answered
+	humanMessage := dto.Message
+	var turn *rewound
+	if rewind.isSet() {
+		turn, err = w.planRewind(boardUUID, threadUUID, rewind)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		humanMessage = turn.message
+		// the replaced message keeps its images unless new ones were sent
+		if len(dto.AttachmentIds) == 0 {
+			attachments, images, err = w.loadAttachments(c.Context(), boardUUID, turn.attachmentIds)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+		}
+	}
 
 	// Create agent on-demand with specified LLM provider
-	agent := agents.NewAgent(LLM)
+	agent, err := agents.NewAgent(LLM, w.generationParams(boardUUID, dto.Params))
+	if err != nil {
+		log.Printf("Error creating agent: %v", err)
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": fmt.Sprintf("Provider %s is not available", LLM),
+		})
+	}
 
-	// get chat history from the database
-	chatHistory, err := w.chatRepo.GetChatHistory(boardUUID, 20)
+	// get as much recent chat history as the provider's budget allows
+	chatHistory, err := w.chatHistory(boardUUID, threadUUID, turn, LLM)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": fmt.Sprintf("Failed to get chat history: %v", err),
@@ -62,8 +211,10 @@ func (w *Workflow) TriggerChatWorkflow(c *fiber.Ctx) error {
 	}
 
 
-	// Call the agent to process the message with boardId (for image context)
-	aiResponse, err := agent.ProcessRequest(c.Context(), dto.Message , chatHistory, boardId)
+	// Call the agent to process the message with boardId (for image context); the
+	// events are only used to collect the tool calls, nothing is streamed to the caller
+	trail := newToolTrail(boardUUID)
+	aiResponse, err := agent.ProcessRequestStream(c.Context(), trail.handle, humanMessage, images, chatHistory, boardId)
 	if err != nil {
 		log.Printf("Error processing request: %v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -72,92 +223,243 @@ func (w *Workflow) TriggerChatWorkflow(c *fiber.Ctx) error {
 	}
 
 	// after get successful response, create a chat in the database
-	human_message_id , ai_message_id , err := w.chatRepo.CreateHumanAndAiMessages(boardUUID, dto.Message, aiResponse)
+	human_message_id , ai_message_id , revertedShapeIds, err := w.saveTurn(boardUUID, threadUUID, turn, humanMessage, aiResponse, models.ChatStatusCompleted)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": fmt.Sprintf("Failed to create human and ai messages: %v", err),
 		})
 	}
+	w.linkAttachments(human_message_id, attachments)
+	w.saveToolCalls(ai_message_id, trail.ToolCalls())
+
+	// summarize older history in the background once it grows too long
+	w.compactChatHistoryAsync(boardUUID, threadUUID, LLM)
 
 	return c.JSON(fiber.Map{
 		"message": aiResponse,
 		"human_message_id": human_message_id.String(),
 		"ai_message_id": ai_message_id.String(),
+		"reverted_shape_ids": revertedShapeIds,
 	})
 }
 
-func (w *Workflow) ProcessChatMessage(hub *libraries.Hub, client *libraries.Client, boardId string, message *libraries.ChatMessagePayload) {
+func (w *Workflow) ProcessChatMessage(gen *libraries.Generation, message *libraries.ChatMessagePayload) {
+	// mark the generation as done once we're finished, whatever the outcome
+	defer gen.Finish()
+
+	boardId := gen.BoardId
+	ctx := gen.Context()
+
 	// get chat history from the database
 	boardIdUUID, err := uuid.Parse(boardId)
 	if err != nil {
-		libraries.SendErrorMessage(hub, client, "Invalid board ID")
+		gen.SendError("Invalid board ID")
 		return
 	}
 
-	// get chat history from the database
-	chatHistory, err := w.chatRepo.GetChatHistory(boardIdUUID, 20)
+	threadUUID, err := w.resolveThread(boardIdUUID, message.ThreadId)
+	if err != nil {
+		gen.SendError(fmt.Sprintf("Invalid thread: %v", err))
+		return
+	}
+	threadId := threadIdOf(threadUUID)
+
+	LLM, err := requestProvider(message.Provider, "vertex_anthropic")
 	if err != nil {
-		libraries.SendErrorMessage(hub, client, "Failed to get chat history")
+		gen.SendError(err.Error())
+		return
+	}
+
+	// an edit or regenerate replaces the turn and everything after it
+	humanMessage := message.Message
+	rewind := rewindRequest{
+		EditMessageId:       message.EditMessageId,
+		RegenerateMessageId: message.RegenerateMessageId,
+		Message:             message.Message,
+		RevertShapes:        message.RevertShapes,
+	}
+	attachments, images, err := w.loadAttachments(ctx, boardIdUUID, message.AttachmentIds)
+	if err != nil {
+		gen.SendError(err.Error())
+		return
+	}
+	var turn *rewound
+	if rewind.isSet() {
+		turn, err = w.planRewind(boardIdUUID, threadUUID, rewind)
+		if err != nil {
+			gen.SendError(err.Error())
+			return
+		}
+		humanMessage = turn.message
+		// the replaced message keeps its images unless new ones were sent
+		if len(message.AttachmentIds) == 0 {
+			attachments, images, err = w.loadAttachments(ctx, boardIdUUID, turn.attachmentIds)
+			if err != nil {
+				gen.SendError(err.Error())
+				return
+			}
+		}
+	}
+
+	// get as much recent chat history as the provider's budget allows
+	chatHistory, err := w.chatHistory(boardIdUUID, threadUUID, turn, LLM)
+	if err != nil {
+		gen.SendError("Failed to get chat history")
 		return
 	}
 
 	// create an agent
-	LLM := "vertex_anthropic"
-	agent := agents.NewAgent(LLM)
+	agent, err := agents.NewAgent(LLM, w.generationParams(boardIdUUID, message.Params))
+	if err != nil {
+		log.Printf("Error creating agent: %v", err)
+		gen.SendError(fmt.Sprintf("Provider %s is not available", LLM))
+		return
+	}
 
 
-	// send an event that the chat is starting
-	libraries.SendEventType(hub , client, libraries.WebSocketMessageTypeChatStarting)
+	// send an event that the chat is starting - includes the generation id so the client can cancel or resume it
+	gen.Send(libraries.WebSocketMessageTypeChatStarting, &libraries.ChatMessageResponsePayload{
+		BoardId:      boardId,
+		ThreadId:     threadId,
+		GenerationId: gen.ID,
+		ResumeToken:  gen.ResumeToken,
+	})
 
 	fmt.Println("Processing chat message...")
-	// process the chat message - pass client and boardId for streaming
-	aiResponse, err := agent.ProcessRequestStream(context.Background(), hub, client, message.Message, chatHistory, boardId)
+	// process the chat message - stream events are relayed to the client through the generation
+	relay := newStreamRelay(gen)
+	aiResponse, err := agent.ProcessRequestStream(ctx, relay.handle, humanMessage, images, chatHistory, boardId)
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		w.handleCancelledGeneration(gen, boardIdUUID, threadUUID, turn, humanMessage, attachments, aiResponse, relay.ToolCalls())
+		return
+	}
 	if err != nil {
 		// Log the error for debugging but still try to send a helpful message
 		log.Printf("Error processing chat message: %v", err)
 		
 		// Send a more informative error message
 		errorMsg := fmt.Sprintf("I encountered an issue while processing your request: %v. Some shapes may have been created successfully. Please check the canvas.", err)
-		libraries.SendChatMessageResponse(hub, client, libraries.WebSocketMessageTypeChatResponse, &libraries.ChatMessageResponsePayload{
+		gen.Send(libraries.WebSocketMessageTypeChatResponse, &libraries.ChatMessageResponsePayload{
 			BoardId: boardId,
 			Message: errorMsg,
 		})
 		
-		// Still try to save what we have (even if partial)
-		if aiResponse != "" {
-			_, _, saveErr := w.chatRepo.CreateHumanAndAiMessages(boardIdUUID, message.Message, aiResponse)
+		// Still try to save what we have (even if partial), and the trail of the tools that ran
+		toolCalls := relay.ToolCalls()
+		if aiResponse != "" || len(toolCalls) > 0 {
+			aiContent := aiResponse
+			if strings.TrimSpace(aiContent) == "" {
+				aiContent = failedPlaceholder
+			}
+			human_message_id, ai_message_id, revertedShapeIds, saveErr := w.saveTurn(boardIdUUID, threadUUID, turn, humanMessage, aiContent, models.ChatStatusCompleted)
 			if saveErr != nil {
 				log.Printf("Failed to save chat messages: %v", saveErr)
+			} else {
+				sendShapesReverted(gen, revertedShapeIds)
+				w.linkAttachments(human_message_id, attachments)
+				w.saveToolCalls(ai_message_id, toolCalls)
 			}
 		}
 		
 		// Send completion event even on error
-		libraries.SendChatMessageResponse(hub, client, libraries.WebSocketMessageTypeChatCompleted, &libraries.ChatMessageResponsePayload{
-			BoardId: boardId,
-			Message: aiResponse,
+		gen.Send(libraries.WebSocketMessageTypeChatCompleted, &libraries.ChatMessageResponsePayload{
+			BoardId:      boardId,
+			ThreadId:     threadId,
+			GenerationId: gen.ID,
+			Message:      aiResponse,
 		})
 		return
 	}
 
 	fmt.Println("Chat message processed successfully")
 	// after get successful response, create a chat in the database
-	human_message_id , ai_message_id , err := w.chatRepo.CreateHumanAndAiMessages(boardIdUUID, message.Message, aiResponse)
+	human_message_id , ai_message_id , revertedShapeIds, err := w.saveTurn(boardIdUUID, threadUUID, turn, humanMessage, aiResponse, models.ChatStatusCompleted)
 	if err != nil {
-		libraries.SendErrorMessage(hub, client, "Failed to create human and ai messages")
+		gen.SendError("Failed to create human and ai messages")
 		return
 	}
+	sendShapesReverted(gen, revertedShapeIds)
+	w.linkAttachments(human_message_id, attachments)
+	w.saveToolCalls(ai_message_id, relay.ToolCalls())
+
+	// summarize older history in the background once it grows too long
+	w.compactChatHistoryAsync(boardIdUUID, threadUUID, LLM)
 
 	fmt.Println("AI response:", aiResponse)
 	fmt.Println("Human message id:", human_message_id.String())
 	fmt.Println("AI message id:", ai_message_id.String())
 	fmt.Println("Sending chat message response...")
 	// send an event that the chat is completed
-	libraries.SendChatMessageResponse(hub , client, libraries.WebSocketMessageTypeChatCompleted, &libraries.ChatMessageResponsePayload{
+	gen.Send(libraries.WebSocketMessageTypeChatCompleted, &libraries.ChatMessageResponsePayload{
 		BoardId: boardId,
+		ThreadId: threadId,
+		GenerationId: gen.ID,
 		Message: aiResponse,
 		HumanMessageId: human_message_id.String(),
 		AiMessageId: ai_message_id.String(),
+		StopReason: relay.StopReason(),
 	})
 
 	fmt.Println("Chat message completed")
-}
\ No newline at end of file
+}
+
+// stored as the answer of a generation cancelled before it produced any text
+const cancelledPlaceholder = "[cancelled]"
+
+// stored as the answer of a generation that failed before producing text, after running tools
+const failedPlaceholder = "[failed]"
+
+// handleCancelledGeneration persists the partial answer of a cancelled generation and notifies the client
+func (w *Workflow) handleCancelledGeneration(gen *libraries.Generation, boardUUID uuid.UUID, threadUUID uuid.UUID, turn *rewound, humanMessage string, attachments []models.ChatAttachment, partialResponse string, toolCalls []models.ChatToolCall) {
+	fmt.Println("Chat generation cancelled:", gen.ID)
+
+	payload := &libraries.ChatMessageResponsePayload{
+		BoardId:      gen.BoardId,
+		ThreadId:     threadIdOf(threadUUID),
+		GenerationId: gen.ID,
+		Message:      partialResponse,
+	}
+
+	// an edit or regenerate cancelled before it did anything keeps the turn it would have replaced
+	if turn != nil && strings.TrimSpace(partialResponse) == "" && len(toolCalls) == 0 {
+		gen.Send(libraries.WebSocketMessageTypeChatCancelled, payload)
+		return
+	}
+
+	// cancelled before the first token - store a placeholder instead of an empty answer
+	aiContent := partialResponse
+	if strings.TrimSpace(aiContent) == "" {
+		aiContent = cancelledPlaceholder
+	}
+
+	human_message_id, ai_message_id, revertedShapeIds, err := w.saveTurn(boardUUID, threadUUID, turn, humanMessage, aiContent, models.ChatStatusCancelled)
+	if err != nil {
+		log.Printf("Failed to save cancelled chat messages: %v", err)
+	} else {
+		sendShapesReverted(gen, revertedShapeIds)
+		payload.HumanMessageId = human_message_id.String()
+		payload.AiMessageId = ai_message_id.String()
+		w.linkAttachments(human_message_id, attachments)
+		w.saveToolCalls(ai_message_id, toolCalls)
+	}
+
+	gen.Send(libraries.WebSocketMessageTypeChatCancelled, payload)
+}
+
+// sendShapesReverted tells the client which shapes a saved rewind deleted
+func sendShapesReverted(gen *libraries.Generation, shapeIds []string) {
+	if len(shapeIds) == 0 {
+		return
+	}
+	gen.Send(libraries.WebSocketMessageTypeShapesReverted, &libraries.ShapesRevertedPayload{
+		BoardId:  gen.BoardId,
+		ShapeIds: shapeIds,
+	})
+}
+
+// saveToolCalls stores the tool calls behind an assistant chat; failures are only logged
+func (w *Workflow) saveToolCalls(aiMessageId uuid.UUID, toolCalls []models.ChatToolCall) {
+	if err := w.chatRepo.CreateToolCalls(aiMessageId, toolCalls); err != nil {
+		log.Printf("Failed to save tool calls: %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/datatypes"
 )
 
 // Board represents the database model
@@ -14,4 +15,6 @@ type Board struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Thumbnail string    `json:"thumbnail"`
+	// GenerationSettings holds the board's GenerationParams overrides
+	GenerationSettings datatypes.JSON `json:"generation_settings,omitempty"`
 }
@@ -23,9 +23,16 @@ const (
 
 type BoardData struct {
 	UUID      uuid.UUID      `gorm:"primarykey" json:"uuid"`
-	BoardId   uuid.UUID      `gorm:"not null" json:"board_id"`
+	BoardId   uuid.UUID      `gorm:"not null;index" json:"board_id"`
 	Type      Type           `gorm:"default:'rect'" json:"type"`
 	Data      datatypes.JSON `json:"data"`
+	// bounding box computed from Data on save, nil when the shape has no geometry
+	MinX      *float64       `json:"min_x,omitempty"`
+	MinY      *float64       `json:"min_y,omitempty"`
+	MaxX      *float64       `json:"max_x,omitempty"`
+	MaxY      *float64       `json:"max_y,omitempty"`
+	// drawing order, shapes with a higher z index are drawn on top
+	ZIndex    int            `gorm:"not null;default:0" json:"z_index"`
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 }
@@ -41,8 +48,16 @@ type Shape struct {
 	Stroke      *string    `json:"stroke,omitempty"`
 	Fill        *string    `json:"fill,omitempty"`
 	StrokeWidth *float64   `json:"strokeWidth,omitempty"`
-	Points      *[]float64 `json:"points,omitempty"`
+	Points      *Points    `json:"points,omitempty"` // an array or a string from EncodePoints
 	Text        *string    `json:"text,omitempty"`
 	FontSize    *float64   `json:"fontSize,omitempty"`
 	FontFamily  *string    `json:"fontFamily,omitempty"`
+	ZIndex      *int       `json:"zIndex,omitempty"` // keeps the stored order when not set
+
+	// connectors: a line or arrow attached to shapes; its points follow them
+	FromShapeId *string `json:"fromShapeId,omitempty"`
+	ToShapeId   *string `json:"toShapeId,omitempty"`
+	FromAnchor  *string `json:"fromAnchor,omitempty"` // top, right, bottom, left or auto
+	ToAnchor    *string `json:"toAnchor,omitempty"`
+	Label       *string `json:"label,omitempty"`
 }
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+// BoardTemplate is a starting layout (retro board, kanban columns, wireframe
+// frames...) that boards can be created from or that can be inserted into a board
+type BoardTemplate struct {
+	UUID        uuid.UUID `gorm:"type:uuid;primaryKey;" json:"uuid"`
+	Title       string    `gorm:"not null" json:"title"`
+	Description string    `json:"description"`
+	// OwnerID is the user or team that saved the template
+	OwnerID   uuid.UUID `gorm:"type:uuid;not null;index" json:"owner_id"`
+	Thumbnail string    `json:"thumbnail"`
+	// Shapes holds []TemplateShape positioned relative to the template's top left corner
+	Shapes datatypes.JSON `json:"shapes,omitempty"`
+	Width  float64        `json:"width"`
+	Height float64        `json:"height"`
+	// BuiltIn templates ship with the server and are not stored
+	BuiltIn   bool      `gorm:"-" json:"built_in"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TemplateShape is a shape of a template, stored like BoardData
+type TemplateShape struct {
+	ID     string         `json:"id"`
+	Type   Type           `json:"type"`
+	Data   datatypes.JSON `json:"data"`
+	ZIndex int            `json:"z_index"`
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/datatypes"
 )
 
 type Role string
@@ -13,11 +14,21 @@ const (
 	RoleAssistant Role = "assistant"
 )
 
+type ChatStatus string
+
+const (
+	ChatStatusCompleted ChatStatus = "completed"
+	ChatStatusCancelled ChatStatus = "cancelled" // generation was stopped, content is partial
+)
+
 type Chat struct {
 	UUID      uuid.UUID `gorm:"type:uuid;primaryKey;" json:"uuid"`
 	BoardUUID   uuid.UUID `gorm:"not null" json:"board_uuid"`
+	ThreadUUID uuid.UUID `gorm:"type:uuid;not null;default:'00000000-0000-0000-0000-000000000000';index" json:"thread_uuid"` // DefaultThreadUUID unless the chat belongs to a ChatThread
 	Content   string    `gorm:"not null" json:"content"`
 	Role      Role      `gorm:"not null" json:"role"`
+	Status    ChatStatus `gorm:"not null;default:'completed'" json:"status"`
+	Attachments datatypes.JSON `json:"attachments,omitempty"` // []AttachmentRef of the images sent with the message
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ChatAttachment is an image uploaded for a chat message. It is stored in the blob
+// store under StorageKey; ChatUUID is set once the message using it is saved.
+type ChatAttachment struct {
+	UUID       uuid.UUID  `gorm:"type:uuid;primaryKey;" json:"uuid"`
+	BoardUUID  uuid.UUID  `gorm:"type:uuid;not null;index" json:"board_uuid"`
+	ChatUUID   *uuid.UUID `gorm:"type:uuid;index" json:"chat_uuid,omitempty"`
+	StorageKey string     `gorm:"not null" json:"-"`
+	MediaType  string     `gorm:"not null" json:"media_type"`
+	Filename   string     `json:"filename"`
+	SizeBytes  int64      `gorm:"not null" json:"size_bytes"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// AttachmentRef is how a Chat row references its attachments
+type AttachmentRef struct {
+	UUID      uuid.UUID `json:"uuid"`
+	MediaType string    `json:"media_type"`
+	Filename  string    `json:"filename,omitempty"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ChatSummary is the running summary of the older chat history of a board thread.
+// Chats created up to SummarizedUntil are represented by Summary instead of being resent.
+type ChatSummary struct {
+	UUID            uuid.UUID `gorm:"type:uuid;primaryKey;" json:"uuid"`
+	BoardUUID       uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_chat_summary_thread" json:"board_uuid"`
+	ThreadUUID      uuid.UUID `gorm:"type:uuid;not null;default:'00000000-0000-0000-0000-000000000000';uniqueIndex:idx_chat_summary_thread" json:"thread_uuid"`
+	Summary         string    `gorm:"not null" json:"summary"`
+	SummarizedUntil time.Time `gorm:"not null" json:"summarized_until"`
+	MessageCount    int       `gorm:"not null;default:0" json:"message_count"` // chats folded into the summary so far
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
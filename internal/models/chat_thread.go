@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultThreadUUID is the thread of chats sent without a thread id, i.e. the
+// board's original implicit conversation
+var DefaultThreadUUID = uuid.Nil
+
+// ChatThread is a separate conversation on a board with its own history
+type ChatThread struct {
+	UUID      uuid.UUID `gorm:"type:uuid;primaryKey;" json:"uuid"`
+	BoardUUID uuid.UUID `gorm:"type:uuid;not null;index" json:"board_uuid"`
+	Title     string    `gorm:"not null" json:"title"`
+	Archived  bool      `gorm:"not null;default:false" json:"archived"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
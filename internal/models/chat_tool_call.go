@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+// ChatToolCall is one tool call the assistant made while producing a Chat reply
+type ChatToolCall struct {
+	UUID       uuid.UUID      `gorm:"type:uuid;primaryKey;" json:"uuid"`
+	ChatUUID   uuid.UUID      `gorm:"type:uuid;not null;index" json:"chat_uuid"` // the assistant Chat row
+	BoardUUID  uuid.UUID      `gorm:"type:uuid;not null;index" json:"board_uuid"`
+	ToolCallID string         `json:"tool_call_id"` // provider id of the call
+	ToolName   string         `gorm:"not null" json:"tool_name"`
+	Input      datatypes.JSON `json:"input"`
+	Result     datatypes.JSON `json:"result,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Position   int            `gorm:"not null" json:"position"` // order within the reply
+	DurationMs int64          `json:"duration_ms"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
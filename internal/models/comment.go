@@ -0,0 +1,57 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+// MelinaAuthorName is the author of comments written by the agent, whose author id is uuid.Nil
+const MelinaAuthorName = "Melina"
+
+// Comment is feedback left on a board, pinned to a shape or to a canvas position.
+// Replies point to the first comment of their thread, which holds the anchor
+// and the resolved state of the whole thread.
+type Comment struct {
+	UUID       uuid.UUID  `gorm:"type:uuid;primaryKey;" json:"uuid"`
+	BoardUUID  uuid.UUID  `gorm:"type:uuid;not null;index" json:"board_uuid"`
+	ParentUUID *uuid.UUID `gorm:"type:uuid;index" json:"parent_uuid,omitempty"`
+
+	// anchor of a thread: a shape id, or x, y on the canvas
+	ShapeId *string  `json:"shape_id,omitempty"`
+	X       *float64 `json:"x,omitempty"`
+	Y       *float64 `json:"y,omitempty"`
+
+	AuthorID   uuid.UUID      `gorm:"type:uuid;not null" json:"author_id"`
+	AuthorName string         `json:"author_name"`
+	Body       string         `gorm:"not null" json:"body"`
+	Mentions   datatypes.JSON `json:"mentions"` // names mentioned with @ in the body
+
+	Resolved   bool       `gorm:"not null;default:false" json:"resolved"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	ResolvedBy *uuid.UUID `gorm:"type:uuid" json:"resolved_by,omitempty"`
+
+	Replies   []Comment `gorm:"-" json:"replies,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+var mentionRe = regexp.MustCompile(`(?:^|\s)@([A-Za-z0-9_.\-]+)`)
+
+// ParseMentions returns the distinct @names in a comment body
+func ParseMentions(body string) []string {
+	mentions := []string{}
+	seen := map[string]bool{}
+	for _, m := range mentionRe.FindAllStringSubmatch(body, -1) {
+		// "thanks @ana." mentions ana
+		name := strings.TrimRight(m[1], ".-")
+		if name != "" && !seen[name] {
+			seen[name] = true
+			mentions = append(mentions, name)
+		}
+	}
+	return mentions
+}
@@ -0,0 +1,11 @@
+package models
+
+// GenerationParams tunes how the model generates a response.
+// Zero values mean "not set" so params can be layered: defaults, board settings, request overrides.
+type GenerationParams struct {
+	MaxTokens     int      `json:"max_tokens,omitempty"`
+	Temperature   *float64 `json:"temperature,omitempty"`
+	TopP          *float64 `json:"top_p,omitempty"`
+	StopSequences []string `json:"stop_sequences,omitempty"`
+	MaxIterations int      `json:"max_iterations,omitempty"` // passes of the tool loop
+}
@@ -0,0 +1,125 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// PointEncoding is a compact string form of a points array a client can ask for
+type PointEncoding string
+
+const (
+	// zigzag varint deltas of the coordinates in tenths of a pixel, base64
+	PointEncodingDeltaVarint PointEncoding = "delta-varint"
+	// little endian float32 coordinates, base64
+	PointEncodingFloat32 PointEncoding = "float32"
+)
+
+// encoded points are prefixed so they can be decoded without negotiation
+var pointEncodingPrefixes = map[PointEncoding]string{
+	PointEncodingDeltaVarint: "dv:",
+	PointEncodingFloat32:     "f32:",
+}
+
+// ParsePointEncoding picks the first supported encoding of a comma separated list
+func ParsePointEncoding(accepted string) (PointEncoding, bool) {
+	for _, name := range strings.Split(accepted, ",") {
+		enc := PointEncoding(strings.TrimSpace(name))
+		if _, ok := pointEncodingPrefixes[enc]; ok {
+			return enc, true
+		}
+	}
+	return "", false
+}
+
+// EncodePoints encodes a points array
+func EncodePoints(points []float64, enc PointEncoding) (string, error) {
+	var buf []byte
+	switch enc {
+	case PointEncodingDeltaVarint:
+		buf = make([]byte, 0, len(points)*2)
+		// x and y are delta encoded separately
+		var prev [2]int64
+		for i, v := range points {
+			q := int64(math.Round(v * 10))
+			buf = binary.AppendVarint(buf, q-prev[i%2])
+			prev[i%2] = q
+		}
+	case PointEncodingFloat32:
+		buf = make([]byte, 0, len(points)*4)
+		for _, v := range points {
+			buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(v)))
+		}
+	default:
+		return "", fmt.Errorf("unsupported point encoding %q", enc)
+	}
+	return pointEncodingPrefixes[enc] + base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// DecodePoints decodes a string made by EncodePoints
+func DecodePoints(s string) ([]float64, error) {
+	for enc, prefix := range pointEncodingPrefixes {
+		if !strings.HasPrefix(s, prefix) {
+			continue
+		}
+		buf, err := base64.StdEncoding.DecodeString(s[len(prefix):])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s points: %w", enc, err)
+		}
+		return decodePointBytes(buf, enc)
+	}
+	return nil, fmt.Errorf("unknown point encoding")
+}
+
+func decodePointBytes(buf []byte, enc PointEncoding) ([]float64, error) {
+	points := []float64{}
+	switch enc {
+	case PointEncodingDeltaVarint:
+		var prev [2]int64
+		for len(buf) > 0 {
+			delta, n := binary.Varint(buf)
+			if n <= 0 {
+				return nil, fmt.Errorf("invalid %s points", enc)
+			}
+			buf = buf[n:]
+			i := len(points) % 2
+			prev[i] += delta
+			points = append(points, float64(prev[i])/10)
+		}
+	case PointEncodingFloat32:
+		if len(buf)%4 != 0 {
+			return nil, fmt.Errorf("invalid %s points", enc)
+		}
+		for i := 0; i < len(buf); i += 4 {
+			v := math.Float32frombits(binary.LittleEndian.Uint32(buf[i:]))
+			// float32 can't hold most decimals exactly, keep what the client drew
+			points = append(points, math.Round(float64(v)*1000)/1000)
+		}
+	}
+	return points, nil
+}
+
+// Points is a flat x, y array. In JSON it is an array of numbers or an encoded string.
+type Points []float64
+
+func (p *Points) UnmarshalJSON(data []byte) error {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err == nil {
+		points, err := DecodePoints(encoded)
+		if err != nil {
+			return err
+		}
+		*p = points
+		return nil
+	}
+	var points []float64
+	if err := json.Unmarshal(data, &points); err != nil {
+		return err
+	}
+	*p = points
+	return nil
+}
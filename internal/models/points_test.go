@@ -0,0 +1,75 @@
+package models
+
+import (
+	"encoding/base64"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestEncodePointsRoundTrip(t *testing.T) {
+	cases := []struct {
+		name   string
+		points []float64
+	}{
+		{"empty", []float64{}},
+		{"single point", []float64{12.5, -7.25}},
+		{"stroke", []float64{0, 0, 10.04, 3.96, 20.11, 8.5, 31.7, 12.3, 45, 20}},
+		{"negative and large", []float64{-1500.33, 2200.47, -1499.9, 2199.01, 80000.06, -80000.04}},
+		{"repeated points", []float64{5, 5, 5, 5, 5, 5}},
+	}
+	for _, enc := range []PointEncoding{PointEncodingDeltaVarint, PointEncodingFloat32} {
+		for _, tc := range cases {
+			t.Run(string(enc)+"/"+tc.name, func(t *testing.T) {
+				encoded, err := EncodePoints(tc.points, enc)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.HasPrefix(encoded, pointEncodingPrefixes[enc]) {
+					t.Fatalf("encoded = %q, want prefix %q", encoded, pointEncodingPrefixes[enc])
+				}
+				decoded, err := DecodePoints(encoded)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(decoded) != len(tc.points) {
+					t.Fatalf("decoded %d coordinates, want %d", len(decoded), len(tc.points))
+				}
+				for i := range tc.points {
+					if math.Abs(decoded[i]-tc.points[i]) > 0.1 {
+						t.Fatalf("coordinate %d = %v, want %v within 0.1", i, decoded[i], tc.points[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestEncodePointsUnsupported(t *testing.T) {
+	if _, err := EncodePoints([]float64{1, 2}, "png"); err == nil {
+		t.Fatal("expected an error for an unsupported encoding")
+	}
+}
+
+func TestDecodePointsMalformed(t *testing.T) {
+	b64 := base64.StdEncoding.EncodeToString
+	cases := []struct {
+		name    string
+		encoded string
+	}{
+		{"no prefix", "AAAA"},
+		{"unknown prefix", "zz:AAAA"},
+		{"bad base64 varint", "dv:not base64!"},
+		{"bad base64 float32", "f32:@@@"},
+		{"truncated varint", "dv:" + b64([]byte{0x02, 0x80})},
+		{"overflowing varint", "dv:" + b64([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})},
+		{"partial float32", "f32:" + b64([]byte{0x00, 0x00, 0x80})},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if points, err := DecodePoints(tc.encoded); err == nil {
+				t.Fatalf("DecodePoints(%q) = %v, want an error", tc.encoded, points)
+			}
+		})
+	}
+}
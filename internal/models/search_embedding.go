@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// SearchEmbedding caches the embedding of a searchable text, keyed by the hash of
+// the text and the embedding model, so unchanged content is embedded once
+type SearchEmbedding struct {
+	ContentHash string         `gorm:"primaryKey" json:"content_hash"`
+	Model       string         `gorm:"primaryKey" json:"model"`
+	Vector      datatypes.JSON `gorm:"not null" json:"vector"` // []float32
+	CreatedAt   time.Time      `json:"created_at"`
+}
@@ -1,9 +1,11 @@
 package repo
 
 import (
+	"encoding/json"
 	"melina-studio-backend/internal/models"
 	"time"
 
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 
 	"github.com/google/uuid"
@@ -16,7 +18,10 @@ type BoardRepo struct {
 
 type BoardRepoInterface interface {
 	CreateBoard(board *models.Board) (uuid.UUID, error)
+	CreateBoardWithShapes(board *models.Board, shapes []models.BoardData) (uuid.UUID, error)
 	GetAllBoards() ([]models.Board, error)
+	GetBoardByID(boardId uuid.UUID) (*models.Board, error)
+	UpdateGenerationSettings(boardId uuid.UUID, params models.GenerationParams) error
 }
 
 func NewBoardRepository(db *gorm.DB) BoardRepoInterface {
@@ -33,9 +38,54 @@ func (r *BoardRepo) CreateBoard(board *models.Board) (uuid.UUID, error) {
 	return uuid, err
 }
 
+// CreateBoardWithShapes creates a board and its first shapes in one transaction,
+// so a failed insert doesn't leave an empty board behind
+func (r *BoardRepo) CreateBoardWithShapes(board *models.Board, shapes []models.BoardData) (uuid.UUID, error) {
+	var boardId uuid.UUID
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		boardId, err = (&BoardRepo{db: tx}).CreateBoard(board)
+		if err != nil {
+			return err
+		}
+		return (&BoardDataRepo{db: tx}).CreateShapes(boardId, shapes)
+	})
+	return boardId, err
+}
+
 // GetAllBoards returns all boards in the database
 func (r *BoardRepo) GetAllBoards() ([]models.Board, error) {
 	var boards []models.Board
 	err := r.db.Find(&boards).Error
 	return boards, err
 }
+
+// GetBoardByID returns a single board
+func (r *BoardRepo) GetBoardByID(boardId uuid.UUID) (*models.Board, error) {
+	var board models.Board
+	err := r.db.Where(&models.Board{UUUID: boardId}).First(&board).Error
+	if err != nil {
+		return nil, err
+	}
+	return &board, nil
+}
+
+// UpdateGenerationSettings stores the board's generation param overrides
+func (r *BoardRepo) UpdateGenerationSettings(boardId uuid.UUID, params models.GenerationParams) error {
+	settings, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	// the primary key on the model scopes the update to this board
+	result := r.db.Model(&models.Board{UUUID: boardId}).Updates(map[string]interface{}{
+		"generation_settings": datatypes.JSON(settings),
+		"updated_at":          time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
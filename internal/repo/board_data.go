@@ -1,6 +1,7 @@
 package repo
 
 import (
+	"melina-studio-backend/internal/melina/layout"
 	"melina-studio-backend/internal/models"
 
 	"time"
@@ -21,9 +22,13 @@ type BoardDataRepo struct {
 
 type BoardDataRepoInterface interface {
 	CreateBoardData(boardData *models.BoardData) error
+	CreateShapes(boardId uuid.UUID, shapes []models.BoardData) error
 	SaveShapeData(boardId uuid.UUID, shapeData *models.Shape) error
 	GetBoardData(boardId uuid.UUID) ([]models.BoardData, error)
+	QueryShapes(boardId uuid.UUID, query ShapeQuery) ([]models.BoardData, error)
 	ClearBoardData(boardId uuid.UUID) error
+	DeleteShapes(boardId uuid.UUID, shapeIds []uuid.UUID) error
+	UpdateShapesData(boardId uuid.UUID, shapes []models.BoardData) error
 }
 
 // NewBoardDataRepository returns a new instance of BoardDataRepo
@@ -31,7 +36,15 @@ func NewBoardDataRepository(db *gorm.DB) BoardDataRepoInterface {
 	return &BoardDataRepo{db: db}
 }
 
+// ShapeQuery filters the shapes of a board, zero values don't filter
+type ShapeQuery struct {
+	BBox  *layout.Bounds // shapes whose bounding box intersects it
+	Types []models.Type
+	Limit int
+}
+
 func (r *BoardDataRepo) CreateBoardData(boardData *models.BoardData) error {
+	layout.SetRowBounds(boardData)
 	return r.db.Create(boardData).Error
 }
 
@@ -92,6 +105,11 @@ func (r *BoardDataRepo) SaveShapeData(boardId uuid.UUID, shapeData *models.Shape
 		addString("stroke", shapeData.Stroke)
 		addString("fill", shapeData.Fill)
 		addFloat("strokeWidth", shapeData.StrokeWidth)
+		addString("fromShapeId", shapeData.FromShapeId)
+		addString("toShapeId", shapeData.ToShapeId)
+		addString("fromAnchor", shapeData.FromAnchor)
+		addString("toAnchor", shapeData.ToAnchor)
+		addString("label", shapeData.Label)
 
 	case "polygon":
 		addFloat("x", shapeData.X)
@@ -106,8 +124,8 @@ func (r *BoardDataRepo) SaveShapeData(boardId uuid.UUID, shapeData *models.Shape
 
 	case "pencil":
 		if shapeData.Points != nil {
-			// store slice, not pointer
-			dataMap["points"] = *shapeData.Points
+			// freehand strokes have far more points than needed to draw them
+			dataMap["points"] = layout.Simplify(*shapeData.Points, layout.SimplifyTolerance())
 		}
 		addString("stroke", shapeData.Stroke)
 		addString("fill", shapeData.Fill)
@@ -140,31 +158,140 @@ func (r *BoardDataRepo) SaveShapeData(boardId uuid.UUID, shapeData *models.Shape
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
+	layout.SetRowBounds(boardData)
 
 	// Check if shape exists, update or create
 	var existing models.BoardData
 	result := r.db.Where("uuid = ?", shapeUUID).First(&existing)
 
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		// Create new
-		return r.db.Create(boardData).Error
+		// new shapes go on top unless the client sent their order
+		if shapeData.ZIndex != nil {
+			boardData.ZIndex = *shapeData.ZIndex
+			return r.db.Create(boardData).Error
+		}
+		return r.db.Transaction(func(tx *gorm.DB) error {
+			z, err := (&BoardDataRepo{db: tx}).nextZIndex(boardId)
+			if err != nil {
+				return err
+			}
+			boardData.ZIndex = z
+			return tx.Create(boardData).Error
+		})
 	} else if result.Error != nil {
 		return result.Error
 	}
 
-	// preserve original CreatedAt
+	// preserve original CreatedAt and order
 	boardData.CreatedAt = existing.CreatedAt
+	boardData.ZIndex = existing.ZIndex
+	if shapeData.ZIndex != nil {
+		boardData.ZIndex = *shapeData.ZIndex
+	}
+
+	// Update existing, selected so a shape that lost its geometry clears its bounds
+	return r.db.Model(&existing).
+		Select("type", "data", "min_x", "min_y", "max_x", "max_y", "z_index", "updated_at").
+		Updates(boardData).Error
+}
 
-	// Update existing
-	return r.db.Model(&existing).Updates(boardData).Error
+// CreateShapes adds several shapes to a board in one transaction, on top of the
+// existing shapes and in the given order
+func (r *BoardDataRepo) CreateShapes(boardId uuid.UUID, shapes []models.BoardData) error {
+	if len(shapes) == 0 {
+		return nil
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		z, err := (&BoardDataRepo{db: tx}).nextZIndex(boardId)
+		if err != nil {
+			return err
+		}
+		for i := range shapes {
+			shapes[i].BoardId = boardId
+			shapes[i].ZIndex = z + i
+			shapes[i].CreatedAt = time.Now()
+			shapes[i].UpdatedAt = time.Now()
+			layout.SetRowBounds(&shapes[i])
+		}
+		return tx.Create(&shapes).Error
+	})
+}
+
+// nextZIndex returns the z index that puts a new shape above every shape of the board.
+// It must run in the transaction that inserts the shapes: it takes a per board lock,
+// held until commit, so concurrent inserts can't read the same top z index.
+func (r *BoardDataRepo) nextZIndex(boardId uuid.UUID) (int, error) {
+	if err := r.db.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "board_z:"+boardId.String()).Error; err != nil {
+		return 0, err
+	}
+	var top int
+	err := r.db.Model(&models.BoardData{}).
+		Where("board_id = ?", boardId).
+		Select("COALESCE(MAX(z_index), 0)").
+		Scan(&top).Error
+	return top + 1, err
 }
 
+// GetBoardData returns every shape of a board in drawing order
 func (r *BoardDataRepo) GetBoardData(boardId uuid.UUID) ([]models.BoardData, error) {
 	var boardData []models.BoardData
-	err := r.db.Where("board_id = ?", boardId).Find(&boardData).Error
+	err := r.db.Where("board_id = ?", boardId).Order("z_index, created_at, uuid").Find(&boardData).Error
+	return boardData, err
+}
+
+// QueryShapes returns the shapes of a board matching the query in drawing order
+func (r *BoardDataRepo) QueryShapes(boardId uuid.UUID, query ShapeQuery) ([]models.BoardData, error) {
+	db := r.db.Where("board_id = ?", boardId)
+	if query.BBox != nil {
+		b := query.BBox
+		// same expression as idx_board_data_bbox_gist, see config/db.go
+		db = db.Where("min_x IS NOT NULL AND box(point(min_x, min_y), point(max_x, max_y)) && box(point(?, ?), point(?, ?))", b.X, b.Y, b.Right(), b.Bottom())
+	}
+	if len(query.Types) > 0 {
+		db = db.Where("type IN ?", query.Types)
+	}
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit)
+	}
+	var boardData []models.BoardData
+	err := db.Order("z_index, created_at, uuid").Find(&boardData).Error
 	return boardData, err
 }
 
 func (r *BoardDataRepo) ClearBoardData(boardId uuid.UUID) error {
 	return r.db.Where("board_id = ?", boardId).Delete(&models.BoardData{}).Error
 }
+
+// DeleteShapes deletes some shapes of a board
+func (r *BoardDataRepo) DeleteShapes(boardId uuid.UUID, shapeIds []uuid.UUID) error {
+	if len(shapeIds) == 0 {
+		return nil
+	}
+	return r.db.Where("board_id = ? AND uuid IN ?", boardId, shapeIds).Delete(&models.BoardData{}).Error
+}
+
+// UpdateShapesData stores the new data of several shapes of a board in one transaction
+func (r *BoardDataRepo) UpdateShapesData(boardId uuid.UUID, shapes []models.BoardData) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, shape := range shapes {
+			layout.SetRowBounds(&shape)
+			result := tx.Model(&models.BoardData{}).
+				Where("board_id = ? AND uuid = ?", boardId, shape.UUID).
+				Updates(map[string]interface{}{
+					"data":       shape.Data,
+					"min_x":      shape.MinX,
+					"min_y":      shape.MinY,
+					"max_x":      shape.MaxX,
+					"max_y":      shape.MaxY,
+					"updated_at": time.Now(),
+				})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return gorm.ErrRecordNotFound
+			}
+		}
+		return nil
+	})
+}
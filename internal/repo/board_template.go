@@ -0,0 +1,63 @@
+package repo
+
+import (
+	"melina-studio-backend/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// BoardTemplateRepo represents the repository for the board template model
+type BoardTemplateRepo struct {
+	db *gorm.DB
+}
+
+type BoardTemplateRepoInterface interface {
+	CreateTemplate(template *models.BoardTemplate) (uuid.UUID, error)
+	GetTemplates(ownerId uuid.UUID) ([]models.BoardTemplate, error)
+	GetTemplate(templateId uuid.UUID) (*models.BoardTemplate, error)
+	DeleteTemplate(ownerId uuid.UUID, templateId uuid.UUID) error
+}
+
+func NewBoardTemplateRepository(db *gorm.DB) BoardTemplateRepoInterface {
+	return &BoardTemplateRepo{db: db}
+}
+
+// CreateTemplate stores a new template
+func (r *BoardTemplateRepo) CreateTemplate(template *models.BoardTemplate) (uuid.UUID, error) {
+	template.UUID = uuid.New()
+	template.CreatedAt = time.Now()
+	template.UpdatedAt = time.Now()
+	err := r.db.Create(template).Error
+	return template.UUID, err
+}
+
+// GetTemplates returns the templates of an owner without their shapes, newest first
+func (r *BoardTemplateRepo) GetTemplates(ownerId uuid.UUID) ([]models.BoardTemplate, error) {
+	var templates []models.BoardTemplate
+	err := r.db.Omit("shapes").Where("owner_id = ?", ownerId).Order("created_at DESC").Find(&templates).Error
+	return templates, err
+}
+
+// GetTemplate returns a template with its shapes
+func (r *BoardTemplateRepo) GetTemplate(templateId uuid.UUID) (*models.BoardTemplate, error) {
+	var template models.BoardTemplate
+	err := r.db.Where("uuid = ?", templateId).First(&template).Error
+	if err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+// DeleteTemplate deletes a template of an owner
+func (r *BoardTemplateRepo) DeleteTemplate(ownerId uuid.UUID, templateId uuid.UUID) error {
+	result := r.db.Where("uuid = ? AND owner_id = ?", templateId, ownerId).Delete(&models.BoardTemplate{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
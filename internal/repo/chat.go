@@ -1,12 +1,16 @@
 package repo
 
 import (
+	"errors"
+	"fmt"
 	llmHandlers "melina-studio-backend/internal/llm_handlers"
 	"melina-studio-backend/internal/models"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ChatRepo struct {
@@ -15,10 +19,21 @@ type ChatRepo struct {
 
 type ChatRepoInterface interface {
 	CreateChat(chat *models.Chat) error
-	GetChatsByBoardId(boardId uuid.UUID, page int, pageSize int, fields ...string) ([]models.Chat, int64, error)
-	CreateHumanAndAiMessages(boardUUID uuid.UUID, humanMessage string, aiMessage string) (uuid.UUID, uuid.UUID, error)
-	GetChatHistory(boardId uuid.UUID, size int) ([]llmHandlers.Message, error)
-	GetLatestChats(boardId uuid.UUID, limit int, fields ...string) ([]models.Chat, error)
+	GetChatsPage(boardId uuid.UUID, threadId uuid.UUID, page ChatPage) ([]models.Chat, bool, error)
+	CreateHumanAndAiMessages(boardUUID uuid.UUID, threadUUID uuid.UUID, humanMessage string, aiMessage string, aiStatus models.ChatStatus) (uuid.UUID, uuid.UUID, error)
+	GetChatHistory(boardId uuid.UUID, threadId uuid.UUID, tokenBudget int) ([]llmHandlers.Message, error)
+	GetChatHistoryBefore(boardId uuid.UUID, threadId uuid.UUID, before time.Time, tokenBudget int) ([]llmHandlers.Message, error)
+	GetLatestChats(boardId uuid.UUID, threadId uuid.UUID, limit int, fields ...string) ([]models.Chat, error)
+	GetChatsSince(boardId uuid.UUID, threadId uuid.UUID, since time.Time, fields ...string) ([]models.Chat, error)
+	GetChatSummary(boardId uuid.UUID, threadId uuid.UUID) (*models.ChatSummary, error)
+	SaveChatSummary(summary *models.ChatSummary) error
+	CreateToolCalls(chatUUID uuid.UUID, toolCalls []models.ChatToolCall) error
+	GetToolCalls(boardId uuid.UUID, chatIds ...uuid.UUID) ([]models.ChatToolCall, error)
+	GetChat(boardId uuid.UUID, chatId uuid.UUID) (*models.Chat, error)
+	GetPreviousChat(chat *models.Chat, role models.Role) (*models.Chat, error)
+	DeleteChat(boardId uuid.UUID, chatId uuid.UUID) error
+	GetToolCallsFrom(chat *models.Chat) ([]models.ChatToolCall, error)
+	ReplaceChatsFrom(chat *models.Chat, revertShapeIds []uuid.UUID, humanMessage string, aiMessage string, aiStatus models.ChatStatus) (uuid.UUID, uuid.UUID, error)
 }
 
 func NewChatRepository(db *gorm.DB) ChatRepoInterface {
@@ -29,87 +44,265 @@ func (r *ChatRepo) CreateChat(chat *models.Chat) error {
 	return r.db.Create(chat).Error
 }
 
-// signature returns chats, totalCount, error
-func (r *ChatRepo) GetChatsByBoardId(boardId uuid.UUID, page int, pageSize int, fields ...string) ([]models.Chat, int64, error) {
+// ChatPage selects a page of chats. Before and After are message ids used as
+// cursors; the page holds chats strictly older than Before and/or newer than After.
+type ChatPage struct {
+	Before      *uuid.UUID
+	After       *uuid.UUID
+	Limit       int
+	NewestFirst bool
+	Fields      []string
+}
+
+// ErrInvalidChatField is returned when a page selects a column that doesn't exist
+var ErrInvalidChatField = errors.New("invalid chat field")
+
+// chatPageFields are the columns a page may select
+var chatPageFields = map[string]bool{
+	"uuid":        true,
+	"board_uuid":  true,
+	"thread_uuid": true,
+	"content":     true,
+	"role":        true,
+	"status":      true,
+	"created_at":  true,
+	"updated_at":  true,
+	"attachments": true,
+}
+
+// GetChatsPage returns a page of a thread's chats and whether more chats follow in
+// the page's order. It uses keyset pagination on (created_at, uuid), so no count or offset is needed.
+func (r *ChatRepo) GetChatsPage(boardId uuid.UUID, threadId uuid.UUID, page ChatPage) ([]models.Chat, bool, error) {
 	var chats []models.Chat
-	var total int64
 
 	// sane defaults + cap
-	if page < 1 {
-		page = 1
-	}
 	const DefaultPageSize = 20
 	const MaxPageSize = 100
-	if pageSize <= 0 {
-		pageSize = DefaultPageSize
+	limit := page.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
 	}
-	if pageSize > MaxPageSize {
-		pageSize = MaxPageSize
+	if limit > MaxPageSize {
+		limit = MaxPageSize
 	}
 
-	offset := (page - 1) * pageSize
-
-	base := r.db.Model(&models.Chat{}).Where("board_uuid = ?", boardId)
+	query := r.db.Model(&models.Chat{}).Where("board_uuid = ? AND thread_uuid = ?", boardId, threadId)
 
-	// total count
-	if err := base.Count(&total).Error; err != nil {
-		return nil, 0, err
+	if page.Before != nil {
+		cursor, err := r.GetChat(boardId, *page.Before)
+		if err != nil {
+			return nil, false, err
+		}
+		query = query.Where("(created_at, uuid) < (?, ?)", cursor.CreatedAt, cursor.UUID)
+	}
+	if page.After != nil {
+		cursor, err := r.GetChat(boardId, *page.After)
+		if err != nil {
+			return nil, false, err
+		}
+		query = query.Where("(created_at, uuid) > (?, ?)", cursor.CreatedAt, cursor.UUID)
 	}
 
-	// apply select if fields passed
-	query := base
-	if len(fields) > 0 {
+	// the cursor columns are always selected so the client can ask for the next page
+	if len(page.Fields) > 0 {
+		fields := []string{"uuid", "created_at"}
+		for _, field := range page.Fields {
+			if !chatPageFields[field] {
+				return nil, false, fmt.Errorf("%w: %s", ErrInvalidChatField, field)
+			}
+			if field != "uuid" && field != "created_at" {
+				fields = append(fields, field)
+			}
+		}
 		query = query.Select(fields)
 	}
 
-	// optional: choose ordering (newest first)
-	if err := query.Order("created_at asc").
-		Limit(pageSize).
-		Offset(offset).
-		Find(&chats).Error; err != nil {
-		return nil, 0, err
+	if page.NewestFirst {
+		query = query.Order("created_at DESC").Order("uuid DESC")
+	} else {
+		query = query.Order("created_at ASC").Order("uuid ASC")
 	}
 
-	return chats, total, nil
+	// one extra row tells whether there is a next page
+	if err := query.Limit(limit + 1).Find(&chats).Error; err != nil {
+		return nil, false, err
+	}
+	hasMore := len(chats) > limit
+	if hasMore {
+		chats = chats[:limit]
+	}
+	return chats, hasMore, nil
 }
 
-func (r *ChatRepo) CreateHumanAndAiMessages(boardUUID uuid.UUID, humanMessage string, aiMessage string) (uuid.UUID, uuid.UUID, error) {
-	humanMessageUUID := uuid.New()
-	aiMessageUUID := uuid.New()
+// CreateHumanAndAiMessages stores a user message and the assistant reply to it.
+// aiStatus marks whether the reply is complete or was cut short by a cancellation.
+func (r *ChatRepo) CreateHumanAndAiMessages(boardUUID uuid.UUID, threadUUID uuid.UUID, humanMessage string, aiMessage string, aiStatus models.ChatStatus) (uuid.UUID, uuid.UUID, error) {
+	var humanMessageUUID, aiMessageUUID uuid.UUID
 
 	// Use a transaction to ensure both messages are created atomically
 	err := r.db.Transaction(func(tx *gorm.DB) error {
-		// Create human message
-		if err := tx.Create(&models.Chat{
-			UUID:      humanMessageUUID,
-			BoardUUID: boardUUID,
-			Content:   humanMessage,
-			Role:      models.RoleUser,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}).Error; err != nil {
-			return err
+		var err error
+		humanMessageUUID, aiMessageUUID, err = createHumanAndAiMessages(tx, boardUUID, threadUUID, humanMessage, aiMessage, aiStatus)
+		return err
+	})
+
+	return humanMessageUUID, aiMessageUUID, err
+}
+
+// createHumanAndAiMessages creates a turn inside the transaction tx
+func createHumanAndAiMessages(tx *gorm.DB, boardUUID uuid.UUID, threadUUID uuid.UUID, humanMessage string, aiMessage string, aiStatus models.ChatStatus) (uuid.UUID, uuid.UUID, error) {
+	humanMessageUUID := uuid.New()
+	aiMessageUUID := uuid.New()
+
+	// Create human message
+	if err := tx.Create(&models.Chat{
+		UUID:       humanMessageUUID,
+		BoardUUID:  boardUUID,
+		ThreadUUID: threadUUID,
+		Content:    humanMessage,
+		Role:       models.RoleUser,
+		Status:     models.ChatStatusCompleted,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}).Error; err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+
+	// Create AI message
+	if err := tx.Create(&models.Chat{
+		UUID:       aiMessageUUID,
+		BoardUUID:  boardUUID,
+		ThreadUUID: threadUUID,
+		Content:    aiMessage,
+		Role:       models.RoleAssistant,
+		Status:     aiStatus,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}).Error; err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+
+	return humanMessageUUID, aiMessageUUID, nil
+}
+
+// historyFetchLimit is how many recent chats GetChatHistory considers before applying the token budget
+const historyFetchLimit = 100
+
+// GetLatestChats returns the most recent chats of a board, oldest first
+func (r *ChatRepo) GetLatestChats(boardId uuid.UUID, threadId uuid.UUID, limit int, fields ...string) ([]models.Chat, error) {
+	return r.getLatestChatsBetween(boardId, threadId, time.Time{}, time.Time{}, limit, fields...)
+}
+
+// GetChatHistory returns the most recent conversation of a board thread that fits in
+// tokenBudget, keeping user/assistant pairs together. When older chats were
+// compacted or dropped, a note with the running summary is prepended as a user/assistant pair.
+func (r *ChatRepo) GetChatHistory(boardId uuid.UUID, threadId uuid.UUID, tokenBudget int) ([]llmHandlers.Message, error) {
+	return r.GetChatHistoryBefore(boardId, threadId, time.Time{}, tokenBudget)
+}
+
+// GetChatHistoryBefore is GetChatHistory limited to the chats created before a point in
+// time, e.g. the history of a turn that is answered again. A zero time means no limit.
+func (r *ChatRepo) GetChatHistoryBefore(boardId uuid.UUID, threadId uuid.UUID, before time.Time, tokenBudget int) ([]llmHandlers.Message, error) {
+	summary, err := r.GetChatSummary(boardId, threadId)
+	if err != nil {
+		return nil, err
+	}
+	// a summary that reaches past the cutoff includes the chats being answered again
+	if summary != nil && !before.IsZero() && !summary.SummarizedUntil.Before(before) {
+		summary = nil
+	}
+
+	var after time.Time
+	if summary != nil {
+		after = summary.SummarizedUntil
+	}
+	chats, err := r.getLatestChatsBetween(boardId, threadId, after, before, historyFetchLimit, "uuid", "role", "content", "attachments")
+	if err != nil {
+		return nil, err
+	}
+	if err := r.appendToolTrails(boardId, chats); err != nil {
+		return nil, err
+	}
+	appendAttachmentNotes(chats)
+
+	// the summary is always sent, so it comes out of the budget first
+	budget := tokenBudget
+	if summary != nil {
+		budget -= llmHandlers.EstimateTokens(summary.Summary)
+	}
+	kept := selectChatWindow(chats, budget)
+	dropped := len(chats) - len(kept)
+
+	chatHistoryMessages := []llmHandlers.Message{}
+	if summary != nil || dropped > 0 {
+		note := ""
+		if summary != nil {
+			note = "Summary of our earlier conversation on this board:\n" + summary.Summary
+		}
+		if dropped > 0 {
+			if note != "" {
+				note += "\n\n"
+			}
+			note += fmt.Sprintf("(%d older messages were left out to save space.)", dropped)
+		}
+		chatHistoryMessages = append(chatHistoryMessages,
+			llmHandlers.Message{
+				Role:    models.RoleUser,
+				Content: note,
+			},
+			llmHandlers.Message{
+				Role:    models.RoleAssistant,
+				Content: "Got it, I'll keep that context in mind.",
+			},
+		)
+	}
+	for _, chat := range kept {
+		// older cancelled generations may have stored an empty answer, which providers reject
+		if chat.Role == models.RoleAssistant && strings.TrimSpace(chat.Content) == "" {
+			continue
 		}
+		chatHistoryMessages = append(chatHistoryMessages, llmHandlers.Message{
+			Role:    chat.Role,
+			Content: chat.Content,
+		})
+	}
 
-		// Create AI message
-		if err := tx.Create(&models.Chat{
-			UUID:      aiMessageUUID,
-			BoardUUID: boardUUID,
-			Content:   aiMessage,
-			Role:      models.RoleAssistant,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}).Error; err != nil {
-			return err
+	return chatHistoryMessages, nil
+}
+
+// selectChatWindow returns the longest suffix of chats (oldest first) that fits in
+// budget tokens. A user message and the assistant reply after it are kept or dropped together.
+func selectChatWindow(chats []models.Chat, budget int) []models.Chat {
+	start := len(chats)
+	used := 0
+	for start > 0 {
+		// the unit ending at start-1: a user/assistant pair, or a lone message
+		unitStart := start - 1
+		if unitStart > 0 && chats[unitStart].Role == models.RoleAssistant && chats[unitStart-1].Role == models.RoleUser {
+			unitStart--
 		}
 
-		return nil
-	})
+		cost := 0
+		for _, chat := range chats[unitStart:start] {
+			cost += llmHandlers.EstimateTokens(chat.Content)
+		}
+		if used+cost > budget {
+			break
+		}
+		used += cost
+		start = unitStart
+	}
 
-	return humanMessageUUID, aiMessageUUID, err
+	// never start the window with an assistant reply
+	for start < len(chats) && chats[start].Role == models.RoleAssistant {
+		start++
+	}
+	return chats[start:]
 }
 
-func (r *ChatRepo) GetLatestChats(boardId uuid.UUID, limit int, fields ...string) ([]models.Chat, error) {
+// getLatestChatsBetween returns the most recent chats created after a point in time
+// and, unless before is zero, before another, oldest first
+func (r *ChatRepo) getLatestChatsBetween(boardId uuid.UUID, threadId uuid.UUID, after time.Time, before time.Time, limit int, fields ...string) ([]models.Chat, error) {
 	var chats []models.Chat
 
 	// default + cap
@@ -120,30 +313,212 @@ func (r *ChatRepo) GetLatestChats(boardId uuid.UUID, limit int, fields ...string
 		limit = 100
 	}
 
-	query := r.db.Model(&models.Chat{}).Where("board_uuid = ?", boardId)
+	query := r.db.Model(&models.Chat{}).Where("board_uuid = ? AND thread_uuid = ? AND created_at > ?", boardId, threadId, after)
+	if !before.IsZero() {
+		query = query.Where("created_at < ?", before)
+	}
+	if len(fields) > 0 {
+		query = query.Select(fields)
+	}
+
+	// newest first so the limit keeps the latest chats, then flip back to chronological order
+	if err := query.Order("created_at DESC").Limit(limit).Find(&chats).Error; err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(chats)-1; i < j; i, j = i+1, j-1 {
+		chats[i], chats[j] = chats[j], chats[i]
+	}
+	return chats, nil
+}
+
+// GetChatsSince returns every chat of a board thread created after since, oldest first
+func (r *ChatRepo) GetChatsSince(boardId uuid.UUID, threadId uuid.UUID, since time.Time, fields ...string) ([]models.Chat, error) {
+	var chats []models.Chat
 
+	query := r.db.Model(&models.Chat{}).Where("board_uuid = ? AND thread_uuid = ? AND created_at > ?", boardId, threadId, since)
 	if len(fields) > 0 {
 		query = query.Select(fields)
 	}
 
-	err := query.Order("created_at ASC").Limit(limit).Find(&chats).Error
+	err := query.Order("created_at ASC").Find(&chats).Error
 	return chats, err
 }
 
-func (r *ChatRepo) GetChatHistory(boardId uuid.UUID, size int) ([]llmHandlers.Message, error) {
+// GetChatSummary returns the running summary of a board thread, or nil if there is none yet
+func (r *ChatRepo) GetChatSummary(boardId uuid.UUID, threadId uuid.UUID) (*models.ChatSummary, error) {
+	var summaries []models.ChatSummary
+	if err := r.db.Where("board_uuid = ? AND thread_uuid = ?", boardId, threadId).Limit(1).Find(&summaries).Error; err != nil {
+		return nil, err
+	}
+	if len(summaries) == 0 {
+		return nil, nil
+	}
+	return &summaries[0], nil
+}
+
+// SaveChatSummary creates or replaces the running summary of a board thread
+func (r *ChatRepo) SaveChatSummary(summary *models.ChatSummary) error {
+	now := time.Now()
+	if summary.UUID == uuid.Nil {
+		summary.UUID = uuid.New()
+	}
+	if summary.CreatedAt.IsZero() {
+		summary.CreatedAt = now
+	}
+	summary.UpdatedAt = now
+
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "board_uuid"}, {Name: "thread_uuid"}},
+		DoUpdates: clause.AssignmentColumns([]string{"summary", "summarized_until", "message_count", "updated_at"}),
+	}).Create(summary).Error
+}
+
+// CreateToolCalls stores the tool calls made for an assistant chat
+func (r *ChatRepo) CreateToolCalls(chatUUID uuid.UUID, toolCalls []models.ChatToolCall) error {
+	if len(toolCalls) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	for i := range toolCalls {
+		toolCalls[i].ChatUUID = chatUUID
+		if toolCalls[i].UUID == uuid.Nil {
+			toolCalls[i].UUID = uuid.New()
+		}
+		if toolCalls[i].CreatedAt.IsZero() {
+			toolCalls[i].CreatedAt = now
+		}
+	}
+	return r.db.Create(&toolCalls).Error
+}
+
+// GetToolCalls returns the tool calls of a board in the order they were made,
+// optionally limited to some assistant chats
+func (r *ChatRepo) GetToolCalls(boardId uuid.UUID, chatIds ...uuid.UUID) ([]models.ChatToolCall, error) {
+	var toolCalls []models.ChatToolCall
+
+	query := r.db.Model(&models.ChatToolCall{}).Where("board_uuid = ?", boardId)
+	if len(chatIds) > 0 {
+		query = query.Where("chat_uuid IN ?", chatIds)
+	}
+
+	err := query.Order("created_at ASC").Order("position ASC").Find(&toolCalls).Error
+	return toolCalls, err
+}
+
+// appendToolTrails adds a compact summary of the tool calls behind every assistant
+// chat to its content, so the model knows what it already did on the board
+func (r *ChatRepo) appendToolTrails(boardId uuid.UUID, chats []models.Chat) error {
+	chatIds := []uuid.UUID{}
+	for _, chat := range chats {
+		if chat.Role == models.RoleAssistant {
+			chatIds = append(chatIds, chat.UUID)
+		}
+	}
+	if len(chatIds) == 0 {
+		return nil
+	}
+
+	toolCalls, err := r.GetToolCalls(boardId, chatIds...)
+	if err != nil {
+		return err
+	}
+	byChat := map[uuid.UUID][]models.ChatToolCall{}
+	for _, tc := range toolCalls {
+		byChat[tc.ChatUUID] = append(byChat[tc.ChatUUID], tc)
+	}
+
+	for i := range chats {
+		if trail := toolTrailSummary(byChat[chats[i].UUID]); trail != "" {
+			chats[i].Content += "\n\n" + trail
+		}
+	}
+	return nil
+}
+
+// GetChat returns a chat of a board
+func (r *ChatRepo) GetChat(boardId uuid.UUID, chatId uuid.UUID) (*models.Chat, error) {
+	var chat models.Chat
+	err := r.db.Where("uuid = ? AND board_uuid = ?", chatId, boardId).First(&chat).Error
+	if err != nil {
+		return nil, err
+	}
+	return &chat, nil
+}
 
-	chats, err := r.GetLatestChats(boardId, size, "role", "content")
+// GetPreviousChat returns the latest chat with the given role before chat, in the same thread
+func (r *ChatRepo) GetPreviousChat(chat *models.Chat, role models.Role) (*models.Chat, error) {
+	var previous models.Chat
+	err := r.db.Where("board_uuid = ? AND thread_uuid = ? AND role = ? AND created_at < ?", chat.BoardUUID, chat.ThreadUUID, role, chat.CreatedAt).
+		Order("created_at DESC").
+		First(&previous).Error
 	if err != nil {
 		return nil, err
 	}
+	return &previous, nil
+}
 
-	chatHistoryMessages := []llmHandlers.Message{}
-	for _, chat := range chats {
-		chatHistoryMessages = append(chatHistoryMessages, llmHandlers.Message{
-			Role:    chat.Role,
-			Content: chat.Content,
-		})
+// DeleteChat deletes a single chat and its tool calls
+func (r *ChatRepo) DeleteChat(boardId uuid.UUID, chatId uuid.UUID) error {
+	chat, err := r.GetChat(boardId, chatId)
+	if err != nil {
+		return err
 	}
 
-	return chatHistoryMessages, nil
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("chat_uuid = ?", chat.UUID).Delete(&models.ChatToolCall{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.Chat{}, "uuid = ?", chat.UUID).Error; err != nil {
+			return err
+		}
+		return dropStaleSummary(tx, chat)
+	})
+}
+
+// GetToolCallsFrom returns the tool calls of chat and every later chat of its thread
+func (r *ChatRepo) GetToolCallsFrom(chat *models.Chat) ([]models.ChatToolCall, error) {
+	var toolCalls []models.ChatToolCall
+	later := r.db.Model(&models.Chat{}).Select("uuid").
+		Where("board_uuid = ? AND thread_uuid = ? AND created_at >= ?", chat.BoardUUID, chat.ThreadUUID, chat.CreatedAt)
+	err := r.db.Where("chat_uuid IN (?)", later).Order("created_at ASC").Order("position ASC").Find(&toolCalls).Error
+	return toolCalls, err
+}
+
+// ReplaceChatsFrom answers a turn again: in one transaction it deletes chat and every
+// later chat of its thread, the shapes in revertShapeIds, and creates the new turn
+func (r *ChatRepo) ReplaceChatsFrom(chat *models.Chat, revertShapeIds []uuid.UUID, humanMessage string, aiMessage string, aiStatus models.ChatStatus) (uuid.UUID, uuid.UUID, error) {
+	var humanMessageUUID, aiMessageUUID uuid.UUID
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		later := tx.Model(&models.Chat{}).Select("uuid").
+			Where("board_uuid = ? AND thread_uuid = ? AND created_at >= ?", chat.BoardUUID, chat.ThreadUUID, chat.CreatedAt)
+
+		if err := tx.Where("chat_uuid IN (?)", later).Delete(&models.ChatToolCall{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("board_uuid = ? AND thread_uuid = ? AND created_at >= ?", chat.BoardUUID, chat.ThreadUUID, chat.CreatedAt).Delete(&models.Chat{}).Error; err != nil {
+			return err
+		}
+		if err := dropStaleSummary(tx, chat); err != nil {
+			return err
+		}
+		if len(revertShapeIds) > 0 {
+			if err := tx.Where("board_id = ? AND uuid IN ?", chat.BoardUUID, revertShapeIds).Delete(&models.BoardData{}).Error; err != nil {
+				return err
+			}
+		}
+
+		var err error
+		humanMessageUUID, aiMessageUUID, err = createHumanAndAiMessages(tx, chat.BoardUUID, chat.ThreadUUID, humanMessage, aiMessage, aiStatus)
+		return err
+	})
+	return humanMessageUUID, aiMessageUUID, err
+}
+
+// dropStaleSummary deletes the thread summary if it covers a removed chat;
+// compaction rebuilds it from the remaining history
+func dropStaleSummary(tx *gorm.DB, removed *models.Chat) error {
+	return tx.Where("board_uuid = ? AND thread_uuid = ? AND summarized_until >= ?", removed.BoardUUID, removed.ThreadUUID, removed.CreatedAt).
+		Delete(&models.ChatSummary{}).Error
 }
@@ -0,0 +1,103 @@
+package repo
+
+import (
+	"encoding/json"
+	"melina-studio-backend/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// ChatAttachmentRepo represents the repository for the chat attachment model
+type ChatAttachmentRepo struct {
+	db *gorm.DB
+}
+
+type ChatAttachmentRepoInterface interface {
+	CreateAttachment(attachment *models.ChatAttachment) (uuid.UUID, error)
+	GetAttachment(boardId uuid.UUID, attachmentId uuid.UUID) (*models.ChatAttachment, error)
+	GetAttachments(boardId uuid.UUID, attachmentIds []uuid.UUID) ([]models.ChatAttachment, error)
+	LinkAttachments(chatId uuid.UUID, attachments []models.ChatAttachment) error
+}
+
+func NewChatAttachmentRepository(db *gorm.DB) ChatAttachmentRepoInterface {
+	return &ChatAttachmentRepo{db: db}
+}
+
+// CreateAttachment stores the metadata of an uploaded attachment
+func (r *ChatAttachmentRepo) CreateAttachment(attachment *models.ChatAttachment) (uuid.UUID, error) {
+	if attachment.UUID == uuid.Nil {
+		attachment.UUID = uuid.New()
+	}
+	attachment.CreatedAt = time.Now()
+	err := r.db.Create(attachment).Error
+	return attachment.UUID, err
+}
+
+// GetAttachment returns an attachment of a board
+func (r *ChatAttachmentRepo) GetAttachment(boardId uuid.UUID, attachmentId uuid.UUID) (*models.ChatAttachment, error) {
+	var attachment models.ChatAttachment
+	err := r.db.Where("uuid = ? AND board_uuid = ?", attachmentId, boardId).First(&attachment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// GetAttachments returns attachments of a board in the requested order.
+// It fails with gorm.ErrRecordNotFound if any of them doesn't exist.
+func (r *ChatAttachmentRepo) GetAttachments(boardId uuid.UUID, attachmentIds []uuid.UUID) ([]models.ChatAttachment, error) {
+	if len(attachmentIds) == 0 {
+		return nil, nil
+	}
+
+	var found []models.ChatAttachment
+	if err := r.db.Where("board_uuid = ? AND uuid IN ?", boardId, attachmentIds).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	byId := make(map[uuid.UUID]models.ChatAttachment, len(found))
+	for _, attachment := range found {
+		byId[attachment.UUID] = attachment
+	}
+
+	attachments := make([]models.ChatAttachment, 0, len(attachmentIds))
+	for _, id := range attachmentIds {
+		attachment, ok := byId[id]
+		if !ok {
+			return nil, gorm.ErrRecordNotFound
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
+
+// LinkAttachments references the attachments from a chat
+func (r *ChatAttachmentRepo) LinkAttachments(chatId uuid.UUID, attachments []models.ChatAttachment) error {
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(attachments))
+	refs := make([]models.AttachmentRef, 0, len(attachments))
+	for _, attachment := range attachments {
+		ids = append(ids, attachment.UUID)
+		refs = append(refs, models.AttachmentRef{
+			UUID:      attachment.UUID,
+			MediaType: attachment.MediaType,
+			Filename:  attachment.Filename,
+		})
+	}
+	refsJSON, err := json.Marshal(refs)
+	if err != nil {
+		return err
+	}
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.ChatAttachment{}).Where("uuid IN ?", ids).Update("chat_uuid", chatId).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Chat{}).Where("uuid = ?", chatId).Update("attachments", datatypes.JSON(refsJSON)).Error
+	})
+}
@@ -0,0 +1,103 @@
+package repo
+
+import (
+	"melina-studio-backend/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ChatThreadRepo represents the repository for the chat thread model
+type ChatThreadRepo struct {
+	db *gorm.DB
+}
+
+type ChatThreadRepoInterface interface {
+	CreateThread(thread *models.ChatThread) (uuid.UUID, error)
+	GetThreadsByBoardId(boardId uuid.UUID, includeArchived bool) ([]models.ChatThread, error)
+	GetThread(boardId uuid.UUID, threadId uuid.UUID) (*models.ChatThread, error)
+	UpdateThread(boardId uuid.UUID, threadId uuid.UUID, updates map[string]interface{}) error
+	DeleteThread(boardId uuid.UUID, threadId uuid.UUID) error
+}
+
+func NewChatThreadRepository(db *gorm.DB) ChatThreadRepoInterface {
+	return &ChatThreadRepo{db: db}
+}
+
+// CreateThread creates a new thread on a board
+func (r *ChatThreadRepo) CreateThread(thread *models.ChatThread) (uuid.UUID, error) {
+	thread.UUID = uuid.New()
+	thread.CreatedAt = time.Now()
+	thread.UpdatedAt = time.Now()
+	err := r.db.Create(thread).Error
+	return thread.UUID, err
+}
+
+// GetThreadsByBoardId returns the threads of a board, newest first
+func (r *ChatThreadRepo) GetThreadsByBoardId(boardId uuid.UUID, includeArchived bool) ([]models.ChatThread, error) {
+	var threads []models.ChatThread
+
+	query := r.db.Where("board_uuid = ?", boardId)
+	if !includeArchived {
+		query = query.Where("archived = ?", false)
+	}
+
+	err := query.Order("created_at DESC").Find(&threads).Error
+	return threads, err
+}
+
+// GetThread returns a thread of a board
+func (r *ChatThreadRepo) GetThread(boardId uuid.UUID, threadId uuid.UUID) (*models.ChatThread, error) {
+	var thread models.ChatThread
+	err := r.db.Where("uuid = ? AND board_uuid = ?", threadId, boardId).First(&thread).Error
+	if err != nil {
+		return nil, err
+	}
+	return &thread, nil
+}
+
+// UpdateThread updates the given columns (title, archived) of a thread
+func (r *ChatThreadRepo) UpdateThread(boardId uuid.UUID, threadId uuid.UUID, updates map[string]interface{}) error {
+	updates["updated_at"] = time.Now()
+	result := r.db.Model(&models.ChatThread{}).
+		Where("uuid = ? AND board_uuid = ?", threadId, boardId).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteThread deletes a thread together with its chats, their tool calls and its summary
+func (r *ChatThreadRepo) DeleteThread(boardId uuid.UUID, threadId uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("uuid = ? AND board_uuid = ?", threadId, boardId).Delete(&models.ChatThread{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		chats := tx.Model(&models.Chat{}).Select("uuid").Where("board_uuid = ? AND thread_uuid = ?", boardId, threadId)
+		if err := tx.Where("chat_uuid IN (?)", chats).Delete(&models.ChatToolCall{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("board_uuid = ? AND thread_uuid = ?", boardId, threadId).Delete(&models.Chat{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("board_uuid = ? AND thread_uuid = ?", boardId, threadId).Delete(&models.ChatSummary{}).Error
+	})
+}
+
+// ParseThreadID parses a thread id from a request; an empty id means the default thread
+func ParseThreadID(threadId string) (uuid.UUID, error) {
+	if threadId == "" {
+		return models.DefaultThreadUUID, nil
+	}
+	return uuid.Parse(threadId)
+}
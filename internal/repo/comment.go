@@ -0,0 +1,128 @@
+package repo
+
+import (
+	"encoding/json"
+	"melina-studio-backend/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// CommentRepo represents the repository for the comment model
+type CommentRepo struct {
+	db *gorm.DB
+}
+
+// CommentFilter picks threads, zero values don't filter
+type CommentFilter struct {
+	Resolved *bool
+	ShapeId  string
+	Mention  string
+}
+
+type CommentRepoInterface interface {
+	CreateComment(comment *models.Comment) (uuid.UUID, error)
+	GetComment(boardId uuid.UUID, commentId uuid.UUID) (*models.Comment, error)
+	GetThreads(boardId uuid.UUID, filter CommentFilter) ([]models.Comment, error)
+	UpdateComment(boardId uuid.UUID, commentId uuid.UUID, updates map[string]interface{}) error
+	DeleteComment(boardId uuid.UUID, commentId uuid.UUID) error
+}
+
+func NewCommentRepository(db *gorm.DB) CommentRepoInterface {
+	return &CommentRepo{db: db}
+}
+
+// CreateComment creates a comment or a reply
+func (r *CommentRepo) CreateComment(comment *models.Comment) (uuid.UUID, error) {
+	comment.UUID = uuid.New()
+	comment.CreatedAt = time.Now()
+	comment.UpdatedAt = time.Now()
+	err := r.db.Create(comment).Error
+	return comment.UUID, err
+}
+
+// GetComment returns a comment of a board
+func (r *CommentRepo) GetComment(boardId uuid.UUID, commentId uuid.UUID) (*models.Comment, error) {
+	var comment models.Comment
+	err := r.db.Where("uuid = ? AND board_uuid = ?", commentId, boardId).First(&comment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// GetThreads returns the threads of a board, oldest first, each with its replies
+func (r *CommentRepo) GetThreads(boardId uuid.UUID, filter CommentFilter) ([]models.Comment, error) {
+	query := r.db.Where("board_uuid = ? AND parent_uuid IS NULL", boardId)
+	if filter.Resolved != nil {
+		query = query.Where("resolved = ?", *filter.Resolved)
+	}
+	if filter.ShapeId != "" {
+		query = query.Where("shape_id = ?", filter.ShapeId)
+	}
+	if filter.Mention != "" {
+		mention, err := json.Marshal([]string{filter.Mention})
+		if err != nil {
+			return nil, err
+		}
+		// threads where any comment mentions the name
+		query = query.Where(
+			"uuid IN (?)",
+			r.db.Model(&models.Comment{}).
+				Select("COALESCE(parent_uuid, uuid)").
+				Where("board_uuid = ? AND mentions @> ?::jsonb", boardId, string(mention)),
+		)
+	}
+
+	var threads []models.Comment
+	if err := query.Order("created_at").Find(&threads).Error; err != nil {
+		return nil, err
+	}
+	if len(threads) == 0 {
+		return threads, nil
+	}
+
+	threadIds := make([]uuid.UUID, 0, len(threads))
+	for _, thread := range threads {
+		threadIds = append(threadIds, thread.UUID)
+	}
+	var replies []models.Comment
+	if err := r.db.Where("parent_uuid IN ?", threadIds).Order("created_at").Find(&replies).Error; err != nil {
+		return nil, err
+	}
+	index := make(map[uuid.UUID]int, len(threads))
+	for i, thread := range threads {
+		index[thread.UUID] = i
+	}
+	for _, reply := range replies {
+		i := index[*reply.ParentUUID]
+		threads[i].Replies = append(threads[i].Replies, reply)
+	}
+	return threads, nil
+}
+
+// UpdateComment updates the given columns (body, mentions, resolved...) of a comment
+func (r *CommentRepo) UpdateComment(boardId uuid.UUID, commentId uuid.UUID, updates map[string]interface{}) error {
+	updates["updated_at"] = time.Now()
+	result := r.db.Model(&models.Comment{}).Where("uuid = ? AND board_uuid = ?", commentId, boardId).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteComment deletes a comment, and its replies when it starts a thread
+func (r *CommentRepo) DeleteComment(boardId uuid.UUID, commentId uuid.UUID) error {
+	result := r.db.Where("board_uuid = ? AND (uuid = ? OR parent_uuid = ?)", boardId, commentId, commentId).Delete(&models.Comment{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package repo
+
+import (
+	"encoding/json"
+	"melina-studio-backend/internal/models"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// kinds of searchable content
+const (
+	SearchKindChat  = "chat"  // chat messages
+	SearchKindBoard = "board" // board titles
+	SearchKindShape = "shape" // text shapes on a board
+)
+
+// SearchKinds lists every kind of searchable content
+var SearchKinds = []string{SearchKindChat, SearchKindBoard, SearchKindShape}
+
+// ts_headline marks matches with private use characters, stripped from the text
+// beforehand, which are turned into offsets so snippets stay plain text
+const (
+	highlightStart  = "\uE000"
+	highlightStop   = "\uE001"
+	searchHighlight = "StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", MinWords=8, MaxWords=30, MaxFragments=2, FragmentDelimiter= … "
+)
+
+// SearchResult is a ranked match with a plain text snippet
+type SearchResult struct {
+	Kind       string    `json:"kind"`
+	BoardId    uuid.UUID `json:"board_id"`
+	BoardTitle string    `json:"board_title"`
+	ItemId     uuid.UUID `json:"item_id"` // the chat, board or shape that matched
+	Snippet    string    `json:"snippet"`
+	// matched words in the snippet; empty for semantic matches
+	Highlights []SearchHighlight `gorm:"-" json:"highlights"`
+	Rank       float64           `json:"rank"`
+	CreatedAt  time.Time         `json:"created_at"`
+}
+
+// SearchHighlight is a match in a snippet, as character (code point) offsets: [Start, End)
+type SearchHighlight struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// SearchDocument is a searchable text, used by semantic search
+type SearchDocument struct {
+	Kind       string
+	BoardId    uuid.UUID
+	BoardTitle string
+	ItemId     uuid.UUID
+	Text       string
+	CreatedAt  time.Time
+}
+
+type SearchRepo struct {
+	db *gorm.DB
+}
+
+type SearchRepoInterface interface {
+	FullTextSearch(userId uuid.UUID, query string, kinds []string, limit int) ([]SearchResult, error)
+	GetSearchDocuments(userId uuid.UUID, kinds []string, limit int) ([]SearchDocument, error)
+	GetEmbeddings(model string, contentHashes []string) (map[string][]float32, error)
+	SaveEmbeddings(model string, vectors map[string][]float32) error
+}
+
+func NewSearchRepository(db *gorm.DB) SearchRepoInterface {
+	return &SearchRepo{db: db}
+}
+
+// searchSource describes where a kind of searchable content lives
+type searchSource struct {
+	kind      string
+	text      string // expression of the searched text
+	from      string // FROM ... WHERE, with the user id as the only argument
+	itemId    string
+	createdAt string
+}
+
+// searchSources returns the sources of the requested kinds. Every source is
+// limited to the boards of the user passed as its argument.
+func searchSources(kinds []string) []searchSource {
+	sources := []searchSource{}
+	for _, kind := range kinds {
+		switch kind {
+		case SearchKindChat:
+			sources = append(sources, searchSource{
+				kind, "c.content", "chats c JOIN boards b ON b.u_uuid = c.board_uuid WHERE b.user_id = ?", "c.uuid", "c.created_at",
+			})
+		case SearchKindBoard:
+			sources = append(sources, searchSource{
+				kind, "b.title", "boards b WHERE b.user_id = ?", "b.u_uuid", "b.created_at",
+			})
+		case SearchKindShape:
+			sources = append(sources, searchSource{
+				kind, "coalesce(d.data->>'text', '')", "board_data d JOIN boards b ON b.u_uuid = d.board_id WHERE b.user_id = ? AND d.type = 'text'", "d.uuid", "d.created_at",
+			})
+		}
+	}
+	return sources
+}
+
+// FullTextSearch ranks the user's chats, board titles and text shapes against a
+// web-style query ("onboarding flow", "-draft", "or") using Postgres full-text search
+func (r *SearchRepo) FullTextSearch(userId uuid.UUID, query string, kinds []string, limit int) ([]SearchResult, error) {
+	results := []SearchResult{}
+
+	parts := []string{}
+	args := []interface{}{}
+	for _, source := range searchSources(kinds) {
+		parts = append(parts, `SELECT '`+source.kind+`' AS kind, b.u_uuid AS board_id, b.title AS board_title, `+source.itemId+` AS item_id,
+			ts_rank(to_tsvector('english', `+source.text+`), q.query) AS rank,
+			ts_headline('english', translate(`+source.text+`, ?, ''), q.query, ?) AS snippet,
+			`+source.createdAt+` AS created_at
+			FROM q, `+source.from+` AND to_tsvector('english', `+source.text+`) @@ q.query`)
+		args = append(args, highlightStart+highlightStop, searchHighlight, userId)
+	}
+	if len(parts) == 0 {
+		return results, nil
+	}
+
+	sql := `WITH q AS (SELECT websearch_to_tsquery('english', ?) AS query)
+		SELECT * FROM (` + strings.Join(parts, " UNION ALL ") + `) AS matches
+		ORDER BY rank DESC, created_at DESC
+		LIMIT ?`
+	args = append([]interface{}{query}, args...)
+	args = append(args, limit)
+
+	if err := r.db.Raw(sql, args...).Scan(&results).Error; err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].Snippet, results[i].Highlights = splitHighlights(results[i].Snippet)
+	}
+	return results, nil
+}
+
+// splitHighlights removes the match markers from a headline and returns where they were
+func splitHighlights(headline string) (string, []SearchHighlight) {
+	var text strings.Builder
+	highlights := []SearchHighlight{}
+	pos := 0
+	for _, r := range headline {
+		switch string(r) {
+		case highlightStart:
+			highlights = append(highlights, SearchHighlight{Start: pos, End: pos})
+		case highlightStop:
+			if len(highlights) > 0 {
+				highlights[len(highlights)-1].End = pos
+			}
+		default:
+			text.WriteRune(r)
+			pos++
+		}
+	}
+	return text.String(), highlights
+}
+
+// GetSearchDocuments returns the most recent searchable texts of the user's boards
+func (r *SearchRepo) GetSearchDocuments(userId uuid.UUID, kinds []string, limit int) ([]SearchDocument, error) {
+	documents := []SearchDocument{}
+
+	parts := []string{}
+	args := []interface{}{}
+	for _, source := range searchSources(kinds) {
+		parts = append(parts, `SELECT '`+source.kind+`' AS kind, b.u_uuid AS board_id, b.title AS board_title, `+source.itemId+` AS item_id,
+			`+source.text+` AS text, `+source.createdAt+` AS created_at
+			FROM `+source.from+` AND `+source.text+` <> ''`)
+		args = append(args, userId)
+	}
+	if len(parts) == 0 {
+		return documents, nil
+	}
+
+	sql := `SELECT * FROM (` + strings.Join(parts, " UNION ALL ") + `) AS documents
+		ORDER BY created_at DESC
+		LIMIT ?`
+	args = append(args, limit)
+
+	err := r.db.Raw(sql, args...).Scan(&documents).Error
+	return documents, err
+}
+
+// GetEmbeddings returns the cached vectors of a model by content hash
+func (r *SearchRepo) GetEmbeddings(model string, contentHashes []string) (map[string][]float32, error) {
+	vectors := map[string][]float32{}
+	if len(contentHashes) == 0 {
+		return vectors, nil
+	}
+
+	var embeddings []models.SearchEmbedding
+	if err := r.db.Where("model = ? AND content_hash IN ?", model, contentHashes).Find(&embeddings).Error; err != nil {
+		return nil, err
+	}
+	for _, embedding := range embeddings {
+		var vector []float32
+		if err := json.Unmarshal(embedding.Vector, &vector); err != nil {
+			continue
+		}
+		vectors[embedding.ContentHash] = vector
+	}
+	return vectors, nil
+}
+
+// SaveEmbeddings caches vectors of a model by content hash
+func (r *SearchRepo) SaveEmbeddings(model string, vectors map[string][]float32) error {
+	if len(vectors) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	embeddings := make([]models.SearchEmbedding, 0, len(vectors))
+	for hash, vector := range vectors {
+		data, err := json.Marshal(vector)
+		if err != nil {
+			return err
+		}
+		embeddings = append(embeddings, models.SearchEmbedding{
+			ContentHash: hash,
+			Model:       model,
+			Vector:      datatypes.JSON(data),
+			CreatedAt:   now,
+		})
+	}
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&embeddings).Error
+}
@@ -0,0 +1,71 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"melina-studio-backend/internal/models"
+	"strings"
+)
+
+// longer tool inputs are truncated in the tool trail
+const maxTrailInputLen = 120
+
+// toolTrailSummary renders tool calls as one compact line each, e.g.
+// "- addShape {"type":"rect",...} -> shape 3f2a..."
+func toolTrailSummary(toolCalls []models.ChatToolCall) string {
+	if len(toolCalls) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("[tools used]")
+	for _, tc := range toolCalls {
+		input := strings.TrimSpace(string(tc.Input))
+		if runes := []rune(input); len(runes) > maxTrailInputLen {
+			input = string(runes[:maxTrailInputLen]) + "..."
+		}
+		b.WriteString(fmt.Sprintf("\n- %s %s -> %s", tc.ToolName, input, toolTrailOutcome(tc)))
+	}
+	return b.String()
+}
+
+// toolTrailOutcome describes the result of a tool call in a few words
+func toolTrailOutcome(tc models.ChatToolCall) string {
+	if tc.Error != "" {
+		return "failed: " + tc.Error
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(tc.Result, &result); err == nil {
+		if shapeId, ok := result["shapeId"].(string); ok && shapeId != "" {
+			return "shape " + shapeId
+		}
+		if shapeIds, ok := result["shapeIds"].([]interface{}); ok && len(shapeIds) > 0 {
+			return fmt.Sprintf("%d shapes", len(shapeIds))
+		}
+	}
+	return "ok"
+}
+
+// appendAttachmentNotes mentions the images of earlier messages; only the current
+// message sends its images, so history doesn't resend them every turn
+func appendAttachmentNotes(chats []models.Chat) {
+	for i := range chats {
+		if len(chats[i].Attachments) == 0 {
+			continue
+		}
+		var refs []models.AttachmentRef
+		if err := json.Unmarshal(chats[i].Attachments, &refs); err != nil || len(refs) == 0 {
+			continue
+		}
+		names := make([]string, 0, len(refs))
+		for _, ref := range refs {
+			name := ref.Filename
+			if name == "" {
+				name = ref.MediaType
+			}
+			names = append(names, name)
+		}
+		chats[i].Content += fmt.Sprintf("\n\n[attached images: %s]", strings.Join(names, ", "))
+	}
+}